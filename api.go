@@ -0,0 +1,176 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiRun summarizes a run in the history DB.
+type apiRun struct {
+	ID      string    `json:"id"`
+	Start   time.Time `json:"start"`
+	Tests   int       `json:"tests"`
+	Passed  int       `json:"passed"`
+	Failed  int       `json:"failed"`
+	Skipped int       `json:"skipped"`
+
+	// Duration is the total test time in seconds.
+	Duration float64 `json:"duration"`
+}
+
+// historyAPI serves the history DB at path as JSON:
+//
+//	/api/runs                  the runs, newest first (?limit=N)
+//	/api/runs/ID/tests         the tests of a run
+//	/api/tests/NAME/history    the runs of a test, oldest first (?variant=V)
+//
+// The DB is read afresh for each request, as runs append to it.
+func historyAPI(path string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/runs", func(w http.ResponseWriter, req *http.Request) {
+		entries, err := readHistoryEntries(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		runs := historyRuns(entries)
+		if n, err := strconv.Atoi(req.URL.Query().Get("limit")); err == nil && n >= 0 && n < len(runs) {
+			runs = runs[:n]
+		}
+		writeJSON(w, runs)
+	})
+	mux.HandleFunc("/api/runs/", func(w http.ResponseWriter, req *http.Request) {
+		id := strings.TrimPrefix(req.URL.Path, "/api/runs/")
+		if !strings.HasSuffix(id, "/tests") {
+			http.NotFound(w, req)
+			return
+		}
+		id = strings.TrimSuffix(id, "/tests")
+		entries, err := readHistoryEntries(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var tests []historyEntry
+		for _, e := range entries {
+			if e.Run == id {
+				tests = append(tests, e)
+			}
+		}
+		if len(tests) == 0 {
+			http.Error(w, "no run "+id, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, tests)
+	})
+	mux.HandleFunc("/api/tests/", func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, "/api/tests/")
+		if !strings.HasSuffix(name, "/history") {
+			http.NotFound(w, req)
+			return
+		}
+		name = strings.TrimSuffix(name, "/history")
+		variant, filter := req.URL.Query()["variant"]
+		entries, err := readHistoryEntries(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var runs []historyEntry
+		for _, e := range entries {
+			if e.Name != name && strings.TrimSuffix(e.Name, ".sh") != name && testID(e.Name) != name {
+				continue
+			}
+			if filter && e.Variant != variant[0] {
+				continue
+			}
+			runs = append(runs, e)
+		}
+		if len(runs) == 0 {
+			http.Error(w, "no history for "+name, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, runs)
+	})
+	return mux
+}
+
+// readHistoryEntries reads all of the history DB. A missing file has
+// no entries.
+func readHistoryEntries(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []historyEntry
+	err = scanHistory(f, func(e historyEntry) {
+		entries = append(entries, e)
+	})
+	return entries, err
+}
+
+// historyRuns groups the entries by run, newest first. Entries written
+// before runs were recorded are left out.
+func historyRuns(entries []historyEntry) []*apiRun {
+	byID := map[string]*apiRun{}
+	runs := []*apiRun{}
+	for _, e := range entries {
+		if e.Run == "" {
+			continue
+		}
+		r := byID[e.Run]
+		if r == nil {
+			r = &apiRun{ID: e.Run, Start: e.Time}
+			byID[e.Run] = r
+			runs = append(runs, r)
+		}
+		if e.Time.Before(r.Start) {
+			r.Start = e.Time
+		}
+		r.Tests++
+		r.Duration += e.Duration
+		switch e.Status {
+		case statusOK:
+			r.Passed++
+		case statusSkipped:
+			r.Skipped++
+		default:
+			r.Failed++
+		}
+	}
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	return runs
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// priorRun holds the results an --append run found in its output dir.
+type priorRun struct {
+	results []*result
+
+	// ids are the IDs of the runs the results are from.
+	ids []string
+}
+
+// loadPriorRun reads the results kept in the store, if there are any.
+func loadPriorRun(store resultStore) (*priorRun, error) {
+	run, err := store.load()
+	if os.IsNotExist(err) {
+		return &priorRun{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	p := &priorRun{ids: run.Appended}
+	if run.ID != "" {
+		p.ids = append(p.ids, run.ID)
+	}
+	variants := map[string]*variant{}
+	for i := range run.Tests {
+		p.results = append(p.results, run.Tests[i].result(variants))
+	}
+	return p, nil
+}
+
+func resultKey(r *result) string {
+	return fmt.Sprintf("%s #%d", r.label(), r.iteration)
+}
+
+// merged returns rr with the prior results of the tests it did not run
+// again added in front.
+func (p *priorRun) merged(rr *runResults) *runResults {
+	if p == nil || len(p.results) == 0 {
+		return rr
+	}
+	ran := map[string]bool{}
+	for _, r := range rr.results {
+		ran[resultKey(r)] = true
+	}
+	m := *rr
+	m.results = nil
+	for _, r := range p.results {
+		if !ran[resultKey(r)] {
+			m.results = append(m.results, r)
+		}
+	}
+	m.results = append(m.results, rr.results...)
+	m.appended = p.ids
+	return &m
+}
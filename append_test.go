@@ -0,0 +1,93 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// memStore is a resultStore keeping results.json in memory.
+type memStore struct {
+	data  []byte
+	saves int
+}
+
+func (m *memStore) save(rr *runResults) error {
+	data, err := rr.resultsJSON()
+	if err != nil {
+		return err
+	}
+	m.data = data
+	m.saves++
+	return nil
+}
+
+func (m *memStore) load() (*jsonRun, error) {
+	if m.data == nil {
+		return nil, os.ErrNotExist
+	}
+	var run jsonRun
+	if err := json.Unmarshal(m.data, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func TestAppend(t *testing.T) {
+	store := &memStore{}
+	prior, err := loadPriorRun(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &runResults{id: "run1", results: []*result{
+		{name: "t1.sh", status: statusFail, firstOutput: -1},
+		{name: "t2.sh", status: statusOK, firstOutput: -1},
+	}}
+	if err := store.save(prior.merged(first)); err != nil {
+		t.Fatal(err)
+	}
+
+	if prior, err = loadPriorRun(store); err != nil {
+		t.Fatal(err)
+	}
+	second := &runResults{id: "run2", results: []*result{
+		{name: "t1.sh", status: statusOK, firstOutput: -1},
+		{name: "t3.sh", status: statusSkipped, firstOutput: -1},
+	}}
+	if err := store.save(prior.merged(second)); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, tr := range run.Tests {
+		got = append(got, tr.Name+" "+tr.Status)
+	}
+	if want := []string{"t2.sh ok", "t1.sh ok", "t3.sh skipped"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tests %q, want %q", got, want)
+	}
+	if run.ID != "run2" || !reflect.DeepEqual(run.Appended, []string{"run1"}) {
+		t.Errorf("ID %q, appended %q, want run2 after run1", run.ID, run.Appended)
+	}
+	if store.saves != 2 {
+		t.Errorf("%d saves, want 2", store.saves)
+	}
+}
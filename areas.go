@@ -0,0 +1,98 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// areaNames are the areas of git's test numbering, by the first digit,
+// as described in t/README.
+var areaNames = [10]string{
+	"basics and global stuff",
+	"object database commands",
+	"working tree commands",
+	"other basic commands",
+	"diff commands",
+	"pull and exporting commands",
+	"revision tree commands",
+	"porcelain: working tree",
+	"porcelain: forensics",
+	"git tools",
+}
+
+// testArea returns the area of a test, eg. "t5" for t5510-fetch.sh, or
+// "other" for scripts not following the numbering.
+func testArea(name string) string {
+	id := testID(name)
+	if len(id) == 5 && id[0] == 't' && id[1] >= '0' && id[1] <= '9' {
+		return id[:2]
+	}
+	return "other"
+}
+
+type areaStats struct {
+	tests, passed, failed, skipped int
+	duration                       time.Duration
+}
+
+// areaReport tabulates the results per area, with pass rates over the
+// tests that were not skipped and the total test time.
+func areaReport(results []*result) string {
+	stats := map[string]*areaStats{}
+	for _, r := range results {
+		a := testArea(r.name)
+		st := stats[a]
+		if st == nil {
+			st = &areaStats{}
+			stats[a] = st
+		}
+		st.tests++
+		st.duration += r.duration
+		switch {
+		case r.failed():
+			st.failed++
+		case r.status == statusSkipped:
+			st.skipped++
+		case r.status == statusOK || r.status == statusSuspect:
+			st.passed++
+		}
+	}
+	var areas []string
+	for a := range stats {
+		areas = append(areas, a)
+	}
+	// "other" sorts after the t areas.
+	sort.Strings(areas)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%-34s %6s %6s %6s %7s %9s %10s\n", "area", "tests", "passed", "failed", "skipped", "pass rate", "duration")
+	for _, a := range areas {
+		st := stats[a]
+		name := a
+		if a != "other" {
+			name = fmt.Sprintf("%sxxx %s", a, areaNames[a[1]-'0'])
+		}
+		rate := "-"
+		if n := st.tests - st.skipped; n > 0 {
+			rate = fmt.Sprintf("%.1f%%", 100*float64(st.passed)/float64(n))
+		}
+		fmt.Fprintf(&buf, "%-34s %6d %6d %6d %7d %9s %10s\n", name, st.tests, st.passed, st.failed, st.skipped, rate, reportDuration(st.duration))
+	}
+	return buf.String()
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// artifactDir returns the directory given to a test as
+// RUNGITTEST_ARTIFACT_DIR, for files to keep for post-mortems.
+func artifactDir(outdir, logFile string) string {
+	dir, err := filepath.Abs(filepath.Join(outdir, "artifacts", strings.TrimSuffix(logFile, ".log")))
+	if err != nil {
+		// Only if the working directory is gone.
+		fatalf("%v", err)
+	}
+	return dir
+}
+
+// collectArtifacts returns the files in the artifact directory of a
+// test, relative to outdir. The directory is removed if it is empty.
+func collectArtifacts(outdir, dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		abs, err := filepath.Abs(outdir)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(abs, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return files, err
+	}
+	if len(files) == 0 {
+		os.RemoveAll(dir)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// pruneArtifacts removes the empty directories left under artifacts/
+// once all tests have finished, and home/ if no test left its home.
+func pruneArtifacts(outdir string) {
+	root := filepath.Join(outdir, "artifacts")
+	var dirs []string
+	filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	// Children come after their parents.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	os.Remove(filepath.Join(outdir, "home"))
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minAutoTimeout is the smallest timeout --auto-timeout sets, so fast
+// tests are not killed by a hiccup of the machine.
+const minAutoTimeout = 5 * time.Second
+
+// autoTimeout derives the timeout of each test from the durations of
+// its passing runs in the history: a percentile times a factor.
+type autoTimeout struct {
+	percentile float64
+	factor     float64
+}
+
+// parseAutoTimeout parses specs like "p99x3".
+func parseAutoTimeout(spec string) (*autoTimeout, error) {
+	bad := fmt.Errorf("want pNxF, eg. p99x3, got %q", spec)
+	x := strings.Index(spec, "x")
+	if !strings.HasPrefix(spec, "p") || x < 0 {
+		return nil, bad
+	}
+	p, err1 := strconv.ParseFloat(spec[1:x], 64)
+	f, err2 := strconv.ParseFloat(spec[x+1:], 64)
+	if err1 != nil || err2 != nil || p <= 0 || p > 100 || f <= 0 {
+		return nil, bad
+	}
+	return &autoTimeout{percentile: p, factor: f}, nil
+}
+
+// percentile returns the p-th percentile (nearest rank) of the
+// durations of the recent passing runs of a test.
+func (h *history) percentile(label string, p float64) (time.Duration, bool) {
+	var ds []float64
+	for _, e := range h.runs[label] {
+		if e.Status == statusOK {
+			ds = append(ds, e.Duration)
+		}
+	}
+	if len(ds) == 0 {
+		return 0, false
+	}
+	sort.Float64s(ds)
+	i := int(math.Ceil(p/100*float64(len(ds)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return time.Duration(ds[i] * float64(time.Second)), true
+}
+
+// timeouts returns the timeout for each test that has passing runs in
+// the history.
+func (a *autoTimeout) timeouts(h *history, jobs []*job) map[string]time.Duration {
+	m := map[string]time.Duration{}
+	for _, j := range jobs {
+		d, ok := h.percentile(j.label(), a.percentile)
+		if !ok {
+			continue
+		}
+		t := time.Duration(float64(d) * a.factor).Round(time.Second)
+		if t < minAutoTimeout {
+			t = minAutoTimeout
+		}
+		m[j.label()] = t
+	}
+	return m
+}
+
+// timeoutFor returns the timeout of a job: the one derived by
+// --auto-timeout if there is one, else --timeout.
+func (o *options) timeoutFor(j *job) time.Duration {
+	if t, ok := o.timeouts[j.label()]; ok {
+		return t
+	}
+	return o.timeout
+}
@@ -0,0 +1,145 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"time"
+)
+
+// health summarizes how well a run went, for a badge.
+type health struct {
+	// Score is 0 to 100, see healthOf.
+	Score     int     `json:"score"`
+	PassRate  float64 `json:"pass_rate"`
+	FlakyRate float64 `json:"flaky_rate"`
+	Tests     int     `json:"tests"`
+	Failed    int     `json:"failed"`
+	Flaky     int     `json:"flaky"`
+	RunID     string  `json:"run_id,omitempty"`
+	Start     string  `json:"start,omitempty"`
+	Label     string  `json:"label"`
+	Message   string  `json:"message"`
+	Color     string  `json:"color"`
+
+	// DurationTrend is the test time of the run over that of the
+	// same tests in earlier runs in the history, or 0 if there are
+	// none.
+	DurationTrend float64 `json:"duration_trend,omitempty"`
+}
+
+// healthOf scores a run: the pass rate in percent over the tests that
+// were not skipped (0 if all were), less 2 points per percent of flaky tests and 1
+// point per 2 percent that the tests got slower than in the earlier
+// runs of the history DB (if h is not nil), at most 25.
+func healthOf(rr *runResults, h *history) health {
+	var st health
+	var total, before time.Duration
+	for _, r := range rr.results {
+		if r.status == statusSkipped || r.status == statusCancelled {
+			continue
+		}
+		st.Tests++
+		if r.failed() {
+			st.Failed++
+		}
+		if r.flaky() {
+			st.Flaky++
+		}
+		if h == nil || r.status != statusOK {
+			continue
+		}
+		if d, ok := h.durationBefore(r.label(), rr.start); ok {
+			total += r.duration
+			before += d
+		}
+	}
+	// A run that tested nothing is no sign of health.
+	score := 0.0
+	if st.Tests > 0 {
+		st.PassRate = float64(st.Tests-st.Failed) / float64(st.Tests)
+		st.FlakyRate = float64(st.Flaky) / float64(st.Tests)
+		score = 100*st.PassRate - 200*st.FlakyRate
+	}
+	if before > 0 {
+		st.DurationTrend = float64(total) / float64(before)
+		if slower := 100 * (st.DurationTrend - 1); slower > 0 {
+			score -= math.Min(slower/2, 25)
+		}
+	}
+	st.Score = int(math.Round(math.Max(0, math.Min(100, score))))
+	st.RunID = rr.id
+	if !rr.start.IsZero() {
+		st.Start = rr.start.Format(time.RFC3339)
+	}
+	st.Label = "git tests"
+	st.Message = fmt.Sprintf("%d/100", st.Score)
+	switch {
+	case st.Score >= 90:
+		st.Color = "#4c1"
+	case st.Score >= 75:
+		st.Color = "#dfb317"
+	case st.Score >= 50:
+		st.Color = "#fe7d37"
+	default:
+		st.Color = "#e05d44"
+	}
+	return st
+}
+
+// durationBefore estimates how long the test took from its passing
+// runs in the history that started before t.
+func (h *history) durationBefore(label string, t time.Time) (time.Duration, bool) {
+	var sum float64
+	n := 0
+	for _, e := range h.runs[label] {
+		if e.Status == statusOK && (t.IsZero() || e.Time.Before(t)) {
+			sum += e.Duration
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return time.Duration(sum / float64(n) * float64(time.Second)), true
+}
+
+func (st health) json() ([]byte, error) {
+	data, err := json.MarshalIndent(st, "", "  ")
+	return append(data, '\n'), err
+}
+
+// svg renders the badge in the usual flat style, with the widths
+// estimated from the length of the text.
+func (st health) svg() []byte {
+	const charWidth, pad = 7, 6
+	lw := len(st.Label)*charWidth + 2*pad
+	mw := len(st.Message)*charWidth + 2*pad
+	w := lw + mw
+	label, msg := html.EscapeString(st.Label), html.EscapeString(st.Message)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<rect width="%[2]d" height="20" fill="#555"/>
+<rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, w, lw, mw, label, msg, st.Color, lw/2, lw+mw/2))
+}
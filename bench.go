@@ -0,0 +1,160 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// benchJobs returns warmup+n jobs per test, interleaved so all tests
+// run their first iteration before any test runs its second.
+func benchJobs(names []string, warmup, n int) []*job {
+	var jobs []*job
+	for i := 1; i <= warmup+n; i++ {
+		for _, nm := range names {
+			jobs = append(jobs, &job{name: nm, iteration: i})
+		}
+	}
+	return jobs
+}
+
+type benchStat struct {
+	name    string
+	samples []time.Duration
+	failed  int
+
+	min, median, mean, stddev time.Duration
+}
+
+// measured returns the successful, measured durations per test.
+func measured(results []*result, warmup int) (map[string][]time.Duration, map[string]int) {
+	samples := map[string][]time.Duration{}
+	failed := map[string]int{}
+	for _, r := range results {
+		if r.iteration <= warmup {
+			continue
+		}
+		if r.status != statusOK {
+			failed[r.label()]++
+			continue
+		}
+		samples[r.label()] = append(samples[r.label()], r.duration)
+	}
+	return samples, failed
+}
+
+func benchStats(results []*result, warmup int) []*benchStat {
+	samples, failed := measured(results, warmup)
+	names := map[string]bool{}
+	for n := range samples {
+		names[n] = true
+	}
+	for n := range failed {
+		names[n] = true
+	}
+
+	var stats []*benchStat
+	for n := range names {
+		st := &benchStat{name: n, samples: samples[n], failed: failed[n]}
+		stats = append(stats, st)
+		if len(st.samples) == 0 {
+			continue
+		}
+		ds := append([]time.Duration{}, st.samples...)
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		st.min = ds[0]
+		st.median = ds[len(ds)/2]
+		if len(ds)%2 == 0 {
+			st.median = (ds[len(ds)/2-1] + ds[len(ds)/2]) / 2
+		}
+		var sum float64
+		for _, d := range ds {
+			sum += float64(d)
+		}
+		mean := sum / float64(len(ds))
+		var sq float64
+		for _, d := range ds {
+			sq += (float64(d) - mean) * (float64(d) - mean)
+		}
+		st.mean = time.Duration(mean)
+		if len(ds) > 1 {
+			st.stddev = time.Duration(math.Sqrt(sq / float64(len(ds)-1)))
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].name < stats[j].name })
+	return stats
+}
+
+func formatBenchStats(stats []*benchStat) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%-30s %4s %10s %10s %10s %10s\n", "test", "runs", "min", "median", "mean", "stddev")
+	for _, st := range stats {
+		fmt.Fprintf(&buf, "%-30s %4d %10s %10s %10s %10s", st.name, len(st.samples),
+			st.min.Round(time.Millisecond), st.median.Round(time.Millisecond),
+			st.mean.Round(time.Millisecond), st.stddev.Round(time.Microsecond))
+		if st.failed > 0 {
+			fmt.Fprintf(&buf, " (%d failed)", st.failed)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// benchName turns "t0001-init.sh" into "BenchmarkT0001_init".
+func benchName(r *result) string {
+	base := strings.TrimSuffix(filepath.Base(r.name), ".sh")
+	if r.variant != nil {
+		base += "_" + r.variant.name
+	}
+	base = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '=', ',', '/':
+			return '_'
+		}
+		return r
+	}, base)
+	return "Benchmark" + strings.ToUpper(base[:1]) + base[1:]
+}
+
+// writeBenchFile writes the measured durations, one line per run, in
+// the format understood by benchstat.
+func writeBenchFile(path string, results []*result, warmup int) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "goos: %s\ngoarch: %s\npkg: rungittest\n", runtime.GOOS, runtime.GOARCH)
+	var sorted []*result
+	for _, r := range results {
+		if r.iteration > warmup && r.status == statusOK {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].label() != sorted[j].label() {
+			return sorted[i].label() < sorted[j].label()
+		}
+		return sorted[i].iteration < sorted[j].iteration
+	})
+	for _, r := range sorted {
+		fmt.Fprintf(&buf, "%s 1 %d ns/op\n", benchName(r), r.duration.Nanoseconds())
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readBenchFile reads the ns/op samples from a benchstat format file,
+// or from bench.txt if path is a directory.
+func readBenchFile(path string) (map[string][]float64, error) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, "bench.txt")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	samples := map[string][]float64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		for i := 2; i+1 < len(fields); i += 2 {
+			if fields[i+1] != "ns/op" {
+				continue
+			}
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			samples[fields[0]] = append(samples[fields[0]], v)
+		}
+	}
+	return samples, scanner.Err()
+}
+
+func median(vs []float64) float64 {
+	s := append([]float64{}, vs...)
+	sort.Float64s(s)
+	if len(s)%2 == 0 {
+		return (s[len(s)/2-1] + s[len(s)/2]) / 2
+	}
+	return s[len(s)/2]
+}
+
+// benchcmpMain implements "rungittest benchcmp OLD NEW", which
+// compares the durations of two --bench runs.
+func benchcmpMain(args []string) {
+	fs := flag.NewFlagSet("benchcmp", flag.ExitOnError)
+	alpha := fs.Float64("alpha", 0.05, "significance level")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest benchcmp [flags] OLD NEW\n\n"+
+			"OLD and NEW are --bench output dirs or bench.txt files. Exits with status 1\n"+
+			"if there are statistically significant regressions.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	old, err := readBenchFile(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	cur, err := readBenchFile(fs.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var names []string
+	for n := range old {
+		if _, ok := cur[n]; ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	var regressions []string
+	fmt.Printf("%-35s %10s %10s %8s %8s\n", "name", "old", "new", "delta", "p")
+	for _, n := range names {
+		o, c := median(old[n]), median(cur[n])
+		p := mannWhitney(old[n], cur[n])
+		delta := "~"
+		if p < *alpha {
+			delta = fmt.Sprintf("%+.1f%%", 100*(c-o)/o)
+			if c > o {
+				regressions = append(regressions, strings.TrimPrefix(n, "Benchmark"))
+			}
+		}
+		fmt.Printf("%-35s %10s %10s %8s %8.3f (n=%d+%d)\n", strings.TrimPrefix(n, "Benchmark"),
+			time.Duration(o).Round(time.Millisecond), time.Duration(c).Round(time.Millisecond),
+			delta, p, len(old[n]), len(cur[n]))
+	}
+
+	if len(regressions) > 0 {
+		fmt.Printf("\n%d significant regressions (p < %g):\n  %s\n", len(regressions), *alpha,
+			strings.Join(regressions, "\n  "))
+		os.Exit(1)
+	}
+}
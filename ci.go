@@ -0,0 +1,157 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reporter is notified as tests start and finish. Calls may come
+// from multiple goroutines.
+type reporter interface {
+	started(name string)
+	finished(r *result)
+	done()
+}
+
+type multiReporter []reporter
+
+func (m multiReporter) started(name string) {
+	for _, r := range m {
+		r.started(name)
+	}
+}
+
+func (m multiReporter) finished(res *result) {
+	for _, r := range m {
+		r.finished(res)
+	}
+}
+
+func (m multiReporter) done() {
+	for _, r := range m {
+		r.done()
+	}
+}
+
+var teamcityEscaper = strings.NewReplacer(
+	"|", "||", "'", "|'", "\n", "|n", "\r", "|r", "[", "|[", "]", "|]")
+
+// teamcityReporter emits TeamCity service messages.
+type teamcityReporter struct {
+	mu sync.Mutex
+}
+
+func newTeamcityReporter() *teamcityReporter {
+	t := &teamcityReporter{}
+	t.message("testSuiteStarted", "name", "rungittest")
+	return t
+}
+
+func (t *teamcityReporter) message(name string, kv ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	msg := "##teamcity[" + name
+	for i := 0; i+1 < len(kv); i += 2 {
+		msg += fmt.Sprintf(" %s='%s'", kv[i], teamcityEscaper.Replace(kv[i+1]))
+	}
+	fmt.Println(msg + "]")
+}
+
+func (t *teamcityReporter) started(name string) {
+	t.message("testStarted", "name", name, "captureStandardOutput", "false")
+}
+
+func (t *teamcityReporter) finished(r *result) {
+	switch {
+	case r.failed():
+		msg := r.summary
+		if r.known() {
+			msg += " (" + r.knownText() + ")"
+		}
+		t.message("testFailed", "name", r.label(), "message", msg, "details", r.excerpt)
+	case r.status == statusSkipped:
+		t.message("testIgnored", "name", r.label(), "message", r.summary)
+	}
+	t.message("testFinished", "name", r.label(),
+		"duration", fmt.Sprintf("%d", r.duration/time.Millisecond))
+}
+
+func (t *teamcityReporter) done() {
+	t.message("testSuiteFinished", "name", "rungittest")
+}
+
+var azurePropertyEscaper = strings.NewReplacer(
+	"%", "%AZP25", ";", "%3B", "\r", "%0D", "\n", "%0A", "]", "%5D")
+
+var azureMessageEscaper = strings.NewReplacer(
+	"%", "%AZP25", "\r", "%0D", "\n", "%0A", "##vso[", "# #vso[", "##[", "# #[")
+
+// azureTextEscaper breaks up logging commands in test output, which
+// the agent would otherwise run.
+var azureTextEscaper = strings.NewReplacer("##vso[", "# #vso[", "##[", "# #[")
+
+// azureReporter emits Azure DevOps logging commands.
+type azureReporter struct {
+	mu sync.Mutex
+
+	// total counts the tests to run, and the reruns once they
+	// finish, so the progress stays within 100%.
+	total    int
+	finishes int
+}
+
+func newAzureReporter(total int) *azureReporter {
+	return &azureReporter{total: total}
+}
+
+func (a *azureReporter) started(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fmt.Printf("##[debug]started %s\n", name)
+}
+
+func (a *azureReporter) finished(r *result) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.finishes++
+	if r.attempt > 0 {
+		a.total++
+	}
+	switch {
+	case r.failed():
+		msg := r.summary
+		if r.known() {
+			msg += " (" + r.knownText() + ")"
+		}
+		fmt.Printf("##vso[task.logissue type=error;sourcepath=%s]%s\n",
+			azurePropertyEscaper.Replace(r.name), azureMessageEscaper.Replace(msg))
+		fmt.Printf("##[group]%s\n%s\n##[endgroup]\n",
+			azureTextEscaper.Replace(r.label()+": "+r.summary), azureTextEscaper.Replace(r.excerpt))
+	case r.status == statusSkipped:
+		fmt.Printf("##vso[task.logissue type=warning;sourcepath=%s]%s\n",
+			azurePropertyEscaper.Replace(r.name), azureMessageEscaper.Replace(r.summary))
+	}
+	pct := 100
+	if a.total > 0 {
+		pct = 100 * a.finishes / a.total
+	}
+	fmt.Printf("##vso[task.setprogress value=%d;]%d/%d tests\n", pct, a.finishes, a.total)
+}
+
+func (a *azureReporter) done() {}
@@ -0,0 +1,87 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- data
+	}()
+	f()
+	w.Close()
+	return string(<-out)
+}
+
+// ciResults are finished tests as the CI reporters see them.
+var ciResults = []*result{
+	{name: "t0001-ok.sh", status: statusOK, summary: "ok: # passed all 2 test(s)"},
+	{name: "t0002-fail.sh", status: statusFail, summary: "error: not ok 2 - b", excerpt: "not ok 2 - b"},
+	{name: "t0003-oom.sh", status: statusOOM, summary: "oom: Killed", excerpt: "Killed"},
+	{name: "t0004-svn.sh", status: statusSkipped, summary: "skipped: missing SVN"},
+}
+
+func TestTeamcityReporter(t *testing.T) {
+	for _, r := range ciResults {
+		out := captureStdout(t, func() { (&teamcityReporter{}).finished(r) })
+		failed := strings.Contains(out, "##teamcity[testFailed name='"+r.name+"'")
+		if want := r.status == statusFail || r.status == statusOOM; failed != want {
+			t.Errorf("%s: testFailed %v, want %v in %q", r.name, failed, want, out)
+		}
+		if ignored, want := strings.Contains(out, "##teamcity[testIgnored"), r.status == statusSkipped; ignored != want {
+			t.Errorf("%s: testIgnored %v, want %v in %q", r.name, ignored, want, out)
+		}
+	}
+}
+
+func TestAzureReporter(t *testing.T) {
+	for _, r := range ciResults {
+		out := captureStdout(t, func() { newAzureReporter(len(ciResults)).finished(r) })
+		failed := strings.Contains(out, "##vso[task.logissue type=error;sourcepath="+r.name+"]")
+		if want := r.status == statusFail || r.status == statusOOM; failed != want {
+			t.Errorf("%s: error issue %v, want %v in %q", r.name, failed, want, out)
+		}
+		if warned, want := strings.Contains(out, "type=warning"), r.status == statusSkipped; warned != want {
+			t.Errorf("%s: warning issue %v, want %v in %q", r.name, warned, want, out)
+		}
+	}
+
+	r := &result{name: "t0005-inject.sh", status: statusFail, summary: "error: not ok 1 - ##[error]x",
+		excerpt: "##[endgroup]\n##vso[task.complete result=Succeeded;]done\n ###[section]"}
+	out := captureStdout(t, func() { newAzureReporter(1).finished(r) })
+	if n := strings.Count(out, "##[endgroup]"); n != 1 {
+		t.Errorf("got %d ##[endgroup], want 1 in %q", n, out)
+	}
+	for _, cmd := range []string{"##[error]", "##vso[task.complete", "##[section]"} {
+		if strings.Contains(out, cmd) {
+			t.Errorf("test output command %q not escaped in %q", cmd, out)
+		}
+	}
+}
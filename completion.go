@@ -0,0 +1,260 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// subcommands are the commands dispatched on the first argument.
+var subcommands = []string{"ctl", "benchcmp", "split", "merge", "grep", "show", "report", "serve", "simulate", "prereqs", "debug", "selftest", "completion"}
+
+// flagChoices are the fixed values of flags, for completion.
+var flagChoices = map[string][]string{
+	"order":    {"given", "fail-first", "slow", "fast"},
+	"stdin":    {"null", "inherit"},
+	"coverage": {"gcov", "kcov"},
+	"ansi":     {"strip", "escape", "keep"},
+	"progress": {"auto", "line", "overwrite"},
+}
+
+// flagLists are the flags completed from "completion --list", and what
+// they list.
+var flagLists = map[string]string{
+	"profile":    "profiles",
+	"skip-tests": "tests",
+}
+
+// completionFlag describes a flag of the main command.
+type completionFlag struct {
+	name    string
+	usage   string
+	isBool  bool
+	choices []string
+	list    string
+}
+
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:    f.Name,
+			usage:   strings.SplitN(f.Usage, "\n", 2)[0],
+			isBool:  ok && b.IsBoolFlag(),
+			choices: flagChoices[f.Name],
+			list:    flagLists[f.Name],
+		})
+	})
+	return flags
+}
+
+// testScripts returns the test scripts in the current directory.
+func testScripts() []string {
+	names, _ := filepath.Glob("t[0-9]*.sh")
+	sort.Strings(names)
+	return names
+}
+
+func bashCompletion(flags []completionFlag) string {
+	var all []string
+	var cases []string
+	for _, f := range flags {
+		all = append(all, "--"+f.name)
+		pat := fmt.Sprintf("-%s|--%s", f.name, f.name)
+		switch {
+		case f.list != "":
+			cases = append(cases, fmt.Sprintf("\t%s)\n\t\tCOMPREPLY=($(compgen -W \"$(rungittest completion --list=%s 2>/dev/null)\" -- \"$cur\"))\n\t\treturn;;", pat, f.list))
+		case f.choices != nil:
+			cases = append(cases, fmt.Sprintf("\t%s)\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn;;", pat, strings.Join(f.choices, " ")))
+		case !f.isBool:
+			cases = append(cases, fmt.Sprintf("\t%s)\n\t\treturn;;", pat))
+		}
+	}
+	return fmt.Sprintf(`# bash completion for rungittest; load with
+#   source <(rungittest completion bash)
+
+_rungittest() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+	if [ "$prev" = "=" ] && [ "$COMP_CWORD" -gt 1 ]; then
+		prev="${COMP_WORDS[COMP_CWORD-2]}"
+	elif [ "$cur" = "=" ]; then
+		prev="${COMP_WORDS[COMP_CWORD-1]}"
+		cur=""
+	fi
+	if [ "$COMP_CWORD" -gt 1 ]; then
+		case "${COMP_WORDS[1]}" in
+		%s)
+			return;;
+		esac
+	fi
+	case "$prev" in
+%s
+	esac
+	case "$cur" in
+	-*)
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+		return;;
+	esac
+	local words="$(rungittest completion --list=scripts 2>/dev/null)"
+	if [ "$COMP_CWORD" -eq 1 ]; then
+		words="%s $words"
+	fi
+	COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+
+complete -o default -F _rungittest rungittest
+`, strings.Join(subcommands, "|"), strings.Join(cases, "\n"), strings.Join(all, " "), strings.Join(subcommands, " "))
+}
+
+// zshDescription escapes a description for an _arguments spec inside
+// single quotes.
+func zshDescription(s string) string {
+	return strings.NewReplacer("'", `'\''`, "[", "(", "]", ")", ":", `\:`).Replace(s)
+}
+
+func zshCompletion(flags []completionFlag) string {
+	var specs []string
+	for _, f := range flags {
+		spec := fmt.Sprintf("'--%s", f.name)
+		if !f.isBool {
+			spec += "="
+		}
+		spec += "[" + zshDescription(f.usage) + "]"
+		switch {
+		case f.list != "":
+			spec += fmt.Sprintf(":%s:{compadd -- $(rungittest completion --list=%s 2>/dev/null)}", f.name, f.list)
+		case f.choices != nil:
+			spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(f.choices, " "))
+		case !f.isBool:
+			spec += ":value:_files"
+		}
+		specs = append(specs, spec+"'")
+	}
+	specs = append(specs, `'*:test script:{compadd -- $(rungittest completion --list=scripts 2>/dev/null); _files}'`)
+	return fmt.Sprintf(`#compdef rungittest
+# zsh completion for rungittest; put it in a directory of $fpath as
+# _rungittest, or load with
+#   source <(rungittest completion zsh)
+
+_rungittest() {
+	case $words[2] in
+	(%s)
+		(( CURRENT > 2 )) && return;;
+	esac
+	if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then
+		compadd -- %s
+	fi
+	_arguments -S \
+		%s
+}
+
+if [ "$funcstack[1]" = "_rungittest" ]; then
+	_rungittest "$@"
+else
+	compdef _rungittest rungittest
+fi
+`, strings.Join(subcommands, "|"), strings.Join(subcommands, " "), strings.Join(specs, " \\\n\t\t"))
+}
+
+// fishQuote quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func fishCompletion(flags []completionFlag) string {
+	lines := []string{
+		"# fish completion for rungittest; load with",
+		"#   rungittest completion fish | source",
+		"",
+		fmt.Sprintf("complete -c rungittest -n __fish_use_subcommand -f -a %s", fishQuote(strings.Join(subcommands, " "))),
+		"complete -c rungittest -n __fish_use_subcommand -a '(rungittest completion --list=scripts 2>/dev/null)'",
+	}
+	for _, f := range flags {
+		l := fmt.Sprintf("complete -c rungittest -n 'not __fish_seen_subcommand_from %s' -l %s -d %s",
+			strings.Join(subcommands, " "), f.name, fishQuote(f.usage))
+		switch {
+		case f.list != "":
+			l += fmt.Sprintf(" -x -a '(rungittest completion --list=%s 2>/dev/null)'", f.list)
+		case f.choices != nil:
+			l += " -x -a " + fishQuote(strings.Join(f.choices, " "))
+		case !f.isBool:
+			l += " -r"
+		}
+		lines = append(lines, l)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// completionMain implements "rungittest completion SHELL", which
+// prints a completion script for the flags of the main command.
+func completionMain(args []string, main *flag.FlagSet) {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	list := fs.String("list", "", "print the profiles, tests or scripts available here, one per line, for use by the completion scripts")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest completion [flags] bash|zsh|fish\n\n"+
+			"Prints a completion script for the given shell. Profile and test names are\n"+
+			"completed from the current directory when completing.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	switch *list {
+	case "":
+	case "profiles":
+		cfg, err := readConfigs()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range cfg.profiles() {
+			fmt.Println(p)
+		}
+		return
+	case "scripts":
+		for _, s := range testScripts() {
+			fmt.Println(s)
+		}
+		return
+	case "tests":
+		for _, s := range testScripts() {
+			fmt.Println(strings.TrimSuffix(s, ".sh"))
+		}
+		return
+	default:
+		log.Fatalf("--list: unknown list %q", *list)
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	flags := completionFlags(main)
+	switch fs.Arg(0) {
+	case "bash":
+		fmt.Print(bashCompletion(flags))
+	case "zsh":
+		fmt.Print(zshCompletion(flags))
+	case "fish":
+		fmt.Print(fishCompletion(flags))
+	default:
+		log.Fatalf("unknown shell %q; want bash, zsh or fish", fs.Arg(0))
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds flag defaults from .rungittest.toml, by section and
+// then flag name. Top-level keys are in section "". Values are lists
+// so arrays can set flags that may be given more than once.
+type config map[string]map[string][]string
+
+const configName = ".rungittest.toml"
+
+// parseConfig parses the subset of TOML we need: [sections], and keys
+// with string, number, boolean or string array values.
+func parseConfig(name, data string) (config, error) {
+	cfg := config{"": {}}
+	section := ""
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		n := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s:%d: bad section header", name, n)
+			}
+			section = strings.Trim(strings.TrimSpace(line[1:len(line)-1]), `"`)
+			if cfg[section] == nil {
+				cfg[section] = map[string][]string{}
+			}
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("%s:%d: want key = value", name, n)
+		}
+		key := strings.Trim(strings.TrimSpace(line[:eq]), `"`)
+		value := strings.TrimSpace(line[eq+1:])
+		// Arrays may span lines.
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		values, err := parseValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		cfg[section][key] = values
+	}
+	return cfg, nil
+}
+
+// stripComment removes a '#' comment that is not inside a string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseValue(v string) ([]string, error) {
+	if strings.HasPrefix(v, "[") {
+		if !strings.HasSuffix(v, "]") {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		var values []string
+		for _, e := range splitArray(v[1 : len(v)-1]) {
+			s, err := parseScalar(e)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+	s, err := parseScalar(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+// splitArray splits the elements of an array at commas outside
+// strings.
+func splitArray(s string) []string {
+	var elems []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == ',':
+			elems = append(elems, s[start:i])
+			start = i + 1
+		}
+	}
+	elems = append(elems, s[start:])
+	var out []string
+	for _, e := range elems {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func parseScalar(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", v)
+		}
+		return s, nil
+	case strings.HasPrefix(v, "'"):
+		if len(v) < 2 || !strings.HasSuffix(v, "'") {
+			return "", fmt.Errorf("bad string %s", v)
+		}
+		return v[1 : len(v)-1], nil
+	case v == "true" || v == "false":
+		return v, nil
+	}
+	if _, err := strconv.ParseFloat(strings.Replace(v, "_", "", -1), 64); err != nil {
+		return "", fmt.Errorf("bad value %s", v)
+	}
+	return strings.Replace(v, "_", "", -1), nil
+}
+
+// readConfigs reads .rungittest.toml from $HOME and then from the
+// test directory, so the latter takes precedence.
+func readConfigs() (config, error) {
+	var paths []string
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, configName))
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if p := filepath.Join(wd, configName); len(paths) == 0 || p != paths[0] {
+			paths = append(paths, p)
+		}
+	}
+	merged := config{"": {}}
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		cfg, err := parseConfig(p, string(data))
+		if err != nil {
+			return nil, err
+		}
+		for section, keys := range cfg {
+			if merged[section] == nil {
+				merged[section] = map[string][]string{}
+			}
+			for k, v := range keys {
+				merged[section][k] = v
+			}
+		}
+	}
+	return merged, nil
+}
+
+// loadSettings reads the config files for the current directory and
+// returns the settings of the given profile, or else of the one named
+// by RUNGITTEST_PROFILE or the "profile" setting.
+func loadSettings(profile string) (map[string][]string, error) {
+	cfg, err := readConfigs()
+	if err != nil {
+		return nil, err
+	}
+	if profile == "" {
+		profile = os.Getenv("RUNGITTEST_PROFILE")
+	}
+	if profile == "" && len(cfg[""]["profile"]) > 0 {
+		profile = cfg[""]["profile"][0]
+	}
+	settings, err := cfg.settings(profile)
+	if err != nil {
+		return nil, fmt.Errorf("--profile: %v", err)
+	}
+	return settings, nil
+}
+
+// profiles returns the names of the [profile.NAME] sections.
+func (c config) profiles() []string {
+	var names []string
+	for s := range c {
+		if strings.HasPrefix(s, "profile.") {
+			names = append(names, strings.TrimPrefix(s, "profile."))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// settings returns the top-level settings overlaid with those of the
+// profile, if any.
+func (c config) settings(profile string) (map[string][]string, error) {
+	merged := map[string][]string{}
+	for k, v := range c[""] {
+		merged[k] = v
+	}
+	if profile == "" {
+		return merged, nil
+	}
+	p, ok := c["profile."+profile]
+	if !ok {
+		return nil, fmt.Errorf("no profile %q in %s (have %s)", profile, configName, strings.Join(c.profiles(), ", "))
+	}
+	for k, v := range p {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// applyDefaults sets the flags that were not given on the command
+// line, first from the config section and then from RUNGITTEST_*
+// environment variables, eg. RUNGITTEST_SKIP_TESTS for --skip-tests.
+// For flags that collect values, the variable replaces the values from
+// the file rather than adding to them.
+func applyDefaults(fs *flag.FlagSet, section map[string][]string) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for key, values := range section {
+		name := strings.Replace(key, "_", "-", -1)
+		if given[name] || name == "tests" {
+			continue
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", configName, key)
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("%s: %s: %v", configName, key, err)
+			}
+		}
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := "RUNGITTEST_" + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		v, ok := os.LookupEnv(env)
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		if l, ok := f.Value.(*listFlag); ok {
+			*l = nil
+		}
+		if e := fs.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("%s: %v", env, e)
+		}
+	})
+	return err
+}
+
+// expandOutdir fills in the placeholders of an --outdir template:
+// {date} is the start time as 20060102-150405, and {head} the
+// abbreviated commit of the git checkout we are in.
+func expandOutdir(tmpl string, start time.Time) string {
+	s := strings.Replace(tmpl, "{date}", start.Format("20060102-150405"), -1)
+	if strings.Contains(s, "{head}") {
+		head := "nohead"
+		if out, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output(); err == nil {
+			head = strings.TrimSpace(string(out))
+		}
+		s = strings.Replace(s, "{head}", head, -1)
+	}
+	return s
+}
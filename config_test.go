@@ -0,0 +1,132 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	for _, c := range []struct {
+		data string
+		want config
+		err  string
+	}{
+		{
+			data: "jobs = 16\ntimeout = \"15m\" # comment\nskip-tests = 't9*'\nverbose = true\n",
+			want: config{"": {
+				"jobs":       {"16"},
+				"timeout":    {"15m"},
+				"skip-tests": {"t9*"},
+				"verbose":    {"true"},
+			}},
+		},
+		{
+			data: "env = [\"A=1\",\n  \"B=#2\", # second\n]\n[profile.quick]\ntests = [\"t0*.sh\"]\n",
+			want: config{
+				"":              {"env": {"A=1", "B=#2"}},
+				"profile.quick": {"tests": {"t0*.sh"}},
+			},
+		},
+		{
+			data: "max-output = 1_000\n[\"profile.x\"]\n\"jobs\" = 2\n",
+			want: config{
+				"":          {"max-output": {"1000"}},
+				"profile.x": {"jobs": {"2"}},
+			},
+		},
+		{data: "jobs\n", err: "t.toml:1: want key = value"},
+		{data: "\n[profile\n", err: "t.toml:2: bad section header"},
+		{data: "outdir = /tmp\n", err: "t.toml:1: bad value /tmp"},
+		{data: "env = [\"A=1\"\n", err: "t.toml:1: unterminated array"},
+	} {
+		cfg, err := parseConfig("t.toml", c.data)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("parseConfig(%q): got error %v, want %q", c.data, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseConfig(%q): %v", c.data, err)
+		} else if !reflect.DeepEqual(cfg, c.want) {
+			t.Errorf("parseConfig(%q) = %v, want %v", c.data, cfg, c.want)
+		}
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	for _, k := range []string{"RUNGITTEST_JOBS", "RUNGITTEST_ENV"} {
+		os.Unsetenv(k)
+	}
+	for _, c := range []struct {
+		args    []string
+		section map[string][]string
+		env     map[string]string
+
+		jobs string
+		list []string
+	}{
+		{
+			section: map[string][]string{"jobs": {"4"}, "env": {"A=1", "B=2"}},
+			jobs:    "4",
+			list:    []string{"A=1", "B=2"},
+		},
+		{
+			section: map[string][]string{"jobs": {"4"}, "env": {"A=1", "B=2"}},
+			env:     map[string]string{"RUNGITTEST_JOBS": "8", "RUNGITTEST_ENV": "C=3"},
+			jobs:    "8",
+			list:    []string{"C=3"},
+		},
+		{
+			args:    []string{"--jobs=2", "--env=D=4"},
+			section: map[string][]string{"jobs": {"4"}, "env": {"A=1"}},
+			env:     map[string]string{"RUNGITTEST_JOBS": "8", "RUNGITTEST_ENV": "C=3"},
+			jobs:    "2",
+			list:    []string{"D=4"},
+		},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		jobs := fs.String("jobs", "1", "")
+		var list listFlag
+		fs.Var(&list, "env", "")
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range c.env {
+			os.Setenv(k, v)
+		}
+		err := applyDefaults(fs, c.section)
+		for k := range c.env {
+			os.Unsetenv(k)
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.args, err)
+			continue
+		}
+		if *jobs != c.jobs || !reflect.DeepEqual([]string(list), c.list) {
+			t.Errorf("%q with %v: got jobs %s, env %q, want %s, %q", c.args, c.env, *jobs, list, c.jobs, c.list)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := applyDefaults(fs, map[string][]string{"bogus": {"1"}}); err == nil || !strings.Contains(err.Error(), `unknown setting "bogus"`) {
+		t.Errorf("unknown setting: got %v", err)
+	}
+}
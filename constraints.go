@@ -0,0 +1,99 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// constraint restricts when tests matching a may run relative to
+// tests matching b. The patterns are matched like GIT_SKIP_TESTS.
+type constraint struct {
+	a, kind, b string
+}
+
+const (
+	// Tests matching b start only once all tests matching a have
+	// finished.
+	constraintBefore = "before"
+
+	// Tests matching a never run at the same time as tests
+	// matching b.
+	constraintNotWith = "not-with"
+)
+
+// readConstraints reads a file with lines such as
+//
+//	t5500 before t5510-*
+//	t99* not-with t99*
+//
+// Empty lines and lines starting with '#' are ignored.
+func readConstraints(path string) ([]constraint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cs []constraint
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || (fields[1] != constraintBefore && fields[1] != constraintNotWith) {
+			return nil, fmt.Errorf("%s:%d: want \"A before B\" or \"A not-with B\"", path, n)
+		}
+		cs = append(cs, constraint{a: fields[0], kind: fields[1], b: fields[2]})
+	}
+	return cs, scanner.Err()
+}
+
+func matches(j *job, pattern string) bool {
+	return matchSkip(j.name, []string{pattern})
+}
+
+// allowed returns true if the constraints let j start now.
+func allowed(cs []constraint, j *job, queue []*job, running map[int]*job) bool {
+	for _, c := range cs {
+		switch c.kind {
+		case constraintBefore:
+			if !matches(j, c.b) {
+				continue
+			}
+			for _, o := range queue {
+				if o != j && matches(o, c.a) {
+					return false
+				}
+			}
+			for _, o := range running {
+				if matches(o, c.a) {
+					return false
+				}
+			}
+		case constraintNotWith:
+			for _, o := range running {
+				if (matches(j, c.a) && matches(o, c.b)) || (matches(j, c.b) && matches(o, c.a)) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,297 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// imagePart is the prefix of the variant part naming the container
+// image of a --container-matrix run.
+const imagePart = "image="
+
+// containerAxis returns a variant for each container image.
+func containerAxis(images string) []*variant {
+	var axis []*variant
+	for _, img := range splitList(images) {
+		axis = append(axis, &variant{name: imagePart + img, parts: []string{imagePart + img}, image: img})
+	}
+	return axis
+}
+
+// containerEngine runs tests in containers with the docker CLI or a
+// compatible one, such as podman.
+type containerEngine struct {
+	path string
+
+	// runID goes into the container names, so they can be told
+	// apart from those of other runs.
+	runID string
+
+	// mounts are the host directories the containers see at the
+	// same path.
+	mounts []string
+}
+
+// newContainerEngine finds the engine, docker or podman by default.
+// The containers get the git tree the tests are in, which is the
+// parent of the current directory, and the output dir.
+func newContainerEngine(name, outdir, runID string) (*containerEngine, error) {
+	names := []string{name}
+	if name == "" {
+		names = []string{"docker", "podman"}
+	}
+	c := &containerEngine{runID: runID}
+	for _, n := range names {
+		if p, err := exec.LookPath(n); err == nil {
+			c.path = p
+			break
+		}
+	}
+	if c.path == "" {
+		return nil, fmt.Errorf("no container engine found, tried %s", strings.Join(names, ", "))
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	out, err := filepath.Abs(outdir)
+	if err != nil {
+		return nil, err
+	}
+	c.mounts = []string{filepath.Dir(cwd), out}
+	return c, nil
+}
+
+// containerEnvSkip are the variables that describe the host, and are
+// not passed into the containers.
+var containerEnvSkip = map[string]bool{
+	"PATH":     true,
+	"HOSTNAME": true,
+	"SHLVL":    true,
+	"PWD":      true,
+	"OLDPWD":   true,
+	"_":        true,
+}
+
+// containerName returns the name of the container for j, which is
+// unique within the run.
+func (c *containerEngine) containerName(j *job) string {
+	name := "rungittest-" + c.runID + "-" + strings.TrimSuffix(j.logName(), ".log")
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// wrap makes cmd run the test in image, in a container called name.
+// The environment of cmd is passed on by name, and the --root from
+// GIT_TEST_OPTS (which may be outside the tree) is mounted too.
+func (c *containerEngine) wrap(cmd *exec.Cmd, image, name string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	args := []string{c.path, "run", "--rm", "--init", "--name=" + name, "--network=host", "--workdir=" + cwd}
+	if uid := os.Getuid(); uid >= 0 {
+		args = append(args, fmt.Sprintf("--user=%d:%d", uid, os.Getgid()))
+	}
+	mounts := c.mounts
+	if root := rootOpt(cmd.Env); root != "" {
+		if root, err = filepath.Abs(root); err != nil {
+			return err
+		}
+		// Otherwise the engine creates it, owned by root.
+		if err := os.MkdirAll(root, 0755); err != nil {
+			return err
+		}
+		mounts = append(mounts, root)
+	}
+	var mounted []string
+	for _, m := range mounts {
+		if !within(m, mounted) {
+			args = append(args, "--volume="+m+":"+m)
+			mounted = append(mounted, m)
+		}
+	}
+	seen := map[string]bool{}
+	for _, e := range cmd.Env {
+		name := e
+		if i := strings.Index(e, "="); i >= 0 {
+			name = e[:i]
+		}
+		if !containerEnvSkip[name] && !seen[name] {
+			args = append(args, "--env="+name)
+			seen[name] = true
+		}
+	}
+	args = append(args, image)
+	cmd.Path = c.path
+	cmd.Args = append(args, cmd.Args...)
+	return nil
+}
+
+// kill stops the container called name. Killing the CLI that started
+// it leaves the container running. Errors are ignored, as the
+// container is usually gone already.
+func (c *containerEngine) kill(name string) {
+	exec.Command(c.path, "kill", name).Run()
+}
+
+// within returns true if path is one of dirs or below one.
+func within(path string, dirs []string) bool {
+	for _, d := range dirs {
+		if path == d || strings.HasPrefix(path, strings.TrimSuffix(d, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rootOpt returns the last --root in GIT_TEST_OPTS of env, which is
+// the one test-lib.sh uses.
+func rootOpt(env []string) string {
+	opts := ""
+	for _, e := range env {
+		if strings.HasPrefix(e, "GIT_TEST_OPTS=") {
+			opts = strings.TrimPrefix(e, "GIT_TEST_OPTS=")
+		}
+	}
+	root := ""
+	fields := strings.Fields(opts)
+	for i, f := range fields {
+		if strings.HasPrefix(f, "--root=") {
+			root = strings.TrimPrefix(f, "--root=")
+		} else if f == "--root" && i+1 < len(fields) {
+			root = fields[i+1]
+		}
+	}
+	return root
+}
+
+// imageOf returns the container image a result ran in, or "".
+func imageOf(r *result) string {
+	if r.variant == nil {
+		return ""
+	}
+	for _, p := range r.variant.parts {
+		if strings.HasPrefix(p, imagePart) {
+			return strings.TrimPrefix(p, imagePart)
+		}
+	}
+	return ""
+}
+
+// imageComparison tabulates the results per container image: the
+// counts per image, and the status in each image of the tests that
+// did not fare the same in all of them. It returns "" if the tests
+// did not run in containers.
+func imageComparison(results []*result) string {
+	type cell struct {
+		runs, failed, skipped int
+		status                string
+	}
+	var images, tests []string
+	byTest := map[string]map[string]*cell{}
+	totals := map[string]*cell{}
+	for _, r := range results {
+		img := imageOf(r)
+		if img == "" || r.status == statusCancelled {
+			continue
+		}
+		if totals[img] == nil {
+			totals[img] = &cell{}
+			images = append(images, img)
+		}
+		if byTest[r.name] == nil {
+			byTest[r.name] = map[string]*cell{}
+			tests = append(tests, r.name)
+		}
+		for _, c := range []*cell{totals[img], byTest[r.name][img]} {
+			if c == nil {
+				c = &cell{}
+				byTest[r.name][img] = c
+			}
+			c.runs++
+			c.status = r.status
+			if r.failed() {
+				c.failed++
+			} else if r.status == statusSkipped {
+				c.skipped++
+			}
+		}
+	}
+	if len(images) == 0 {
+		return ""
+	}
+	sort.Strings(tests)
+
+	text := func(c *cell) string {
+		switch {
+		case c == nil:
+			return "-"
+		case c.runs == 1:
+			return c.status
+		}
+		return fmt.Sprintf("%d/%d failed", c.failed, c.runs)
+	}
+	width := 12
+	for _, img := range images {
+		if len(img) > width {
+			width = len(img)
+		}
+	}
+	var buf bytes.Buffer
+	row := func(first string, cells []string) {
+		line := fmt.Sprintf("%-20s", first)
+		for _, c := range cells {
+			line += fmt.Sprintf(" %-*s", width, c)
+		}
+		buf.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	row("", images)
+	var counts []string
+	for _, img := range images {
+		t := totals[img]
+		counts = append(counts, fmt.Sprintf("%d/%d/%d", t.failed, t.skipped, t.runs))
+	}
+	row("failed/skipped/runs", counts)
+	for _, name := range tests {
+		cells := byTest[name]
+		same := len(cells) == len(images)
+		for _, img := range images {
+			if text(cells[img]) != text(cells[images[0]]) {
+				same = false
+			}
+		}
+		if same {
+			continue
+		}
+		var statuses []string
+		for _, img := range images {
+			statuses = append(statuses, text(cells[img]))
+		}
+		row(name, statuses)
+	}
+	return strings.TrimRight(buf.String(), "\n")
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const controlSocket = "control.sock"
+
+const controlHelp = `commands:
+  status        show progress and running tests
+  jobs N        change the number of parallel jobs
+  cancel TEST   stop a running test, or drop it from the queue
+  cancel-run    stop dispatching tests and stop the running ones
+  pause         stop dispatching tests, letting running ones finish
+  resume        resume dispatching tests
+  flush         write summary.txt and results.json for the results so far
+`
+
+// controlServer accepts commands on a Unix domain socket in the
+// output directory.
+type controlServer struct {
+	sched *scheduler
+	flush func() error
+}
+
+func serveControl(outdir string, s *scheduler, flush func() error) (net.Listener, error) {
+	path := filepath.Join(outdir, controlSocket)
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &controlServer{sched: s, flush: flush}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go c.handle(conn)
+		}
+	}()
+	return l, nil
+}
+
+func (c *controlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	conn.Write([]byte(c.command(strings.Fields(line))))
+}
+
+func (c *controlServer) command(args []string) string {
+	if len(args) == 0 {
+		return controlHelp
+	}
+	switch args[0] {
+	case "status":
+		return c.sched.status()
+	case "jobs":
+		if len(args) != 2 {
+			return "usage: jobs N\n"
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Sprintf("bad job count %q\n", args[1])
+		}
+		c.sched.setJobs(n)
+		log.Printf("control: jobs set to %d", n)
+		return fmt.Sprintf("jobs: %d\n", n)
+	case "cancel":
+		if len(args) != 2 {
+			return "usage: cancel TEST\n"
+		}
+		if !c.sched.cancelTest(args[1]) {
+			return fmt.Sprintf("%s: not running or queued\n", args[1])
+		}
+		return fmt.Sprintf("cancelled %s\n", args[1])
+	case "cancel-run":
+		log.Printf("control: cancelling run")
+		c.sched.stop()
+		return "cancelling run\n"
+	case "pause":
+		log.Printf("control: pausing")
+		c.sched.setPaused(true)
+		return "paused\n"
+	case "resume":
+		log.Printf("control: resuming")
+		c.sched.setPaused(false)
+		return "resumed\n"
+	case "flush":
+		if err := c.flush(); err != nil {
+			return fmt.Sprintf("flush: %v\n", err)
+		}
+		return "flushed\n"
+	}
+	return fmt.Sprintf("unknown command %q\n%s", args[0], controlHelp)
+}
+
+// controlMain implements "rungittest ctl OUTDIR COMMAND...".
+func controlMain(args []string) {
+	if len(args) < 1 {
+		log.Fatalf("usage: rungittest ctl OUTDIR COMMAND [ARGS]\n%s", controlHelp)
+	}
+	conn, err := net.Dial("unix", filepath.Join(args[0], controlSocket))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, strings.Join(args[1:], " ")); err != nil {
+		log.Fatal(err)
+	}
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(reply)
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// coverageDir returns where the coverage data of a test goes: the
+// GCOV_PREFIX for gcov, or the kcov output directory.
+func coverageDir(outdir, logFile string) string {
+	dir, err := filepath.Abs(filepath.Join(outdir, "coverage", "tests", strings.TrimSuffix(logFile, ".log")))
+	if err != nil {
+		// Only if the working directory is gone.
+		fatalf("%v", err)
+	}
+	return dir
+}
+
+// checkCoverage verifies that the tools for the coverage mode are
+// there.
+func checkCoverage(mode string) error {
+	switch mode {
+	case "gcov":
+		if _, err := exec.LookPath("gcov-tool"); err != nil {
+			log.Printf("--coverage: gcov-tool not found, the data of the tests will not be merged")
+		}
+	case "kcov":
+		if _, err := exec.LookPath("kcov"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("must be gcov or kcov")
+	}
+	return nil
+}
+
+// mergeCoverage combines the coverage data of all tests into
+// coverage/merged, and writes coverage/attribution.txt, which lists
+// for each data file the tests that produced it.
+func mergeCoverage(outdir, mode string, results []*result) error {
+	var dirs []string
+	byFile := map[string][]string{}
+	for _, r := range results {
+		dir := coverageDir(outdir, r.logFile)
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		dirs = append(dirs, dir)
+		filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return nil
+			}
+			if mode == "gcov" && !strings.HasSuffix(p, ".gcda") {
+				return nil
+			}
+			if mode == "kcov" && filepath.Base(p) != "coverage.json" {
+				return nil
+			}
+			rel, _ := filepath.Rel(dir, p)
+			if mode == "kcov" {
+				rel = filepath.Dir(rel)
+			}
+			byFile[rel] = append(byFile[rel], r.label())
+			return nil
+		})
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no coverage data")
+	}
+
+	var files []string
+	for f := range byFile {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	attribution := ""
+	for _, f := range files {
+		attribution += fmt.Sprintf("%s: %s\n", f, strings.Join(byFile[f], ", "))
+	}
+	covDir := filepath.Join(outdir, "coverage")
+	if err := ioutil.WriteFile(filepath.Join(covDir, "attribution.txt"), []byte(attribution), 0644); err != nil {
+		return err
+	}
+
+	merged := filepath.Join(covDir, "merged")
+	if err := os.RemoveAll(merged); err != nil {
+		return err
+	}
+	switch mode {
+	case "kcov":
+		out, err := exec.Command("kcov", append([]string{"--merge", merged}, dirs...)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("kcov --merge: %v: %s", err, out)
+		}
+	case "gcov":
+		if _, err := exec.LookPath("gcov-tool"); err != nil {
+			return nil
+		}
+		// gcov-tool merges two directories at a time.
+		for _, d := range dirs {
+			if _, err := os.Stat(merged); os.IsNotExist(err) {
+				if out, err := exec.Command("cp", "-r", d, merged).CombinedOutput(); err != nil {
+					return fmt.Errorf("cp: %v: %s", err, out)
+				}
+				continue
+			}
+			tmp := merged + ".tmp"
+			if out, err := exec.Command("gcov-tool", "merge", merged, d, "-o", tmp).CombinedOutput(); err != nil {
+				return fmt.Errorf("gcov-tool merge: %v: %s", err, out)
+			}
+			if err := os.RemoveAll(merged); err != nil {
+				return err
+			}
+			if err := os.Rename(tmp, merged); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// parseCPUList parses a list such as "0-3,8,10-11".
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(s), ",") {
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		lo, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		for c := lo; c <= hi; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
+
+// allowedCPUs returns the CPUs we may run on.
+func allowedCPUs() []int {
+	if data, err := ioutil.ReadFile("/proc/self/status"); err == nil {
+		for _, l := range strings.Split(string(data), "\n") {
+			if !strings.HasPrefix(l, "Cpus_allowed_list:") {
+				continue
+			}
+			if cpus, err := parseCPUList(strings.TrimPrefix(l, "Cpus_allowed_list:")); err == nil && len(cpus) > 0 {
+				return cpus
+			}
+		}
+	}
+	var cpus []int
+	for i := 0; i < runtime.NumCPU(); i++ {
+		cpus = append(cpus, i)
+	}
+	return cpus
+}
+
+// cpuPinning assigns CPUs to worker slots.
+type cpuPinning struct {
+	cpus    []int
+	perSlot int
+}
+
+// slotCPUs returns the taskset CPU list for a worker slot.
+func (p *cpuPinning) slotCPUs(slot int) string {
+	var list []string
+	for i := 0; i < p.perSlot; i++ {
+		list = append(list, strconv.Itoa(p.cpus[(slot*p.perSlot+i)%len(p.cpus)]))
+	}
+	return strings.Join(list, ",")
+}
+
+func (p *cpuPinning) check(jobs int) error {
+	if jobs*p.perSlot > len(p.cpus) {
+		return fmt.Errorf("%d jobs with %d CPUs each need %d CPUs, only have %d; slots will share CPUs",
+			jobs, p.perSlot, jobs*p.perSlot, len(p.cpus))
+	}
+	return nil
+}
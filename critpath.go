@@ -0,0 +1,177 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// idleGap is a stretch of a run during which worker slots were idle.
+type idleGap struct {
+	from, to time.Duration
+
+	// idle is the slot time left unused.
+	idle time.Duration
+
+	// last is the test that finished last during the gap, and next
+	// the test started at its end, if any.
+	last, next *result
+}
+
+// criticalPathCount is the number of idle gaps listed.
+const criticalPathCount = 5
+
+// minIdleGap is the length below which gaps are put down to the time
+// it takes to start a test.
+const minIdleGap = time.Second
+
+// criticalPath compares the wall-clock time of the run with the lower
+// bound set by the longest test and the test time spread evenly over
+// the slots, and lists where slots were idle the longest.
+func criticalPath(results []*result, ws []weight, jobs int) string {
+	var rs []*result
+	for _, r := range results {
+		if !r.start.IsZero() {
+			rs = append(rs, r)
+		}
+	}
+	if len(rs) == 0 || jobs < 1 {
+		return ""
+	}
+	slots := func(r *result) int {
+		n := slotsFor(ws, &job{name: r.name, variant: r.variant})
+		if n > jobs {
+			n = jobs
+		}
+		return n
+	}
+
+	origin, end := rs[0].start, rs[0].start.Add(rs[0].duration)
+	var total time.Duration
+	longest := rs[0]
+	for _, r := range rs {
+		if r.start.Before(origin) {
+			origin = r.start
+		}
+		if e := r.start.Add(r.duration); e.After(end) {
+			end = e
+		}
+		total += r.duration * time.Duration(slots(r))
+		if r.duration > longest.duration {
+			longest = r
+		}
+	}
+	wall := end.Sub(origin)
+	spread := total / time.Duration(jobs)
+	bound := spread
+	if longest.duration > bound {
+		bound = longest.duration
+	}
+
+	// Sweep over the starts and ends, finishing tests before
+	// starting others at the same time.
+	type event struct {
+		at    time.Duration
+		slots int
+		r     *result
+	}
+	var events []event
+	for _, r := range rs {
+		from := r.start.Sub(origin)
+		events = append(events, event{from, slots(r), r}, event{from + r.duration, -slots(r), r})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].at != events[j].at {
+			return events[i].at < events[j].at
+		}
+		return events[i].slots < events[j].slots
+	})
+	var gaps []*idleGap
+	var cur *idleGap
+	var idle time.Duration
+	load := 0
+	for i, e := range events {
+		if i > 0 && load < jobs {
+			d := (e.at - events[i-1].at) * time.Duration(jobs-load)
+			idle += d
+			if cur != nil {
+				cur.idle += d
+			}
+		}
+		load += e.slots
+		if e.slots < 0 && cur == nil && load < jobs {
+			cur = &idleGap{from: e.at}
+		}
+		if cur == nil {
+			continue
+		}
+		if e.slots < 0 {
+			cur.last = e.r
+		}
+		if load >= jobs || i == len(events)-1 {
+			cur.to = e.at
+			if e.slots > 0 {
+				cur.next = e.r
+			}
+			if cur.to-cur.from >= minIdleGap {
+				gaps = append(gaps, cur)
+			}
+			cur = nil
+		}
+	}
+	sort.SliceStable(gaps, func(i, j int) bool { return gaps[i].idle > gaps[j].idle })
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "longest test: %s, %s\n", longest.label(), reportDuration(longest.duration))
+	fmt.Fprintf(&buf, "test time over %d slots: %s\n", jobs, reportDuration(spread))
+	fmt.Fprintf(&buf, "wall-clock: %s, lower bound %s (%s)\n", reportDuration(wall),
+		reportDuration(bound), percent(bound, wall))
+	fmt.Fprintf(&buf, "idle slot time: %s, %s\n", reportDuration(idle), percent(idle, wall*time.Duration(jobs)))
+	if len(gaps) > criticalPathCount {
+		gaps = gaps[:criticalPathCount]
+	}
+	if len(gaps) > 0 {
+		buf.WriteString("\nlargest idle gaps:\n")
+	}
+	for _, g := range gaps {
+		fmt.Fprintf(&buf, "+%s - +%s: %s slot time idle", reportDuration(g.from), reportDuration(g.to), reportDuration(g.idle))
+		if g.next != nil {
+			// Nothing was dispatched although slots were
+			// free, so something held the queue back.
+			fmt.Fprintf(&buf, ", until %s started (held back by --weights, --constraints, a pause or throttling)\n", g.next.label())
+			continue
+		}
+		// The queue had run dry; the tests still running at the
+		// end of the gap were started too late.
+		r := g.last
+		from := r.start.Sub(origin)
+		fmt.Fprintf(&buf, ", waiting for %s, started at +%s and taking %s", r.label(), reportDuration(from), reportDuration(r.duration))
+		if from > 0 {
+			fmt.Fprintf(&buf, "; starting it first could save up to %s", reportDuration(minDuration(from, g.to-g.from)))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
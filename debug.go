@@ -0,0 +1,63 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"runtime"
+)
+
+var (
+	testsStarted  = expvar.NewInt("tests_started")
+	testsFinished = expvar.NewInt("tests_finished")
+	testsFailed   = expvar.NewInt("tests_failed")
+	testsSkipped  = expvar.NewInt("tests_skipped")
+	runIDVar      = expvar.NewString("run_id")
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// expvarReporter maintains the counters exported on /debug/vars.
+type expvarReporter struct{}
+
+func (expvarReporter) started(name string) {
+	testsStarted.Add(1)
+}
+
+func (expvarReporter) finished(r *result) {
+	testsFinished.Add(1)
+	if r.failed() {
+		testsFailed.Add(1)
+	}
+	if r.status == statusSkipped {
+		testsSkipped.Add(1)
+	}
+}
+
+func (expvarReporter) done() {}
+
+// serveDebug serves /debug/pprof and /debug/vars on addr.
+func serveDebug(addr string) {
+	go func() {
+		log.Printf("debug server: %v", http.ListenAndServe(addr, nil))
+	}()
+}
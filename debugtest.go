@@ -0,0 +1,101 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// findScript resolves a script name or test number (eg. "t5510") to
+// the script in the current directory.
+func findScript(name string) (string, error) {
+	if _, err := os.Stat(name); err == nil {
+		return name, nil
+	}
+	matches, _ := filepath.Glob(name + "-*.sh")
+	if len(matches) == 0 {
+		matches, _ = filepath.Glob(name + "*.sh")
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no test script %q", name)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%q is ambiguous: %s", name, strings.Join(matches, ", "))
+}
+
+// trashDir returns where test-lib.sh puts the trash directory of the
+// script: under the last --root in GIT_TEST_OPTS or the arguments, or
+// the current directory.
+func trashDir(script string, args []string) string {
+	root := "."
+	for _, a := range append(strings.Fields(os.Getenv("GIT_TEST_OPTS")), args...) {
+		if strings.HasPrefix(a, "--root=") {
+			root = strings.TrimPrefix(a, "--root=")
+		}
+	}
+	return filepath.Join(root, "trash directory."+strings.TrimSuffix(filepath.Base(script), ".sh"))
+}
+
+func debugMain(args []string) {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	shell := fs.String("shell", "/bin/sh", "shell for running the test script")
+	chdir := fs.String("chdir", "", "change to this directory before looking for the script")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest debug [flags] SCRIPT [TEST-ARGS...]\n\n"+
+			"Runs one test script in the foreground with -v -x -i --debug, so\n"+
+			"the output shows live, the script stops at the first failure and\n"+
+			"the trash directory is kept. SCRIPT is a script name or test\n"+
+			"number (eg. t5510). TEST-ARGS, eg. --run=1-3, are passed on.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *chdir != "" {
+		if err := os.Chdir(*chdir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	script, err := findScript(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	testArgs := append([]string{filepath.ToSlash(script), "-v", "-x", "-i", "--debug"}, fs.Args()[1:]...)
+	cmd := exec.Command(*shell, testArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		log.Fatal(err)
+	}
+	if dir := trashDir(script, fs.Args()[1:]); dirExists(dir) {
+		fmt.Fprintf(os.Stderr, "trash directory kept: %s\n", dir)
+	}
+	os.Exit(cmd.ProcessState.ExitCode())
+}
+
+func dirExists(dir string) bool {
+	fi, err := os.Stat(dir)
+	return err == nil && fi.IsDir()
+}
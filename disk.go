@@ -0,0 +1,93 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// testRoot returns the directory where the tests create their trash
+// directories: the --root from GIT_TEST_OPTS, or the current
+// directory.
+func testRoot() string {
+	fields := strings.Fields(os.Getenv("GIT_TEST_OPTS"))
+	for i, f := range fields {
+		if strings.HasPrefix(f, "--root=") {
+			return strings.TrimPrefix(f, "--root=")
+		}
+		if f == "--root" && i+1 < len(fields) {
+			return fields[i+1]
+		}
+	}
+	return "."
+}
+
+// diskGuard checks free space and inodes on the file systems the run
+// writes to.
+type diskGuard struct {
+	paths []string
+
+	warnBytes, abortBytes   int64
+	warnInodes, abortInodes int64
+
+	warned map[string]bool
+}
+
+const diskCheckInterval = 10 * time.Second
+
+// check returns an error if any file system is below the abort
+// threshold, and logs a warning when one first drops below the warn
+// threshold.
+func (g *diskGuard) check() error {
+	for _, p := range g.paths {
+		bytes, inodes, err := diskFree(p)
+		if err != nil {
+			if err != errNotSupported {
+				log.Printf("disk guard: %v", err)
+			}
+			continue
+		}
+		if bytes < g.abortBytes {
+			return fmt.Errorf("%s: only %s free", p, formatSize(bytes))
+		}
+		if inodes >= 0 && inodes < g.abortInodes {
+			return fmt.Errorf("%s: only %d inodes free", p, inodes)
+		}
+		low := (bytes < g.warnBytes) || (inodes >= 0 && inodes < g.warnInodes)
+		if low && !g.warned[p] {
+			log.Printf("warning: %s is running low: %s, %d inodes free", p, formatSize(bytes), inodes)
+		}
+		g.warned[p] = low
+	}
+	return nil
+}
+
+// watch checks the disks periodically, aborting the run if they fill
+// up.
+func (g *diskGuard) watch(s *scheduler) {
+	go func() {
+		for range time.Tick(diskCheckInterval) {
+			if err := g.check(); err != nil {
+				log.Printf("disk guard: %v; aborting run", err)
+				s.abort(exitInfra, "disk full: "+err.Error())
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// durationBuckets are the upper bounds of the buckets of the duration
+// report; the last bucket has no bound.
+var durationBuckets = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	15 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+}
+
+// bucketOf returns the index of the bucket d falls in.
+func bucketOf(d time.Duration) int {
+	for i, b := range durationBuckets {
+		if d < b {
+			return i
+		}
+	}
+	return len(durationBuckets)
+}
+
+func bucketName(i int) string {
+	if i == len(durationBuckets) {
+		return ">=" + shortDuration(durationBuckets[i-1])
+	}
+	return "<" + shortDuration(durationBuckets[i])
+}
+
+// shortDuration formats d as "5m" rather than "5m0s".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	return s
+}
+
+// reportDuration rounds d for the tables of the reports.
+func reportDuration(d time.Duration) time.Duration {
+	if d >= time.Minute {
+		return d.Round(time.Second)
+	}
+	return d.Round(time.Millisecond)
+}
+
+func percent(d, total time.Duration) string {
+	if total <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(d)/float64(total))
+}
+
+// durationReport shows how the test time is spread over the tests: a
+// histogram of the durations, the share taken by the slowest tests and
+// the test time per area and duration bucket.
+func durationReport(results []*result) string {
+	var total time.Duration
+	for _, r := range results {
+		total += r.duration
+	}
+	nb := len(durationBuckets) + 1
+
+	var buf bytes.Buffer
+	counts := make([]int, nb)
+	times := make([]time.Duration, nb)
+	maxCount := 0
+	for _, r := range results {
+		b := bucketOf(r.duration)
+		counts[b]++
+		times[b] += r.duration
+		if counts[b] > maxCount {
+			maxCount = counts[b]
+		}
+	}
+	const barWidth = 40
+	fmt.Fprintf(&buf, "%-8s %6s %10s %7s\n", "duration", "tests", "time", "share")
+	for b := 0; b < nb; b++ {
+		fmt.Fprintf(&buf, "%-8s %6d %10s %7s", bucketName(b), counts[b],
+			reportDuration(times[b]), percent(times[b], total))
+		if counts[b] > 0 {
+			buf.WriteString(" " + strings.Repeat("#", 1+(barWidth-1)*counts[b]/maxCount))
+		}
+		buf.WriteString("\n")
+	}
+
+	sorted := append([]*result{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].duration > sorted[j].duration })
+	buf.WriteString("\n")
+	var cum time.Duration
+	next := 1
+	for i, r := range sorted {
+		cum += r.duration
+		if i+1 == next || i+1 == len(sorted) {
+			what := fmt.Sprintf("slowest %d tests", i+1)
+			if i == 0 {
+				what = "slowest test"
+			}
+			fmt.Fprintf(&buf, "%s: %s, %s of the test time\n", what, reportDuration(cum), percent(cum, total))
+			for next <= i+1 {
+				next *= 2
+			}
+		}
+	}
+
+	byArea := map[string][]time.Duration{}
+	var areas []string
+	for _, r := range results {
+		a := testArea(r.name)
+		if byArea[a] == nil {
+			byArea[a] = make([]time.Duration, nb+1)
+			areas = append(areas, a)
+		}
+		byArea[a][bucketOf(r.duration)] += r.duration
+		byArea[a][nb] += r.duration
+	}
+	sort.Strings(areas)
+	fmt.Fprintf(&buf, "\n%-6s", "area")
+	for b := 0; b < nb; b++ {
+		fmt.Fprintf(&buf, " %7s", bucketName(b))
+	}
+	fmt.Fprintf(&buf, " %7s\n", "all")
+	for _, a := range areas {
+		fmt.Fprintf(&buf, "%-6s", a)
+		for _, d := range byArea[a] {
+			fmt.Fprintf(&buf, " %7s", percent(d, total))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
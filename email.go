@@ -0,0 +1,122 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// digest is the mail sent by --email after a run.
+type digest struct {
+	subject string
+	body    string
+}
+
+// newDigest summarizes a run for people who don't look at the
+// machine: counts, the failures that are new compared to the
+// baseline, the flaky tests, and where to find the output.
+func newDigest(rr *runResults, base skipBaseline, link string) *digest {
+	host, _ := os.Hostname()
+	counts := map[string]int{}
+	var newFailed, oldFailed, flaky []string
+	for _, r := range rr.results {
+		counts[r.status]++
+		if r.flaky() {
+			flaky = append(flaky, strings.TrimRight("  "+r.line(), " "))
+		}
+		if !r.failed() {
+			continue
+		}
+		line := "  " + r.line()
+		if r.recurrence != "" {
+			line += "\n\t" + r.recurrence
+		}
+		if old, ok := base[r.label()]; len(base) > 0 && (!ok || old.Status == statusOK || old.Status == statusSkipped) {
+			newFailed = append(newFailed, line)
+		} else {
+			oldFailed = append(oldFailed, line)
+		}
+	}
+	sort.Strings(newFailed)
+	sort.Strings(oldFailed)
+	sort.Strings(flaky)
+	failed := len(newFailed) + len(oldFailed)
+
+	d := &digest{
+		subject: fmt.Sprintf("rungittest on %s: %d failures (%d new), %d passed", host, failed, len(newFailed), counts[statusOK]),
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "rungittest on %s, %d tests: %d passed, %d failed, %d skipped, elapsed %s.\n",
+		host, len(rr.results), counts[statusOK], failed, counts[statusSkipped], rr.elapsed.Round(time.Second))
+	if rr.id != "" {
+		fmt.Fprintf(&buf, "Run ID: %s\n", rr.id)
+	}
+	if rr.aborted != "" {
+		fmt.Fprintf(&buf, "\nThe run was aborted: %s\n", rr.aborted)
+	}
+	section := func(title string, lines []string) {
+		if len(lines) > 0 {
+			fmt.Fprintf(&buf, "\n%s (%d):\n%s\n", title, len(lines), strings.Join(lines, "\n"))
+		}
+	}
+	section("New failures since the baseline", newFailed)
+	if len(base) > 0 {
+		section("Failures that also failed in the baseline", oldFailed)
+	} else {
+		section("Failures", oldFailed)
+	}
+	section("Flaky tests (failed, then passed when rerun)", flaky)
+	section("Newly skipped", rr.newSkips)
+	if link != "" {
+		fmt.Fprintf(&buf, "\nLogs and artifacts: %s\n", link)
+	}
+	d.body = buf.String()
+	return d
+}
+
+// send mails the digest through the SMTP server at addr. If
+// RUNGITTEST_SMTP_USER is set, it logs in with that and
+// RUNGITTEST_SMTP_PASSWORD.
+func (d *digest) send(addr, from string, to []string) error {
+	var auth smtp.Auth
+	if user := os.Getenv("RUNGITTEST_SMTP_USER"); user != "" {
+		host := addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", user, os.Getenv("RUNGITTEST_SMTP_PASSWORD"), host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n",
+		from, strings.Join(to, ", "), d.subject, time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(d.body, "\n", "\r\n", -1))
+	return smtp.SendMail(addr, auth, from, to, msg.Bytes())
+}
+
+// defaultEmailFrom is the sender unless --email-from says otherwise.
+func defaultEmailFrom() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return "rungittest@" + host
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The error of a result says why the test did not run to completion.
+// The kinds below are recorded in results.json, so tools reading it
+// need not parse the summary.
+
+// setupError is the error of a test that was not started because
+// preparing it failed, eg. creating its log or home.
+type setupError struct {
+	// stage is what failed, eg. "create" or "sandbox".
+	stage string
+	err   error
+}
+
+func (e *setupError) Error() string {
+	return fmt.Sprintf("%s error: %v", e.stage, e.err)
+}
+
+func (e *setupError) Unwrap() error { return e.err }
+
+// timeoutError is the error of a test stopped by --timeout, or by
+// --silence-timeout if silent is set.
+type timeoutError struct {
+	limit  time.Duration
+	silent bool
+	err    error
+}
+
+func (e *timeoutError) Error() string {
+	if e.silent {
+		return fmt.Sprintf("no output for %s", e.limit)
+	}
+	return fmt.Sprintf("timed out after %s", e.limit)
+}
+
+func (e *timeoutError) Unwrap() error { return e.err }
+
+// crashError is the error of a test whose script, or a command it
+// ran, died of a signal.
+type crashError struct {
+	signal string
+	err    error
+}
+
+func (e *crashError) Error() string {
+	return "crashed: " + e.signal
+}
+
+func (e *crashError) Unwrap() error { return e.err }
+
+// loadedError is an error read back from results.json.
+type loadedError struct {
+	kind, msg string
+}
+
+func (e *loadedError) Error() string { return e.msg }
+
+// errorKind returns "setup", "timeout" or "crash" for the errors
+// above, and "" for others.
+func errorKind(err error) string {
+	var (
+		setup   *setupError
+		timeout *timeoutError
+		crash   *crashError
+		loaded  *loadedError
+	)
+	switch {
+	case errors.As(err, &setup):
+		return "setup"
+	case errors.As(err, &timeout):
+		return "timeout"
+	case errors.As(err, &crash):
+		return "crash"
+	case errors.As(err, &loaded):
+		return loaded.kind
+	}
+	return ""
+}
+
+// setupFailed marks r as not started because the given stage of
+// preparing the test failed.
+func (r *result) setupFailed(stage string, err error) *result {
+	r.status = statusFail
+	r.summary = stage + " error"
+	r.err = &setupError{stage: stage, err: err}
+	return r
+}
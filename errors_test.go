@@ -0,0 +1,51 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestErrorKind(t *testing.T) {
+	exit := &exec.ExitError{}
+	for _, c := range []struct {
+		err  error
+		kind string
+	}{
+		{nil, ""},
+		{exit, ""},
+		{&setupError{stage: "sandbox", err: errors.New("no landlock")}, "setup"},
+		{fmt.Errorf("wrapped: %w", &setupError{stage: "create", err: exit}), "setup"},
+		{&timeoutError{limit: time.Minute, err: exit}, "timeout"},
+		{&timeoutError{limit: time.Minute, silent: true}, "timeout"},
+		{&crashError{signal: "bus error", err: exit}, "crash"},
+		{&loadedError{kind: "crash", msg: "crashed: bus error"}, "crash"},
+	} {
+		if k := errorKind(c.err); k != c.kind {
+			t.Errorf("errorKind(%v) = %q, want %q", c.err, k, c.kind)
+		}
+	}
+
+	if !errors.Is(&crashError{signal: "x", err: exit}, exit) {
+		t.Errorf("crashError does not unwrap")
+	}
+	if msg := (&timeoutError{limit: time.Minute, silent: true}).Error(); msg != "no output for 1m0s" {
+		t.Errorf("silent timeout: got %q", msg)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type hookFailure struct {
+	Test     string  `json:"test"`
+	Status   string  `json:"status"`
+	Summary  string  `json:"summary"`
+	Duration float64 `json:"duration"`
+	Log      string  `json:"log"`
+
+	// URL is the log under --email-link, if given.
+	URL string `json:"url,omitempty"`
+}
+
+type hookEvent struct {
+	Event    string        `json:"event"`
+	RunID    string        `json:"run_id"`
+	Host     string        `json:"host,omitempty"`
+	Args     string        `json:"args"`
+	Outdir   string        `json:"outdir"`
+	Start    time.Time     `json:"start"`
+	Failures []hookFailure `json:"failures"`
+
+	// Finished and Failed count the tests so far.
+	Finished int `json:"finished"`
+	Failed   int `json:"failed"`
+}
+
+// failureHook posts failures to a webhook while the run goes on. The
+// first failure starts a batch, which is sent once window has passed,
+// so a broken build that fails everything makes one post, not
+// hundreds.
+type failureHook struct {
+	runID  string
+	url    string
+	window time.Duration
+	outdir string
+	link   string
+	start  time.Time
+
+	mu      sync.Mutex
+	pending []hookFailure
+	timer   *time.Timer
+	nDone   int
+	nFailed int
+
+	posts sync.WaitGroup
+}
+
+func newFailureHook(runID, url string, window time.Duration, outdir, link string) *failureHook {
+	return &failureHook{
+		runID:  runID,
+		url:    url,
+		window: window,
+		outdir: outdir,
+		link:   strings.TrimSuffix(link, "/"),
+		start:  time.Now(),
+	}
+}
+
+func (h *failureHook) started(name string) {}
+
+func (h *failureHook) finished(r *result) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nDone++
+	if !r.failed() {
+		return
+	}
+	h.nFailed++
+	f := hookFailure{
+		Test:     r.label(),
+		Status:   r.status,
+		Summary:  r.summary,
+		Duration: r.duration.Seconds(),
+		Log:      filepath.ToSlash(r.logFile),
+	}
+	if h.link != "" {
+		f.URL = h.link + "/" + f.Log
+	}
+	h.pending = append(h.pending, f)
+	if h.timer == nil {
+		h.timer = time.AfterFunc(h.window, h.flush)
+	}
+}
+
+// flush posts the pending failures.
+func (h *failureHook) flush() {
+	h.mu.Lock()
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+	ev := hookEvent{
+		Event:    "test_failures",
+		RunID:    h.runID,
+		Args:     strings.Join(os.Args, " "),
+		Outdir:   h.outdir,
+		Start:    h.start,
+		Failures: h.pending,
+		Finished: h.nDone,
+		Failed:   h.nFailed,
+	}
+	h.pending = nil
+	h.posts.Add(1)
+	h.mu.Unlock()
+	defer h.posts.Done()
+
+	if len(ev.Failures) == 0 {
+		return
+	}
+	if host, err := os.Hostname(); err == nil {
+		ev.Host = host
+	}
+	if err := h.post(&ev); err != nil {
+		log.Printf("--notify-on-failure: %v", err)
+	}
+}
+
+func (h *failureHook) post(ev *hookEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(h.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", h.url, resp.Status)
+	}
+	return nil
+}
+
+// done sends what is left without waiting for the window to pass, and
+// waits for the posts in flight.
+func (h *failureHook) done() {
+	h.flush()
+	h.posts.Wait()
+}
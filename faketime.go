@@ -0,0 +1,102 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var (
+	faketimeOffsetRE = regexp.MustCompile(`^[+-][0-9]+(\.[0-9]+)?[smhdy]?$`)
+	faketimeRateRE   = regexp.MustCompile(`^x[0-9]+(\.[0-9]+)?$`)
+)
+
+// parseFaketime turns a --faketime value into a FAKETIME setting for
+// libfaketime. The value is an offset such as "+1y" or "-30d", an
+// absolute start time "@2038-01-19 03:14:00", a rate such as "x10",
+// or an offset and a rate, "+1y,x10". Other values are passed on
+// unchanged, for libfaketime's other formats.
+func parseFaketime(v string) (string, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("want OFFSET, RATE or OFFSET,RATE, got %q", v)
+	}
+	offset, rate := parts[0], ""
+	if len(parts) == 2 {
+		rate = parts[1]
+	} else if faketimeRateRE.MatchString(offset) {
+		offset, rate = "+0", offset
+	}
+	if rate != "" && !faketimeRateRE.MatchString(rate) {
+		return "", fmt.Errorf("bad rate %q, want eg. x2", rate)
+	}
+	switch {
+	case faketimeOffsetRE.MatchString(offset):
+	case strings.HasPrefix(offset, "@"):
+		if _, err := time.Parse("2006-01-02 15:04:05", offset[1:]); err != nil {
+			return "", fmt.Errorf("bad start time %q, want eg. @2038-01-19 03:14:00", offset)
+		}
+	case rate != "":
+		return "", fmt.Errorf("bad offset %q, want eg. +1y", offset)
+	}
+	return strings.TrimSpace(offset + " " + rate), nil
+}
+
+// faketimeLibs are where distributions install libfaketime.
+var faketimeLibs = []string{
+	"/usr/lib/*/faketime/libfaketime.so.1",
+	"/usr/lib/faketime/libfaketime.so.1",
+	"/usr/lib64/faketime/libfaketime.so.1",
+	"/usr/local/lib/faketime/libfaketime.so.1",
+	"/opt/homebrew/lib/faketime/libfaketime.1.dylib",
+	"/usr/local/lib/faketime/libfaketime.1.dylib",
+}
+
+// findLibfaketime returns the path of libfaketime.
+func findLibfaketime() (string, error) {
+	for _, g := range faketimeLibs {
+		if m, _ := filepath.Glob(g); len(m) > 0 {
+			return m[0], nil
+		}
+	}
+	return "", fmt.Errorf("libfaketime not found; install it or give --faketime-lib")
+}
+
+// faketimeEnv returns the environment that preloads lib into the
+// tests with the given FAKETIME setting.
+func faketimeEnv(lib, spec string) []string {
+	// Without FAKETIME_DONT_RESET, each git command of a test would
+	// start over at an absolute start time.
+	env := []string{"FAKETIME=" + spec, "FAKETIME_DONT_RESET=1"}
+	if runtime.GOOS == "darwin" {
+		return append(env, "DYLD_FORCE_FLAT_NAMESPACE=1",
+			"DYLD_INSERT_LIBRARIES="+joinPreload(os.Getenv("DYLD_INSERT_LIBRARIES"), lib, ":"))
+	}
+	return append(env, "LD_PRELOAD="+joinPreload(os.Getenv("LD_PRELOAD"), lib, " "))
+}
+
+// joinPreload adds lib to a list of preloaded libraries.
+func joinPreload(old, lib, sep string) string {
+	if old == "" {
+		return lib
+	}
+	return old + sep + lib
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// defaultFingerprintEnv are the environment variables recorded for
+// each test, so runs on different machines can be compared.
+const defaultFingerprintEnv = "GIT_TEST_*,GIT_SKIP_TESTS,LANG,LC_ALL,TZ"
+
+// fingerprintEnv returns the variables in env (the environment of the
+// test, or ours if nil) whose names match one of the patterns.
+func fingerprintEnv(env []string, patterns []string) map[string]string {
+	if len(patterns) == 0 {
+		return nil
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	fp := map[string]string{}
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, kv[0]); ok {
+				// Later entries override earlier ones.
+				fp[kv[0]] = kv[1]
+				break
+			}
+		}
+	}
+	return fp
+}
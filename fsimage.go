@@ -0,0 +1,204 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fsFlavor says how to make a file system for --filesystems.
+type fsFlavor struct {
+	fstype string
+	mkfs   []string
+
+	// casefold makes the test root a case-insensitive directory.
+	casefold bool
+
+	// noOwners is set for file systems without file ownership,
+	// which are mounted as the --run-as user instead.
+	noOwners bool
+}
+
+var fsFlavors = map[string]fsFlavor{
+	"ext4":          {fstype: "ext4", mkfs: []string{"mkfs.ext4", "-q", "-F"}},
+	"ext4-casefold": {fstype: "ext4", mkfs: []string{"mkfs.ext4", "-q", "-F", "-O", "casefold"}, casefold: true},
+	"btrfs":         {fstype: "btrfs", mkfs: []string{"mkfs.btrfs", "-q", "-f"}},
+	"xfs":           {fstype: "xfs", mkfs: []string{"mkfs.xfs", "-q", "-f"}},
+	"vfat":          {fstype: "vfat", mkfs: []string{"mkfs.vfat"}, noOwners: true},
+}
+
+func fsFlavorNames() string {
+	var names []string
+	for n := range fsFlavors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// loopFS is a file system in an image file, loop mounted in the
+// output dir.
+type loopFS struct {
+	name   string
+	flavor fsFlavor
+	image  string
+	mnt    string
+
+	// root is the test root in it.
+	root string
+
+	mounted bool
+}
+
+func runQuiet(argv ...string) error {
+	out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v %s", strings.Join(argv, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// loopFilesystems returns the file systems to mount for names, without
+// mounting them yet.
+func loopFilesystems(outdir string, names []string) ([]*loopFS, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if runtime.GOOS != "linux" {
+		return nil, errNotSupported
+	}
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("loop mounts need root")
+	}
+	dir, err := filepath.Abs(filepath.Join(outdir, "fs"))
+	if err != nil {
+		return nil, err
+	}
+	var fss []*loopFS
+	for _, n := range names {
+		fl, ok := fsFlavors[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown file system %q, have %s", n, fsFlavorNames())
+		}
+		if _, err := exec.LookPath(fl.mkfs[0]); err != nil {
+			return nil, err
+		}
+		l := &loopFS{
+			name:   n,
+			flavor: fl,
+			image:  filepath.Join(dir, n+".img"),
+			mnt:    filepath.Join(dir, n),
+		}
+		l.root = filepath.Join(l.mnt, "root")
+		fss = append(fss, l)
+	}
+	return fss, nil
+}
+
+// mount creates a sparse image of the given size with the file system
+// and mounts it.
+func (l *loopFS) mount(size int64, u *runAsUser) error {
+	if err := os.MkdirAll(l.mnt, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(l.image)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = runQuiet(append(l.flavor.mkfs, l.image)...)
+	}
+	if err != nil {
+		os.Remove(l.image)
+		os.Remove(l.mnt)
+		return err
+	}
+	opts := "loop"
+	if l.flavor.noOwners && u != nil {
+		opts += ",uid=" + strconv.Itoa(u.uid) + ",gid=" + strconv.Itoa(u.gid)
+	}
+	if err := runQuiet("mount", "-t", l.flavor.fstype, "-o", opts, l.image, l.mnt); err != nil {
+		os.Remove(l.image)
+		os.Remove(l.mnt)
+		return err
+	}
+	l.mounted = true
+	err = os.Mkdir(l.root, 0755)
+	if err == nil && l.flavor.casefold {
+		// Case folding is a directory attribute, inherited by
+		// the directories created in it.
+		err = runQuiet("chattr", "+F", l.root)
+	}
+	if err == nil && !l.flavor.noOwners {
+		err = u.give(l.root)
+	}
+	return err
+}
+
+// unmount unmounts the file system and removes its image.
+func (l *loopFS) unmount() {
+	if !l.mounted {
+		return
+	}
+	if err := runQuiet("umount", l.mnt); err != nil {
+		log.Printf("--filesystems: %v", err)
+		return
+	}
+	l.mounted = false
+	os.Remove(l.mnt)
+	os.Remove(l.image)
+	os.Remove(filepath.Dir(l.mnt))
+}
+
+// mountFilesystems mounts fss. On error, the ones mounted so far are
+// unmounted again.
+func mountFilesystems(fss []*loopFS, size int64, u *runAsUser) error {
+	for _, l := range fss {
+		if err := l.mount(size, u); err != nil {
+			unmountFilesystems(fss)
+			return fmt.Errorf("%s: %v", l.name, err)
+		}
+		log.Printf("--filesystems: %s on %s", l.name, l.mnt)
+	}
+	return nil
+}
+
+func unmountFilesystems(fss []*loopFS) {
+	for _, l := range fss {
+		l.unmount()
+	}
+}
+
+// filesystemAxis returns a variant for each file system, with the test
+// root on it.
+func filesystemAxis(fss []*loopFS) []*variant {
+	var axis []*variant
+	for _, l := range fss {
+		axis = append(axis, &variant{name: "fs=" + l.name, parts: []string{"fs=" + l.name}, root: l.root})
+	}
+	return axis
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"path/filepath"
+	"strings"
+)
+
+// fuzzToggles are GIT_TEST_* settings that switch git to alternative
+// code paths, all of which the test suite is supposed to pass with.
+var fuzzToggles = []string{
+	"GIT_TEST_SPLIT_INDEX=yes",
+	"GIT_TEST_FULL_IN_PACK_ARRAY=true",
+	"GIT_TEST_OE_SIZE=10",
+	"GIT_TEST_OE_DELTA_SIZE=5",
+	"GIT_TEST_COMMIT_GRAPH=1",
+	"GIT_TEST_COMMIT_GRAPH_CHANGED_PATHS=1",
+	"GIT_TEST_MULTI_PACK_INDEX=1",
+	"GIT_TEST_MULTI_PACK_INDEX_WRITE_BITMAP=1",
+	"GIT_TEST_WRITE_REV_INDEX=1",
+	"GIT_TEST_CHECKOUT_WORKERS=2",
+	"GIT_TEST_PRELOAD_INDEX=1",
+	"GIT_TEST_INDEX_VERSION=4",
+	"GIT_TEST_SPARSE_INDEX=1",
+	"GIT_TEST_DEFAULT_REF_FORMAT=reftable",
+}
+
+// fuzzEnv picks a random subset of fuzzToggles. The choice only
+// depends on the seed, the test and k, so a combination can be
+// reproduced by rerunning the test alone with the same seed.
+func fuzzEnv(seed int64, name string, k int) []string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%d", filepath.Base(name), k)
+	rnd := rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+
+	var env []string
+	for _, t := range fuzzToggles {
+		if rnd.Intn(2) == 0 {
+			env = append(env, t)
+		}
+	}
+	return env
+}
+
+// fuzzJobs returns n copies of each job, each with a random
+// combination of GIT_TEST_* settings added to its variant.
+func fuzzJobs(jobs []*job, n int, seed int64) []*job {
+	var result []*job
+	for _, j := range jobs {
+		for k := 1; k <= n; k++ {
+			env := fuzzEnv(seed, j.name, k)
+			var short []string
+			for _, e := range env {
+				short = append(short, strings.TrimPrefix(strings.SplitN(e, "=", 2)[0], "GIT_TEST_"))
+			}
+			if len(short) == 0 {
+				short = []string{"none"}
+			}
+
+			// Report fuzzed runs together with the other
+			// runs of their matrix variant.
+			group := ""
+			v := &variant{
+				name:   fmt.Sprintf("fuzz%d:%s", k, strings.Join(short, "+")),
+				subdir: fmt.Sprintf("fuzz%d", k),
+				env:    env,
+				parts:  env,
+				group:  &group,
+			}
+			if j.variant != nil {
+				group = j.variant.groupName()
+				v.name = j.variant.name + "," + v.name
+				v.subdir = filepath.Join(j.variant.dir(), v.subdir)
+				v.env = append(append([]string{}, j.variant.env...), env...)
+				v.parts = append(append([]string{}, j.variant.parts...), env...)
+				v.umask = j.variant.umask
+				v.root = j.variant.root
+				v.image = j.variant.image
+			}
+			result = append(result, &job{name: j.name, variant: v, iteration: j.iteration})
+		}
+	}
+	return result
+}
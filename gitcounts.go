@@ -0,0 +1,93 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitCounts are the counters test-lib.sh writes to
+// test-results/NAME.counts when a script finishes.
+type gitCounts struct {
+	total, success, fixed, broken, failed int
+}
+
+// readGitCounts reads the counts file of the script, if it was written
+// after start. File times are coarse, so allow for some slack.
+func readGitCounts(script string, start time.Time) *gitCounts {
+	dir := os.Getenv("TEST_OUTPUT_DIRECTORY")
+	if dir == "" {
+		dir = filepath.Dir(script)
+	}
+	name := filepath.Join(dir, "test-results", strings.TrimSuffix(filepath.Base(script), ".sh")+".counts")
+	if fi, err := os.Stat(name); err != nil || fi.ModTime().Before(start.Add(-time.Second)) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	c := &gitCounts{}
+	for _, l := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "total":
+			c.total = n
+		case "success":
+			c.success = n
+		case "fixed":
+			c.fixed = n
+		case "broken":
+			c.broken = n
+		case "failed":
+			c.failed = n
+		}
+	}
+	return c
+}
+
+// check compares the counters with what we saw in the TAP output, and
+// returns a description of the differences, or "". Fixed known
+// breakages show as passing TODO tests in TAP, and broken ones as
+// failing TODO tests.
+func (c *gitCounts) check(t *tapResult) string {
+	var diffs []string
+	if c.failed != t.failed {
+		diffs = append(diffs, fmt.Sprintf("failed %d, TAP %d", c.failed, t.failed))
+	}
+	if c.success+c.fixed != t.passed {
+		diffs = append(diffs, fmt.Sprintf("success+fixed %d, TAP passed %d", c.success+c.fixed, t.passed))
+	}
+	if c.broken != t.todo {
+		diffs = append(diffs, fmt.Sprintf("broken %d, TAP todo %d", c.broken, t.todo))
+	}
+	if c.total != t.count() {
+		diffs = append(diffs, fmt.Sprintf("total %d, TAP %d", c.total, t.count()))
+	}
+	return strings.Join(diffs, ", ")
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// logMatch is a matching line in a log.
+type logMatch struct {
+	line int
+	text string
+}
+
+// grepLog returns the lines of the file at path matching re, up to max
+// if it is positive.
+func grepLog(path string, re *regexp.Regexp, max int) ([]logMatch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var matches []logMatch
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	n := 0
+	for scanner.Scan() {
+		n++
+		if re.Match(scanner.Bytes()) {
+			matches = append(matches, logMatch{n, cleanText(scanner.Text(), "escape")})
+			if max > 0 && len(matches) >= max {
+				break
+			}
+		}
+	}
+	return matches, scanner.Err()
+}
+
+// grepMain implements "rungittest grep DIR PATTERN", which searches the
+// logs of a run.
+func grepMain(args []string) {
+	fs := flag.NewFlagSet("grep", flag.ExitOnError)
+	ignoreCase := fs.Bool("i", false, "ignore case")
+	failedOnly := fs.Bool("failed", false, "only search the logs of failed tests")
+	list := fs.Bool("l", false, "only list the matching tests")
+	max := fs.Int("max", 0, "show at most this many matches per test; 0 for all")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest grep [flags] DIR PATTERN\n\n"+
+			"Searches the logs of the run in DIR for the regular expression PATTERN,\n"+
+			"and prints the matches grouped by test, with the status of the test.\n"+
+			"Exits with status 1 if nothing matched.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir, pattern := fs.Arg(0), fs.Arg(1)
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Fatal(err)
+	}
+	run, err := readResults(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var results []*result
+	variants := map[string]*variant{}
+	for i := range run.Tests {
+		r := run.Tests[i].result(variants)
+		if !*failedOnly || r.failed() {
+			results = append(results, r)
+		}
+	}
+	matches := make([][]logMatch, len(results))
+	errs := make([]error, len(results))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				matches[i], errs[i] = grepLog(filepath.Join(dir, results[i].logFile), re, *max)
+			}
+		}()
+	}
+	for i := range results {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return results[order[a]].logFile < results[order[b]].logFile
+	})
+	found := 0
+	for _, i := range order {
+		r := results[i]
+		if errs[i] != nil {
+			log.Printf("%s: %v", r.label(), errs[i])
+		}
+		if len(matches[i]) == 0 {
+			continue
+		}
+		found++
+		if *list {
+			fmt.Printf("%s (%s)\n", r.label(), r.status)
+			continue
+		}
+		fmt.Printf("%s (%s) %s:\n", r.label(), r.status, filepath.Join(dir, r.logFile))
+		for _, m := range matches[i] {
+			fmt.Printf("  %d: %s\n", m.line, m.text)
+		}
+	}
+	if found == 0 {
+		os.Exit(1)
+	}
+	if !*list {
+		fmt.Printf("%d of %d tests match\n", found, len(results))
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The history DB is a file with a JSON line for each test run, which
+// every run appends to.
+
+type historyEntry struct {
+	Time     time.Time `json:"time"`
+	Name     string    `json:"name"`
+	Variant  string    `json:"variant,omitempty"`
+	Status   string    `json:"status"`
+	Duration float64   `json:"duration"`
+
+	// Skipped is the number of skipped test cases, and Missing
+	// the prerequisites that caused skips.
+	Skipped int      `json:"skipped,omitempty"`
+	Missing []string `json:"missing,omitempty"`
+
+	// Signature identifies the failure, see failureSignature.
+	Signature string `json:"signature,omitempty"`
+
+	// Run is the ID of the run the entry is from.
+	Run string `json:"run,omitempty"`
+}
+
+// historyWindow is the number of recent runs of a test that are
+// considered.
+const historyWindow = 20
+
+// history holds the recent runs of each test, oldest first, keyed by
+// label. signatures counts the failures in the whole DB, keyed by
+// label and signature separated by a NUL.
+type history struct {
+	runs       map[string][]historyEntry
+	signatures map[string]*signatureStats
+}
+
+// defaultHistoryPath returns where the history DB is kept unless
+// --history says otherwise.
+func defaultHistoryPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "rungittest", "history.jsonl")
+}
+
+// readHistory reads the history DB. A missing file is an empty
+// history.
+func readHistory(path string) (*history, error) {
+	h := &history{runs: map[string][]historyEntry{}, signatures: map[string]*signatureStats{}}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = scanHistory(f, func(e historyEntry) {
+		var v *variant
+		if e.Variant != "" {
+			v = &variant{name: e.Variant}
+		}
+		l := label(e.Name, v)
+		runs := append(h.runs[l], e)
+		if len(runs) > historyWindow {
+			runs = runs[1:]
+		}
+		h.runs[l] = runs
+		if e.Signature != "" {
+			key := l + "\x00" + e.Signature
+			st := h.signatures[key]
+			if st == nil {
+				st = &signatureStats{first: e.Time}
+				h.signatures[key] = st
+			}
+			st.count++
+		}
+	})
+	return h, err
+}
+
+// scanHistory calls fn for each entry of the history DB read from r.
+func scanHistory(r io.Reader, fn func(historyEntry)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var e historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Skip lines torn by a crash.
+			continue
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
+
+// appendHistory adds the results of a run to the history DB. Cancelled
+// tests say nothing about the test, and are left out.
+func appendHistory(path, run string, results []*result) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		if r.status == statusCancelled {
+			continue
+		}
+		e := historyEntryFor(r)
+		e.Run = run
+		if err := enc.Encode(&e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// duration estimates how long the test takes from its recent passing
+// runs.
+func (h *history) duration(label string) (time.Duration, bool) {
+	var sum float64
+	n := 0
+	for _, e := range h.runs[label] {
+		if e.Status == statusOK || e.Status == statusSkipped {
+			sum += e.Duration
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return time.Duration(sum / float64(n) * float64(time.Second)), true
+}
+
+// failRate returns the fraction of recent runs of the test that did
+// not pass, weighing recent runs more.
+func (h *history) failRate(label string) float64 {
+	runs := h.runs[label]
+	var total, failed float64
+	for i, e := range runs {
+		w := float64(i + 1)
+		total += w
+		if e.Status != statusOK && e.Status != statusSkipped {
+			failed += w
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return failed / total
+}
+
+// lastFailed returns true if the most recent run of the test did not
+// pass.
+func (h *history) lastFailed(label string) bool {
+	runs := h.runs[label]
+	if len(runs) == 0 {
+		return false
+	}
+	st := runs[len(runs)-1].Status
+	return st != statusOK && st != statusSkipped
+}
+
+// medianDuration returns the median estimated duration over all
+// tests with passing runs, or def if there are none.
+func (h *history) medianDuration(def time.Duration) time.Duration {
+	var ds []time.Duration
+	for l := range h.runs {
+		if d, ok := h.duration(l); ok {
+			ds = append(ds, d)
+		}
+	}
+	if len(ds) == 0 {
+		return def
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[len(ds)/2]
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// scratchHome creates a fresh home directory for a test under the
+// output dir, and returns it with the environment pointing HOME, the
+// XDG base directories and GNUPGHOME into it, so the settings of the
+// user running the tests cannot leak into them.
+func scratchHome(outdir string, j *job) (string, []string, error) {
+	dir, err := filepath.Abs(filepath.Join(outdir, "home", strings.TrimSuffix(j.logName(), ".log")))
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, err
+	}
+	env := []string{"HOME=" + dir}
+	for _, d := range []struct{ name, path string }{
+		{"XDG_CONFIG_HOME", ".config"},
+		{"XDG_CACHE_HOME", ".cache"},
+		{"XDG_DATA_HOME", ".local/share"},
+		{"XDG_STATE_HOME", ".local/state"},
+		{"GNUPGHOME", ".gnupg"},
+	} {
+		p := filepath.Join(dir, d.path)
+		// gpg refuses to use a home others can read.
+		if err := os.MkdirAll(p, 0700); err != nil {
+			return "", nil, err
+		}
+		env = append(env, d.name+"="+p)
+	}
+	return dir, env, nil
+}
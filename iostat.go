@@ -0,0 +1,96 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ioStats are the I/O counters of a test and its children. Fields are
+// -1 where unknown.
+type ioStats struct {
+	// diskRead and diskWritten are the bytes that reached the
+	// block layer, from the rusage of the test.
+	diskRead, diskWritten int64
+
+	// read and written are the bytes passed to read(2), write(2)
+	// and the like, including what the page cache served, and
+	// syscalls the number of these calls. They are sampled from
+	// /proc/PID/io while the test runs, so they miss the last
+	// moments and processes that escaped the process tree.
+	read, written, syscalls int64
+
+	// wait is how long the processes were blocked on I/O, as far
+	// as seen by the samples. It needs delay accounting
+	// (sysctl kernel.task_delayacct=1).
+	wait time.Duration
+}
+
+// String formats the known counters, eg. "disk read 0, disk written
+// 12.5M, read 80.1M, written 13M, 5120 syscalls".
+func (s *ioStats) String() string {
+	var parts []string
+	add := func(name string, n int64) {
+		if n >= 0 {
+			parts = append(parts, fmt.Sprintf("%s %s", name, formatSize(n)))
+		}
+	}
+	add("disk read", s.diskRead)
+	add("disk written", s.diskWritten)
+	add("read", s.read)
+	add("written", s.written)
+	if s.syscalls >= 0 {
+		parts = append(parts, fmt.Sprintf("%d syscalls", s.syscalls))
+	}
+	if s.wait > 0 {
+		parts = append(parts, fmt.Sprintf("io wait %s", s.wait))
+	}
+	if len(parts) == 0 {
+		return "unknown"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// disk returns the bytes read from and written to disk, or -1 if
+// unknown.
+func (s *ioStats) disk() int64 {
+	if s.diskRead < 0 || s.diskWritten < 0 {
+		return -1
+	}
+	return s.diskRead + s.diskWritten
+}
+
+// mostIO lists the tests that did the most disk I/O, the candidates
+// for running on a tmpfs.
+func mostIO(results []*result) []string {
+	var rs []*result
+	for _, r := range results {
+		if r.io != nil && r.io.disk() > 0 {
+			rs = append(rs, r)
+		}
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].io.disk() > rs[j].io.disk() })
+	if len(rs) > slowestCount {
+		rs = rs[:slowestCount]
+	}
+	var lines []string
+	for _, r := range rs {
+		lines = append(lines, fmt.Sprintf("%-20s - %s", r.label(), r.io))
+	}
+	return lines
+}
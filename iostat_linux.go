@@ -0,0 +1,227 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ioSampleInterval = 500 * time.Millisecond
+
+	// clockTicks is USER_HZ, the unit of the times in
+	// /proc/PID/stat.
+	clockTicks = 100
+)
+
+// ioWatch collects the /proc/PID/io counters of a running test. A
+// process's counters include those of the children it waited for, so
+// their sum over the live processes of the test only drops when an
+// orphan is reaped by init; we keep the largest sum seen.
+type ioWatch struct {
+	root    int
+	sampled bool
+	most    procIO
+
+	// waits is the I/O delay of each process seen, by pid and
+	// start time. Unlike the counters, it is not passed on to the
+	// parent.
+	waits map[string]time.Duration
+}
+
+type procIO struct {
+	read, written, syscalls int64
+}
+
+var ioWatches struct {
+	sync.Mutex
+	byPid   map[int]*ioWatch
+	running bool
+}
+
+// watchIO starts sampling the I/O of the test with the given pid and
+// its children.
+func watchIO(pid int) *ioWatch {
+	w := &ioWatch{root: pid, waits: map[string]time.Duration{}}
+	ioWatches.Lock()
+	defer ioWatches.Unlock()
+	if ioWatches.byPid == nil {
+		ioWatches.byPid = map[int]*ioWatch{}
+	}
+	ioWatches.byPid[pid] = w
+	if !ioWatches.running {
+		ioWatches.running = true
+		go sampleIOLoop()
+	}
+	return w
+}
+
+// stop ends the watch and fills in the sampled counters of s.
+func (w *ioWatch) stop(s *ioStats) {
+	ioWatches.Lock()
+	defer ioWatches.Unlock()
+	delete(ioWatches.byPid, w.root)
+	if !w.sampled {
+		return
+	}
+	s.read, s.written, s.syscalls = w.most.read, w.most.written, w.most.syscalls
+	for _, d := range w.waits {
+		s.wait += d
+	}
+}
+
+func sampleIOLoop() {
+	for {
+		time.Sleep(ioSampleInterval)
+		ioWatches.Lock()
+		if len(ioWatches.byPid) == 0 {
+			ioWatches.running = false
+			ioWatches.Unlock()
+			return
+		}
+		sampleIO(ioWatches.byPid)
+		ioWatches.Unlock()
+	}
+}
+
+type procStat struct {
+	ppid, pgrp int
+	start      string
+	blkio      int64
+}
+
+// readProcStat reads the fields we need from /proc/PID/stat.
+func readProcStat(pid int) (procStat, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, err
+	}
+	// The command name may contain anything, but is followed by
+	// the last ')'. Field N of proc(5) is at N-3 after it.
+	s := string(data)
+	fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+	if len(fields) < 40 {
+		return procStat{}, fmt.Errorf("pid %d: short stat", pid)
+	}
+	var st procStat
+	st.ppid, _ = strconv.Atoi(fields[1])
+	st.pgrp, _ = strconv.Atoi(fields[2])
+	st.start = fields[19]
+	st.blkio, _ = strconv.ParseInt(fields[39], 10, 64)
+	return st, nil
+}
+
+// readProcIO reads /proc/PID/io.
+func readProcIO(pid int) (procIO, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return procIO{}, err
+	}
+	var p procIO
+	for _, l := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) != 2 {
+			continue
+		}
+		n, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "rchar:":
+			p.read = n
+		case "wchar:":
+			p.written = n
+		case "syscr:", "syscw:":
+			p.syscalls += n
+		}
+	}
+	return p, nil
+}
+
+// sampleIO attributes the processes to the watched tests, by process
+// group or ancestry, and adds up their counters.
+func sampleIO(watches map[int]*ioWatch) {
+	d, err := os.Open("/proc")
+	if err != nil {
+		return
+	}
+	names, _ := d.Readdirnames(-1)
+	d.Close()
+	stats := map[int]procStat{}
+	for _, n := range names {
+		pid, err := strconv.Atoi(n)
+		if err != nil {
+			continue
+		}
+		if st, err := readProcStat(pid); err == nil {
+			stats[pid] = st
+		}
+	}
+	owner := func(pid int) *ioWatch {
+		if w := watches[stats[pid].pgrp]; w != nil {
+			return w
+		}
+		for depth := 0; pid > 1 && depth < 100; depth++ {
+			if w := watches[pid]; w != nil {
+				return w
+			}
+			st, ok := stats[pid]
+			if !ok {
+				break
+			}
+			pid = st.ppid
+		}
+		return nil
+	}
+
+	sums := map[*ioWatch]*procIO{}
+	for pid, st := range stats {
+		w := owner(pid)
+		if w == nil {
+			continue
+		}
+		p, err := readProcIO(pid)
+		if err != nil {
+			continue
+		}
+		sum := sums[w]
+		if sum == nil {
+			sum = &procIO{}
+			sums[w] = sum
+		}
+		sum.read += p.read
+		sum.written += p.written
+		sum.syscalls += p.syscalls
+		if st.blkio > 0 {
+			w.waits[fmt.Sprintf("%d %s", pid, st.start)] = time.Duration(st.blkio) * time.Second / clockTicks
+		}
+	}
+	for w, sum := range sums {
+		w.sampled = true
+		if sum.read > w.most.read {
+			w.most.read = sum.read
+		}
+		if sum.written > w.most.written {
+			w.most.written = sum.written
+		}
+		if sum.syscalls > w.most.syscalls {
+			w.most.syscalls = sum.syscalls
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// isolatedRoot returns a fresh directory to use as --root for the
+// job.
+func isolatedRoot(outdir string, j *job) (string, error) {
+	base := outdir
+	if j.variant != nil && j.variant.root != "" {
+		base = j.variant.root
+	}
+	dir, err := filepath.Abs(filepath.Join(base, "isolated", strings.TrimSuffix(j.logName(), ".log")))
+	if err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// concurrentWith returns the labels of the results that ran at the
+// same time as r.
+func concurrentWith(r *result, results []*result) []string {
+	end := r.start.Add(r.duration)
+	var labels []string
+	for _, o := range results {
+		if o == r || o.start.IsZero() {
+			continue
+		}
+		if o.start.Before(end) && r.start.Before(o.start.Add(o.duration)) {
+			labels = append(labels, o.label())
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
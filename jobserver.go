@@ -0,0 +1,166 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// jobserver is a GNU make style jobserver: a pipe holding a token byte
+// for each job that may run besides the first, which needs none. Tests
+// take a token while they run, and the make processes they start find
+// the jobserver through MAKEFLAGS, so nested parallelism draws from
+// the same pool.
+type jobserver struct {
+	r, w *os.File
+
+	// makeflags is MAKEFLAGS for the tests.
+	makeflags string
+
+	// fifo is the path of the fifo, if the jobserver uses one.
+	fifo string
+
+	// free holds the token we get for free while it is not in use.
+	free chan struct{}
+}
+
+// jobserverFifo is the name of the fifo newJobserver creates.
+const jobserverFifo = "jobserver.fifo"
+
+func freeToken() chan struct{} {
+	c := make(chan struct{}, 1)
+	c <- struct{}{}
+	return c
+}
+
+// newJobserver creates a jobserver for n jobs, as a fifo in dir.
+func newJobserver(n int, dir string) (*jobserver, error) {
+	path := filepath.Join(dir, jobserverFifo)
+	os.Remove(path)
+	if err := mkfifo(path); err != nil {
+		return nil, err
+	}
+	// Opening for reading and writing does not block on a fifo.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write([]byte(strings.Repeat("+", n-1))); err != nil {
+		f.Close()
+		return nil, err
+	}
+	flags := fmt.Sprintf("-j%d --jobserver-auth=fifo:%s", n, path)
+	if old := os.Getenv("MAKEFLAGS"); old != "" {
+		flags = old + " " + flags
+	}
+	return &jobserver{r: f, w: f, makeflags: flags, fifo: path, free: freeToken()}, nil
+}
+
+// connectJobserver joins the jobserver of a make running us, as
+// described by MAKEFLAGS, or returns nil if there is none.
+func connectJobserver(makeflags string) (*jobserver, error) {
+	var auth string
+	for _, f := range strings.Fields(makeflags) {
+		for _, prefix := range []string{"--jobserver-auth=", "--jobserver-fds="} {
+			if strings.HasPrefix(f, prefix) {
+				auth = strings.TrimPrefix(f, prefix)
+			}
+		}
+	}
+	if auth == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(auth, "fifo:") {
+		path := strings.TrimPrefix(auth, "fifo:")
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &jobserver{r: f, w: f, makeflags: makeflags, fifo: path, free: freeToken()}, nil
+	}
+	fds := strings.Split(auth, ",")
+	if len(fds) != 2 {
+		return nil, fmt.Errorf("bad --jobserver-auth %q", auth)
+	}
+	rfd, err1 := strconv.Atoi(fds[0])
+	wfd, err2 := strconv.Atoi(fds[1])
+	if err1 != nil || err2 != nil || rfd < 0 || wfd < 0 {
+		// make -n and recursive make without "+" pass -2,-2.
+		return nil, fmt.Errorf("bad --jobserver-auth %q", auth)
+	}
+	js := &jobserver{
+		r:    os.NewFile(uintptr(rfd), "jobserver-r"),
+		w:    os.NewFile(uintptr(wfd), "jobserver-w"),
+		free: freeToken(),
+	}
+	if _, err := js.r.Stat(); err != nil {
+		return nil, fmt.Errorf("jobserver descriptors are not open; mark the make rule with \"+\"")
+	}
+	// The descriptors are not passed on to the tests, so nested
+	// makes cannot use them; they run serially instead of
+	// oversubscribing.
+	var flags []string
+	for _, f := range strings.Fields(makeflags) {
+		if !strings.HasPrefix(f, "--jobserver-") && !strings.HasPrefix(f, "-j") {
+			flags = append(flags, f)
+		}
+	}
+	js.makeflags = strings.Join(flags, " ")
+	return js, nil
+}
+
+// acquire waits for a token, and returns the function to give it back.
+func (js *jobserver) acquire(ctx context.Context) (func(), error) {
+	releaseFree := func() { js.free <- struct{}{} }
+	select {
+	case <-js.free:
+		return releaseFree, nil
+	default:
+	}
+
+	got := make(chan error, 1)
+	var token [1]byte
+	go func() {
+		_, err := js.r.Read(token[:])
+		got <- err
+	}()
+	release := func() { js.w.Write(token[:]) }
+	// giveBack returns the token once the read gets one.
+	giveBack := func() {
+		go func() {
+			if <-got == nil {
+				release()
+			}
+		}()
+	}
+	select {
+	case err := <-got:
+		if err != nil {
+			return nil, err
+		}
+		return release, nil
+	case <-js.free:
+		giveBack()
+		return releaseFree, nil
+	case <-ctx.Done():
+		giveBack()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// leak is something a test left behind in the test root.
+type leak struct {
+	path string
+
+	// sockets is the number of sockets in it.
+	sockets int
+}
+
+func (l leak) String() string {
+	if l.sockets > 0 {
+		return fmt.Sprintf("%s (%d sockets)", l.path, l.sockets)
+	}
+	return l.path
+}
+
+// findLeaks returns what a passing test left behind in the test root:
+// entries named after it, like its trash directory. Failing tests
+// keep their trash directory on purpose, so only call this for tests
+// that passed.
+func findLeaks(root, name string) []leak {
+	base := strings.TrimSuffix(filepath.Base(name), ".sh")
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	var leaks []leak
+	for _, e := range entries {
+		if !strings.Contains(e.Name(), base) || e.Name() == base+".sh" {
+			continue
+		}
+		l := leak{path: filepath.Join(root, e.Name())}
+		if e.Type()&os.ModeSocket != 0 {
+			l.sockets = 1
+		} else if e.IsDir() {
+			l.sockets = countSockets(l.path)
+		}
+		leaks = append(leaks, l)
+	}
+	return leaks
+}
+
+func countSockets(dir string) int {
+	n := 0
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode()&os.ModeSocket != 0 {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// cleanLeaks removes the leftovers found by findLeaks.
+func cleanLeaks(leaks []leak) error {
+	for _, l := range leaks {
+		if err := os.RemoveAll(l.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// lintCommands returns the commands that check a test script: "sh -n"
+// for syntax errors, and git's chainlint.pl for broken &&-chains if it
+// is next to the script and perl is available.
+func lintCommands(script, shell string) [][]string {
+	cmds := [][]string{{shell, "-n", script}}
+	cl := filepath.Join(filepath.Dir(script), "chainlint.pl")
+	if _, err := os.Stat(cl); err == nil {
+		if perl, err := exec.LookPath("perl"); err == nil {
+			cmds = append(cmds, []string{perl, cl, script})
+		}
+	}
+	return cmds
+}
+
+// lintScripts checks the scripts in parallel, and returns the output
+// for the scripts that failed.
+func lintScripts(scripts []string, jobs int, shell string) map[string]string {
+	failures := map[string]string{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, jobs)
+	for _, s := range scripts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(s string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for _, argv := range lintCommands(s, shell) {
+				out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput()
+				if err == nil {
+					continue
+				}
+				msg := strings.TrimRight(string(out), "\n")
+				if msg == "" {
+					msg = err.Error()
+				}
+				mu.Lock()
+				failures[s] = msg
+				mu.Unlock()
+				return
+			}
+		}(s)
+	}
+	wg.Wait()
+	return failures
+}
+
+// formatLint renders the lint failures, sorted by script.
+func formatLint(failures map[string]string) string {
+	var names []string
+	for n := range failures {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	out := ""
+	for _, n := range names {
+		out += "*** " + n + " ***\n" + failures[n] + "\n\n"
+	}
+	return out
+}
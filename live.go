@@ -0,0 +1,349 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// liveEvent is sent to the browsers following a run with --serve.
+type liveEvent struct {
+	Type string `json:"type"`
+
+	// For "state", sent first on each connection.
+	RunID    string     `json:"run_id,omitempty"`
+	Total    int        `json:"total,omitempty"`
+	Running  []liveTest `json:"running,omitempty"`
+	Finished []liveTest `json:"finished,omitempty"`
+	Done     bool       `json:"done,omitempty"`
+	Start    time.Time  `json:"start,omitempty"`
+
+	// For "started" and "finished".
+	Test *liveTest `json:"test,omitempty"`
+}
+
+type liveTest struct {
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	Status   string    `json:"status,omitempty"`
+	Failed   bool      `json:"failed,omitempty"`
+	Summary  string    `json:"summary,omitempty"`
+	Duration float64   `json:"duration,omitempty"`
+	Log      string    `json:"log,omitempty"`
+}
+
+// liveReporter keeps the state of the run for --serve, and pushes the
+// changes to the connected browsers as server-sent events.
+type liveReporter struct {
+	runID   string
+	mu      sync.Mutex
+	start   time.Time
+	total   int
+	running map[string]time.Time
+	results []liveTest
+	over    bool
+	clients map[chan []byte]bool
+
+	// streams counts the open event streams.
+	streams sync.WaitGroup
+}
+
+func newLiveReporter(runID string, total int) *liveReporter {
+	return &liveReporter{
+		runID:   runID,
+		start:   time.Now(),
+		total:   total,
+		running: map[string]time.Time{},
+		clients: map[chan []byte]bool{},
+	}
+}
+
+// send must be called with mu held. Clients that fall behind are
+// dropped; their browser reconnects and gets the state afresh.
+func (l *liveReporter) send(ev *liveEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("--serve: %v", err)
+		return
+	}
+	for c := range l.clients {
+		select {
+		case c <- data:
+		default:
+			delete(l.clients, c)
+			close(c)
+		}
+	}
+}
+
+func (l *liveReporter) started(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.running[name] = now
+	l.send(&liveEvent{Type: "started", Test: &liveTest{Name: name, Start: now}})
+}
+
+func (l *liveReporter) finished(r *result) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.running, r.label())
+	t := liveTest{
+		Name:     r.label(),
+		Start:    r.start,
+		Status:   r.status,
+		Failed:   r.failed(),
+		Summary:  r.summary,
+		Duration: r.duration.Seconds(),
+		Log:      filepath.ToSlash(r.logFile),
+	}
+	l.results = append(l.results, t)
+	l.send(&liveEvent{Type: "finished", Test: &t})
+}
+
+// done tells the browsers the run is over, giving them a moment to get
+// the news before we exit.
+func (l *liveReporter) done() {
+	l.mu.Lock()
+	l.over = true
+	l.send(&liveEvent{Type: "done"})
+	for c := range l.clients {
+		delete(l.clients, c)
+		close(c)
+	}
+	l.mu.Unlock()
+
+	flushed := make(chan struct{})
+	go func() {
+		l.streams.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+	}
+}
+
+// state must be called with mu held.
+func (l *liveReporter) state() *liveEvent {
+	ev := &liveEvent{
+		Type:     "state",
+		RunID:    l.runID,
+		Total:    l.total,
+		Start:    l.start,
+		Done:     l.over,
+		Finished: append([]liveTest{}, l.results...),
+	}
+	for name, start := range l.running {
+		ev.Running = append(ev.Running, liveTest{Name: name, Start: start})
+	}
+	return ev
+}
+
+func (l *liveReporter) events(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	l.streams.Add(1)
+	defer l.streams.Done()
+	c := make(chan []byte, 256)
+	l.mu.Lock()
+	state, err := json.Marshal(l.state())
+	over := l.over
+	if !over {
+		l.clients[c] = true
+	}
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.clients[c] {
+			delete(l.clients, c)
+			close(c)
+		}
+	}()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "data: %s\n\n", state)
+	flusher.Flush()
+	if over {
+		return
+	}
+	for {
+		select {
+		case data, ok := <-c:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// serveLive serves a live view of the run on addr, along with the files
+// of the output dir and the history API.
+func serveLive(addr, outdir, historyPath string, l *liveReporter) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	if historyPath != "" {
+		mux.Handle("/api/", historyAPI(historyPath))
+	}
+	mux.HandleFunc("/events", l.events)
+	files := http.FileServer(http.Dir(outdir))
+	mux.Handle("/files/", http.StripPrefix("/files/", files))
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			files.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(livePage))
+	})
+	log.Printf("--serve: live view on http://%s/", ln.Addr())
+	go func() {
+		log.Printf("--serve: %v", http.Serve(ln, mux))
+	}()
+	return nil
+}
+
+const livePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>rungittest (live)</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { padding: 0.2em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+.failed { color: #b00; } .ok { color: #070; } .other { color: #777; }
+</style>
+</head>
+<body>
+<h1 id="title">rungittest</h1>
+<p id="counts"></p>
+<p id="run"></p>
+<p><a href="summary.txt">summary.txt</a> <a href="results.json">results.json</a> <a href="files/">all files</a></p>
+<h2>Running</h2>
+<table id="running"><tr><th>Test</th><th>For</th></tr></table>
+<h2>Failed</h2>
+<table id="failed"><tr><th>Test</th><th>Status</th><th>Duration</th><th>Summary</th></tr></table>
+<h2>Finished</h2>
+<table id="finished"><tr><th>Test</th><th>Status</th><th>Duration</th><th>Summary</th></tr></table>
+<script>
+var total = 0, start = null, done = false, running = {}, finished = [];
+
+function cell(row, text, cls) {
+  var td = row.insertCell();
+  td.textContent = text;
+  if (cls) td.className = cls;
+  return td;
+}
+
+function testRow(table, t) {
+  var row = table.insertRow(1);
+  var a = document.createElement("a");
+  a.href = encodeURI(t.log);
+  a.textContent = t.name;
+  row.insertCell().appendChild(a);
+  cell(row, t.status, t.failed ? "failed" : (t.status == "ok" ? "ok" : "other"));
+  cell(row, t.duration.toFixed(1) + "s");
+  cell(row, t.summary || "");
+}
+
+function clear(table) {
+  while (table.rows.length > 1) table.deleteRow(1);
+}
+
+function add(t) {
+  finished.push(t);
+  testRow(document.getElementById("finished"), t);
+  if (t.failed) testRow(document.getElementById("failed"), t);
+}
+
+function tick() {
+  var table = document.getElementById("running");
+  clear(table);
+  var now = Date.now();
+  Object.keys(running).sort().forEach(function(name) {
+    var row = table.insertRow();
+    cell(row, name);
+    cell(row, Math.round((now - running[name]) / 1000) + "s");
+  });
+  var failed = finished.filter(function(t) { return t.failed; }).length;
+  var elapsed = start ? Math.round((now - start) / 1000) : 0;
+  document.getElementById("counts").textContent = finished.length + "/" + total +
+    " finished, " + failed + " failed, " + Object.keys(running).length + " running, elapsed " +
+    elapsed + "s" + (done ? " (done)" : "");
+  document.getElementById("title").textContent = "rungittest: " + failed + " failed" + (done ? "" : " (live)");
+}
+
+var events = new EventSource("events");
+events.onerror = function() {
+  if (!done) document.getElementById("title").textContent = "rungittest (disconnected)";
+};
+events.onmessage = function(msg) {
+  var ev = JSON.parse(msg.data);
+  switch (ev.type) {
+  case "state":
+    total = ev.total;
+    document.getElementById("run").textContent = "run " + ev.run_id;
+    start = Date.parse(ev.start);
+    done = ev.done;
+    running = {};
+    (ev.running || []).forEach(function(t) { running[t.name] = Date.parse(t.start); });
+    finished = [];
+    clear(document.getElementById("finished"));
+    clear(document.getElementById("failed"));
+    (ev.finished || []).forEach(add);
+    break;
+  case "started":
+    running[ev.test.name] = Date.parse(ev.test.start);
+    break;
+  case "finished":
+    delete running[ev.test.name];
+    add(ev.test);
+    break;
+  case "done":
+    done = true;
+    events.close();
+    break;
+  }
+  tick();
+};
+setInterval(tick, 1000);
+</script>
+</body>
+</html>
+`
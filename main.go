@@ -21,136 +21,2225 @@
      go run ~/vc/rungittest/main.go --outdir results.6cb5e6e7b8e 't00*sh'
 
   this will run t00*.sh and leave log files in results.6cb5e6e7b8e.
+
+  With --chdir, globs and the output dir are relative to the given
+  directory instead, so the following is equivalent:
+
+     go run ~/vc/rungittest/main.go --chdir ~/git/t --outdir results.6cb5e6e7b8e 't00*sh'
+
+  If the globs match no tests, rungittest fails rather than reporting
+  an empty run.
+
+  --select NAME=GLOB adds the tests matching GLOB to the run under the
+  label NAME, and summary.txt, results.json and the Markdown summary
+  break the results down per label, eg. for reviewers of different
+  areas:
+
+     rungittest --outdir out --select smoke='t00*.sh' --select net='t55*.sh'
+
+  --area-report prints a table of the results per area of git's test
+  numbering (t0xxx basics, t5xxx pull and exporting commands, ...) with
+  pass rates and total test time, and writes it to areas.txt.
+
+  --duration-report shows where the time goes: a histogram of the test
+  durations, how much of the total test time the slowest 1, 2, 4, ...
+  tests take, and the share of each area and duration bucket, in
+  durations.txt. A line like "slowest 8 tests: 12m3s, 40.2% of the
+  test time" points at the tests worth speeding up or splitting.
+
+  --critical-path compares the wall-clock time with its lower bound:
+  the longest test, or the test time spread evenly over the --jobs
+  slots if that is more. It lists the largest stretches of idle slots,
+  and what caused them: a long test started late while the others had
+  run out, or tests held back by --weights, --constraints or
+  throttling. "rungittest simulate" tries other orders.
+
+  --skip-tests takes a GIT_SKIP_TESTS style list of patterns. Matching
+  scripts are not run at all, and the patterns are passed on to the
+  tests as GIT_SKIP_TESTS, so "t9100.3" style entries skip individual
+  test cases. --print-skip-tests prints a GIT_SKIP_TESTS value covering
+  all failing scripts at the end of the run.
+
+  With --strict-stderr, a test that passes but writes anything to
+  stderr other than blank lines, "sh -x" traces and the regular
+  expressions listed in the --stderr-allow file fails, which catches
+  new warnings that the tests do not check for.
+
+  Scripts that skip all of their tests (eg. because of missing
+  prerequisites) are reported as "skipped", and summary.txt lists the
+  prerequisites that were missing across the run.
+
+  results.json also sorts the reasons for skipping into kinds, per test
+  and in total for the run, so the gaps of CI images can be compared
+  by a script: "prereq" (a missing prerequisite, eg. PERL), "opt-in"
+  (needs eg. GIT_TEST_HTTPD or EXPENSIVE), "dependency" (a tool or
+  service is not installed, eg. httpd or svn), "environment" (eg. the
+  filesystem or permissions), "platform", "skip-list"
+  (GIT_SKIP_TESTS), "run-filter" (--run) and "other", along with a
+  key and the original text.
+
+  Skips are compared with the last run of each test in the history, or
+  with the results.json of the --baseline output dir. Scripts that
+  started skipping altogether or skip more test cases, eg. because a
+  tool vanished from the CI image, are listed as "newly skipped" with
+  the prerequisites that went missing.
+
+  Each test gets RUNGITTEST_ARTIFACT_DIR in its environment, a
+  directory under artifacts/ in the output dir. Files that the test or
+  its hooks leave there (repositories, packfiles, dumps of state) are
+  kept for post-mortems and listed in the log and results.json.
+
+  Each test also gets a fresh HOME under home/ in the output dir, with
+  XDG_CONFIG_HOME, the other XDG base directories and GNUPGHOME inside
+  it, so a ~/.gitconfig or gpg keyring of the user running the tests
+  cannot affect them, even in commands run before test-lib.sh sets up
+  its own HOME. The directory is removed unless the test fails.
+  --isolate-home=false turns this off.
+
+  --run-as=USER, for root, eg. in a container, runs the tests as an
+  unprivileged user, as several tests behave differently as root or
+  are unsafe to run as root. rungittest itself keeps running as root,
+  for namespace and cgroup setup, and hands the directories it creates
+  for each test to USER. The test scripts' directory (for test-results
+  and trash directories) and any --root must be writable by USER.
+
+  --sandbox, on Linux 5.13 or later, confines tests with Landlock: they
+  can read everything, but write only below the test root, the output
+  directory, test-results, /dev/null and the terminal, and the paths
+  given with --sandbox-allow. They also get a network namespace of
+  their own with only the loopback device, so the httpd and daemon
+  tests work but nothing reaches outside. A test that touches files
+  outside its trash directory fails with "Permission denied".
+
+  --faketime runs every test under libfaketime, to exercise
+  date-sensitive code (expiry, reflog pruning, certificate checks)
+  throughout the suite: --faketime=+1y shifts the clock a year ahead,
+  --faketime="@2038-01-19 03:14:00" starts it at that time, --faketime=x10
+  makes it run ten times as fast, and --faketime=+1y,x10 does both. The
+  setting is noted in summary.txt and in results.json for each test.
+
+  For comparing runs across machines, results.json records for each
+  test the lazy prerequisites test-lib.sh reported as satisfied or not
+  (with --verbose) and the environment variables matching
+  --fingerprint-env, by default GIT_TEST_*, GIT_SKIP_TESTS, LANG,
+  LC_ALL and TZ.
+
+  Failures are recognized by a signature: the first failing test case
+  (or the status and summary), with numbers and object IDs masked.
+  Failures whose signature is in the history are listed under
+  "recurring or known failures" with how often and since when they
+  were seen. The --known-issues file links failures to bugs, with lines such as
+
+    t5310           https://bugs.example.com/123  bitmap.*corrupt
+    t9*-svn-*       https://bugs.example.com/456
+
+  giving a GIT_SKIP_TESTS style pattern, the link, and optionally a
+  regular expression the signature must match. A .yaml or .yml file
+  lists entries with a test pattern and/or a signature regular
+  expression, and a url and/or a note:
+
+    - test: t5310-pack-bitmaps
+      signature: "bitmap.*corrupt"
+      url: https://bugs.example.com/123
+      note: racy on NFS
+
+  Matching failures are marked as known issues in summary.txt,
+  results.json, the Markdown summary, --tap-out and the CI messages.
+  With --ignore-known, they do not count for the exit status.
+
+  With --email, a digest of the run is mailed through --smtp after the
+  run: the counts, the failures that are new compared to the baseline
+  (see above), the flaky tests, and the --email-link URL where the
+  output dir is uploaded. RUNGITTEST_SMTP_USER and
+  RUNGITTEST_SMTP_PASSWORD are used to log in to the server if set.
+
+  With --watch, the tests are rerun whenever files change, for a quick
+  edit-test loop: edited scripts are rerun, and changes to
+  test-lib*.sh, lib-*.sh or the built git and test-tool rerun the
+  failing tests, or all tests if none failed. The files are checked
+  every second, and each run overwrites the output dir.
+
+  --notify-desktop shows a notification with the counts when the run
+  ends, using notify-send (libnotify) or osascript on macOS, for long
+  runs in a background terminal.
+
+  --notify-on-failure=URL posts failures to a webhook as they happen,
+  rather than when a nightly run ends hours later. The first failure
+  starts a batch that is posted as one JSON event after --notify-batch
+  (30s), with the test, status, summary and log of each failure, and
+  whatever is pending is posted when the run ends.
+
+  Each run gets an ID, such as 20261014T153934Z-1a2b3c4d, which sorts
+  by start time. It is written to meta.json in the output dir as soon
+  as the run starts, and recorded in results.json, summary.txt, the
+  history DB, the OTLP trace, /debug/vars and the notifications, so
+  they can all be matched up. CI can assign the ID through
+  RUNGITTEST_RUN_ID, which the tests also get.
+
+  --append keeps the results already in the output dir, so a quick
+  subset now, the rest later and targeted reruns add up to one
+  summary.txt and results.json. A test that is run again replaces its
+  earlier result. The exit code, history and notifications are about
+  the tests of the current invocation.
+
+  A script that exits successfully but whose TAP plan ("1..N") is
+  missing or does not match the number of test results is counted as a
+  failure with status "bad plan".
+
+  On a terminal, progress is shown by overwriting a single line. When
+  stdout is redirected (CI, "| tee") or TERM=dumb, each finished test
+  gets its own line with a timestamp instead; --progress overrides
+  this. Every --status-interval, a line with the counts so far and the
+  failing tests is added, so a CI log can be checked at a glance.
+
+  While running, a control socket is available in the output directory:
+
+     rungittest ctl results.6cb5e6e7b8e status
+
+  See "rungittest ctl DIR help" for the available commands. SIGUSR1
+  and SIGUSR2 add and remove a worker slot; when removing, running tests
+  are allowed to finish.
+
+  With --detect-oom, a failed test is classified as "oom" if the OOM
+  killer fired (in our cgroup, or system-wide) while it ran and the
+  test was killed by SIGKILL or reports "Killed". This is a heuristic:
+  with many jobs, an OOM kill can be attributed to the wrong test.
+  --oom-retry reruns such tests one at a time after the main run.
+
+  With --jobserver, tests take a token of a GNU make style jobserver
+  while they run, and get it in MAKEFLAGS, so a make -j started by a
+  test draws from the same pool and the total parallelism stays within
+  --jobs. If rungittest itself runs under make -jN (from a rule using
+  $(MAKE) or marked "+"), it joins make's jobserver instead. The
+  jobserver is a fifo, which make 4.4 or later is needed for.
+
+  --retry-on=CLASS,... reruns failures of the given classes once after
+  the main run: "signal" for scripts that crashed or ran a command that
+  died of a signal (eg. SIGBUS on a flaky NFS mount), "timeout", "oom"
+  and "hung". Failed assertions are never retried. Tests that pass on
+  the retry count as flaky for --fail-on. Retries start once the main
+  run is done; as many failures are caused by load, --retry-serial
+  runs them one at a time, and --retry-delay waits before each retry
+  (including those of --oom-retry).
+
+  --bench=N runs each test N times after --bench-warmup unmeasured
+  runs, prints duration statistics and writes the measured durations
+  to bench.txt in the output dir, in benchstat format. For stable
+  numbers, combine with --cpuset, or use --jobs=1.
+
+  "rungittest benchcmp OLD NEW" compares two such runs, using the
+  Mann-Whitney U test to only report differences that are
+  statistically significant.
+
+  --locales=C,en_US.UTF-8 runs the selection once under each locale,
+  setting LANG and LC_ALL. Logs for each variant go into their own
+  subdirectory, and summary.txt groups results per variant. Note that
+  git's test-lib.sh resets LANG and LC_ALL to C, so this only affects
+  scripts, like those using lib-gettext.sh, that select a locale based
+  on the environment.
+
+  Similarly, --hash=sha1,sha256 runs the selection under each
+  GIT_TEST_DEFAULT_HASH, and --timezones=UTC,America/Los_Angeles,
+  Asia/Kathmandu under each TZ. As with locales, test-lib.sh sets TZ
+  to UTC, so zones only reach the date handling of scripts and helpers
+  that look at the TZ they were started with. Matrix flags combine,
+  and summary.txt lists the tests that fail only under some of the
+  variants.
+
+  The matrix flag --umask=0022,0077 runs the selection under each
+  umask, which git honors when creating repositories and objects
+  under the default core.sharedRepository. Permission bugs tend to
+  show only under restrictive umasks like 0077, which hardly anyone
+  develops with.
+
+  --filesystems=ext4,ext4-casefold,btrfs,vfat is a matrix flag too: it
+  creates a sparse image of --filesystem-size for each file system,
+  loop mounts it under the output dir and runs the selection with the
+  test root on it, so summary.txt shows the failures particular to
+  one file system, such as those from case folding or coarse
+  timestamps. This needs root and the mkfs tools; the file systems
+  are unmounted and their images removed when the run ends.
+
+  --slow-fs=2ms makes the test root behind a small FUSE file system
+  built into rungittest, which passes each operation through after
+  the given delay. Like on NFS, nothing is cached between operations,
+  so races and timing assumptions that a local disk hides come out.
+  This needs root; the --filesystems variants bypass it.
+
+  --container-matrix=debian:12,alpine:3.20,fedora:40 runs the
+  selection in each image, with docker or podman (--container-engine),
+  to catch failures particular to a libc or to the version of a tool.
+  The containers see the git tree (the parent of the current
+  directory), the output dir and the test root at the same paths, run
+  as the invoking user with the environment of the test, and use the
+  host network. They are named after the run ID and the test, and
+  killed if the test times out or is stopped. summary.txt gets a table
+  of the tests that did not fare the same in all images. Note the git
+  under test is the one built in the tree, so it must run in the
+  images: build it statically, or point GIT_TEST_INSTALLED (via --env)
+  at the git of the image.
+
+  --fuzz-env=N runs each test N times, each with a random combination
+  of GIT_TEST_* settings (split index, commit graph, ...). The
+  combination is part of the variant name, and depends only on the
+  test, the run number and --fuzz-seed, which is recorded in
+  summary.txt.
+
+  Tests read stdin from /dev/null. With --stdin=inherit, they get the
+  runner's stdin, and stay in the runner's process group so they can
+  read from the terminal; cancelling such a test only kills the script
+  itself, not its children.
+
+  With --timeout, tests running too long are stopped along with their
+  process group and reported as "timeout", which counts as a failure.
+  The log of each test records its CPU time and peak RSS.
+
+  The log and results.json also have the I/O of each test: the bytes
+  read from and written to disk, and on Linux the bytes passed to
+  read and write calls and their number, sampled from /proc/PID/io of
+  the test's processes, and the time they waited for I/O if delay
+  accounting is on (sysctl kernel.task_delayacct=1). summary.txt lists
+  the tests doing the most disk I/O, which gain most from a tmpfs
+  --root.
+
+  --tmpfs-root=SIZE runs the tests with a tmpfs of that size as their
+  --root. On Linux, rungittest reruns itself in a private mount
+  namespace (and a user namespace, unless run by root) in which it
+  mounts the tmpfs, so it is gone when the run ends, however it ends.
+  If namespaces are unavailable, root mounts the tmpfs in a temporary
+  directory and unmounts it afterwards.
+
+  --compare-roots=A,B runs the tests once with each of two roots, one
+  after the other, and reports how much faster each test and the whole
+  run was on B. A root is "default" for the one GIT_TEST_OPTS gives,
+  "tmpfs" for a --tmpfs-root (4G unless given), or a directory. The
+  runs write to root1 and root2 in the output directory, and
+  roots.txt holds the comparison, fastest gains first. The runs don't
+  go into the history.
+
+  --auto-timeout=pNxF gives each test its own timeout of F times the
+  N-th percentile of its recent passing runs in the history, but at
+  least 5s, so slow tests are not killed while a hanging fast one is
+  caught quickly. Tests without passing runs get --timeout.
+
+  With --leaks, the test root is checked after each passing test for
+  entries named after it, such as a trash directory that should have
+  been removed, and these are listed in summary.txt. --clean-leaks
+  removes them, so they cannot break later runs.
+
+  --record-schedule writes the order in which tests were started, and
+  which tests had finished by then, to schedule.jsonl. Passing that
+  file to --replay-schedule starts the tests in the same order, each
+  only after the same tests have finished, which makes failures that
+  depend on ordering reproducible. Tests that are not in the schedule
+  run after it.
+
+  The results of each run are appended to a history file (see
+  --history), which is used to estimate durations and how likely tests
+  are to fail. With --deadline, tests that failed recently go first,
+  and only the tests expected to finish in time are run; the rest are
+  listed as left out, and the run is marked as partial. Tests still
+  running at the deadline are stopped, which marks the run as partial
+  too. --order=fail-first also puts recently failing and flaky tests
+  first without a deadline, so --fail-fast runs end as early as
+  possible.
+
+  --lint checks all selected scripts before running any, with "sh -n",
+  and with git's chainlint.pl for broken &&-chains if it is next to
+  them. The problems are printed per script and written to
+  lint.txt, and no tests are run.
+
+  --coverage=gcov gives each test its own GCOV_PREFIX under
+  coverage/tests in the output dir, for git built with coverage
+  instrumentation; --coverage=kcov runs the scripts under kcov
+  instead. Afterwards, the data of all tests is merged into
+  coverage/merged (with gcov-tool for gcov), and
+  coverage/attribution.txt lists which tests produced data for each
+  file.
+
+  If test-lib.sh writes test-results/NAME.counts, summary.txt lists
+  the known breakages that were fixed, and tests whose counters
+  disagree with their TAP output.
+
+  --tap-out=FILE writes the TAP output of all tests as one stream, with
+  the test points renumbered and prefixed with the test, for TAP
+  consumers like prove or tap2junit. Failures that only show in the
+  exit status are added as failing test points.
+
+  --prove-compat accepts the prove flags used by git's Makefile, so
+  "prove" can be swapped for "rungittest --prove-compat": -j, --exec,
+  --state=slow,fast,failed,save and --timer, with the arguments after
+  "::" added to GIT_TEST_OPTS. Without --outdir, the output goes to a
+  temporary directory.
+
+  The exit status is 0 if all tests passed, 1 if tests failed or were
+  OOM-killed, 2 for errors that prevent a proper run (bad flags, a
+  full disk), 3 if --deadline stopped the run and 4 if it was
+  cancelled with Ctrl-C or "ctl cancel-run". --fail-on=flaky,skip
+  also counts tests that only passed when rerun, and skipped scripts,
+  as failures.
+
+  "rungittest split --shards=N GLOB..." prints N lists of tests with
+  balanced durations (from the history) without running anything, for
+  CI systems that run shards as separate jobs. --shard=K prints just
+  the K-th list, and --out writes them to files.
+
+  "rungittest simulate --jobs 4,8,16,32" predicts the wall-clock time
+  of a run for each number of jobs and --order, from the durations in
+  the history DB, without running anything, to help pick the size of
+  CI machines. It dispatches like a real run, honoring --weights, and
+  shows the lower bound no order can beat and how busy the slots
+  would be. Tests default to all of those in the history.
+
+  "rungittest prereqs 't[0-9]*.sh'" audits what this machine can test:
+  it lists the prerequisites the test cases of the scripts need, read
+  from their source, and whether each is satisfied here, with the
+  number of test cases that can run. Nothing is run but a probe per
+  script, which sources test-lib.sh and the lib-*.sh files the script
+  does and calls test_have_prereq, so libraries that skip a whole
+  script (eg. for lack of a web server) show up too. --json gives the
+  matrix to compare CI images.
+
+  "rungittest merge --out MERGED DIR..." combines the output dirs of
+  shards run on different machines into one summary.txt and
+  results.json, copying the logs into a subdirectory per shard. Tests
+  that ran in more than one shard are listed separately.
+
+  --priority names a file of tests or patterns, one per line, that are
+  started before all others, in the order given.
+
+  --constraints names a file with lines "A before B", so tests matching
+  B only start once all tests matching A have finished, and
+  "A not-with B", so tests matching A and B never run at the same
+  time. A and B are GIT_SKIP_TESTS style patterns; "t99* not-with
+  t99*" runs the t99* tests one at a time.
+
+  --weights names a file with lines "PATTERN SLOTS", for tests that
+  use more than one CPU, eg. because they run make -j or start many
+  daemons. Such a test only starts once SLOTS of the --jobs worker
+  slots are free, and takes all of them if it weighs more. Other tests
+  may start ahead of it while it waits.
+
+  summary.txt starts with "# rungittest summary 2", the version of
+  its format, followed by "# KEY: VALUE" header lines. Then come
+  sections separated by empty lines, each started by "# TITLE N:" with
+  the number of entries, or by "# TITLE:" for tables without a count,
+  such as "# per variant:". The first is always "# failed N:", listing
+  one failed test per line. Indented lines continue the line before.
+  New header keys and sections may be added, but anything else that
+  would break a script reading the file bumps the version. For
+  anything more, read results.json.
+
+  Besides summary.txt, the output directory has results.json, which
+  for every test also lists the tests that were running at the same
+  time, so interference can be mined across many runs. A test that did
+  not complete has an "error" with its kind: "setup" if it could not
+  be started, "timeout" if it ran too long or went silent, and "crash"
+  if it or a command it ran died of a signal.
+
+  With --isolate-failures, failed tests are rerun one at a time at the
+  end, each in a fresh --root under the output directory. Tests that
+  then pass are reported as "interference suspect", along with the
+  tests that were running when they failed.
+
+  With --rerun-verbose-on-failure, tests that failed are rerun once
+  more at the end, one at a time, with -v -x. The log of that run is
+  kept next to the original as NAME.verbose.log and listed in
+  summary.txt and results.json, so the trace is there when debugging
+  starts. The rerun does not change the result.
+
+  Defaults for all flags can be set in .rungittest.toml in $HOME and
+  in the test directory (which wins), with the flag names as keys:
+
+     jobs = 16
+     outdir = "/tmp/rgt-{head}-{date}"
+     skip-tests = "t9*"
+     timeout = "15m"
+     env = ["GIT_TEST_OPTS=--verbose-log", "GIT_TEST_DEFAULT_HASH=sha256"]
+
+  RUNGITTEST_* environment variables, eg. RUNGITTEST_JOBS, override
+  the file, and flags on the command line override both. For flags
+  that can be repeated, like --env, a variable replaces the values
+  from the file. A chdir setting, from the files in $HOME and the
+  starting directory, is applied before reading the file of the test
+  directory. In --outdir, {date} is replaced by the start time and
+  {head} by the current commit.
+
+  Sections like [profile.nightly] bundle settings that --profile=nightly
+  applies on top of the top-level ones; "tests" gives the globs to run
+  when there are none on the command line:
+
+     [profile.quick]
+     tests = ["t0*.sh", "t1*.sh"]
+     fail-fast = true
+
+     [profile.nightly]
+     tests = ["t[0-9]*.sh"]
+     locales = "C,en_US.UTF-8"
+     hash = "sha1,sha256"
+     timeout = "30m"
+     markdown-summary = "nightly.md"
+
+  "rungittest grep DIR PATTERN" searches the logs of a run for a
+  regular expression, and prints the matches grouped by test along
+  with its status; --failed limits the search to failed tests.
+
+  "rungittest show DIR [TEST]" opens the log of the given test, or of
+  the first failure, in $PAGER; --all-failed shows the failing test
+  cases of all failed tests one after another.
+
+  "rungittest report --format=html|json|junit|markdown|tap DIR"
+  renders a report from the results.json and logs of a finished run,
+  so the format need not be chosen when starting the run.
+
+  --format=badge-svg and --format=badge-json render a health badge for
+  dashboards. The score of 0 to 100 is the pass rate over the scripts
+  that were not skipped, less 2 points per percent of flaky tests and
+  1 point per 2 percent that the tests got slower than in the earlier
+  runs in the --history DB, at most 25. The JSON has the parts of the
+  score too.
+
+  "rungittest serve DIR --listen :8080" serves an output dir over
+  HTTP, with the HTML report as the index page and links to the logs,
+  artifacts and results.json. The report is rendered for each request,
+  so a run in progress can be followed by reloading. For a page that
+  updates itself, start the run with --serve=:8080: it shows the tests
+  running and finished as they go, pushed to the browser as
+  server-sent events, so everyone can watch a long run without
+  shell access to the machine.
+
+  Both also serve the history DB as JSON, for dashboards: /api/runs
+  lists the runs, newest first, /api/runs/ID/tests the tests of one,
+  and /api/tests/NAME/history the past runs of a test. Runs are only
+  recorded by id since this API exists; older entries show up in the
+  test history only.
+
+  "rungittest debug SCRIPT [ARGS]" runs a single script in the
+  foreground with -v -x -i --debug, for looking at a failure found by a
+  parallel run: output is shown live, the script stops at the first
+  failing test case, and the trash directory is kept.
+
+  "rungittest selftest" runs a generated suite of fast, slow, failing,
+  flaky, hanging and noisy scripts, and checks that scheduling,
+  timeouts, reruns and reports work end to end.
+
+  "rungittest completion bash|zsh|fish" prints a completion script for
+  the flags and subcommands. Profile names and, inside a test
+  directory, test scripts are looked up when completing:
+
+     source <(rungittest completion bash)
+
+  --max-output=SIZE keeps only the first and last SIZE/2 bytes of the
+  stdout and of the stderr of each test, so a runaway trace cannot fill
+  the disk. TAP lines are counted over the whole output, and the log
+  says what was dropped. With --max-output-fail, such tests fail.
+
+  By default, ANSI escape sequences and other control characters such
+  as NUL are stripped from the logs; --ansi=escape writes them as \xNN
+  and --ansi=keep leaves the output as it was. Summaries and the
+  progress line never contain them.
+
+  Each log and results.json record how long the test ran before its
+  first output and its longest silence. Tests that stay silent for
+  more than --silence-timeout, eg. waiting for a daemon that never
+  comes up, are reported as likely hung long before --timeout fires;
+  with --silence-kill they are stopped and get status "hung".
+
+  Stopped tests (--timeout, --silence-kill, --fail-fast, Ctrl-C, ctl
+  cancel) first get SIGTERM, so their traps can stop daemons and
+  remove trash directories, and SIGKILL once --grace has passed. A
+  second Ctrl-C kills them right away.
+
+  On Windows, tests are run with the sh.exe from Git for Windows (see
+  --shell), and all processes of a test are killed through a job
+  object. Features relying on Linux tools or /proc (--cpuset, --idle,
+  memory throttling, OOM detection, --pty) are not available there.
 */
 
 package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 )
 
+const (
+	statusOK        = "ok"
+	statusFail      = "error"
+	statusSkipped   = "skipped"
+	statusBadPlan   = "bad plan"
+	statusCancelled = "cancelled"
+	statusOOM       = "oom"
+	statusTimeout   = "timeout"
+	statusHung      = "hung"
+	statusSuspect   = "interference suspect"
+)
+
 type result struct {
 	name    string
+	variant *variant
+	status  string
 	summary string
 	err     error
+	tap     *tapResult
+
+	start    time.Time
+	duration time.Duration
+
+	// cpuUser and cpuSys are the CPU time used by the test and the
+	// children it waited for.
+	cpuUser time.Duration
+	cpuSys  time.Duration
+
+	// maxRSS is the largest resident set size in bytes of any of
+	// these processes, or -1 if unknown.
+	maxRSS int64
+
+	// io are the I/O counters of the test, or nil if unknown.
+	io *ioStats
+
+	// faketime is the libfaketime setting the test ran with.
+	faketime string
+
+	// worker is the slot (0 .. jobs-1) the test ran in.
+	worker int
+
+	// logFile is the log of the test, relative to the output dir.
+	logFile string
+
+	// truncated is set if output was dropped because of
+	// --max-output.
+	truncated bool
+
+	// firstOutput is how long the test ran before writing
+	// anything, or -1 if it never did, and maxSilence is the
+	// longest time it went without output. silent is set if that
+	// exceeded --silence-timeout.
+	firstOutput time.Duration
+	maxSilence  time.Duration
+	silent      bool
+
+	// env holds the --fingerprint-env variables the test ran with.
+	env map[string]string
+
+	// artifacts are the files the test left in
+	// RUNGITTEST_ARTIFACT_DIR, relative to the output dir.
+	artifacts []string
+
+	// signature identifies how a failed test failed. recurrence
+	// says how often the failure was seen before and which known
+	// issue it is, if any, and knownIssue and knownNote are the
+	// link and note of that.
+	signature  string
+	recurrence string
+	knownIssue string
+	knownNote  string
+
+	// signal is the signal that killed the script or a command it
+	// ran, if it failed that way.
+	signal string
+
+	// verboseLog is the log of the --rerun-verbose-on-failure run.
+	verboseLog string
+
+	// selections are the --select names the test was chosen by.
+	selections []string
+
+	// excerpt holds the interesting part of the output of a failed
+	// test.
+	excerpt string
+
+	// leaks are the leftovers of a passing test in the test root.
+	leaks []leak
+
+	// counts are what test-lib.sh wrote to test-results/, if
+	// anything.
+	counts *gitCounts
+
+	// concurrent are the tests that ran at the same time as a
+	// failed test.
+	concurrent []string
+
+	// attempt counts retries, and previous is the result of the
+	// previous attempt.
+	attempt  int
+	previous *result
+
+	// iteration numbers the runs in benchmark mode.
+	iteration int
 }
 
-func runTest(name, outdir string) *result {
-	f, err := os.Create(filepath.Join(outdir, name+".log"))
-	if err != nil {
-		return &result{
-			summary: "create error",
-			err:     err,
+// options holds the settings for running a test.
+type options struct {
+	outdir string
+	env    []string
+
+	detectOOM bool
+
+	// timeout, if positive, is how long a test may run before it
+	// is killed. timeouts holds the --auto-timeout values by
+	// label, which take precedence.
+	timeout  time.Duration
+	timeouts map[string]time.Duration
+
+	// grace is how long a test that timed out gets to clean up.
+	grace time.Duration
+
+	// maxOutput, if positive, limits how much of stdout and stderr
+	// is kept, and maxOutputFail fails tests exceeding it.
+	maxOutput     int64
+	maxOutputFail bool
+
+	// silence, if positive, is how long a test may go without
+	// output before it is reported as hung, and killed if
+	// silenceKill is set.
+	silence     time.Duration
+	silenceKill bool
+
+	// stderrAllow, if set, lists the patterns that all stderr
+	// lines of passing tests must match.
+	stderrAllow []*regexp.Regexp
+
+	// fingerprint are the patterns of environment variables to
+	// record for each test.
+	fingerprint []string
+
+	// selections maps test names to the --select names they were
+	// chosen by.
+	selections map[string][]string
+
+	// faketime is the FAKETIME setting of the tests, if any.
+	faketime string
+
+	// runAs, if set, is the user to run the tests as.
+	runAs *runAsUser
+
+	// sandbox, if set, confines the tests.
+	sandbox *sandbox
+
+	// container runs the tests of variants with an image.
+	container *containerEngine
+
+	// isolateHome gives each test its own HOME, XDG directories
+	// and GNUPGHOME.
+	isolateHome bool
+
+	// history and knownIssues are for recognizing failures seen
+	// before.
+	history     *history
+	knownIssues []knownIssue
+
+	// ansi is what to do with control characters in logs: strip,
+	// escape or keep.
+	ansi string
+
+	// tapOut, if set, collects the TAP output of all tests.
+	tapOut *tapWriter
+
+	// coverage is "gcov" or "kcov" to collect coverage per test.
+	coverage string
+
+	// shell runs the test scripts.
+	shell string
+
+	// wrapper is prepended to the command line for tests.
+	wrapper []string
+
+	// pin, if set, pins each worker slot to its own CPUs.
+	pin *cpuPinning
+
+	// pty runs tests on a pseudo-terminal rather than pipes.
+	pty bool
+
+	// inheritStdin passes our stdin to the tests instead of
+	// /dev/null.
+	inheritStdin bool
+
+	// exec creates the processes of the tests.
+	exec executor
+}
+
+// command returns the command line for running the job.
+func (o *options) command(j *job) []string {
+	var argv []string
+	if j.variant != nil && j.variant.umask != "" {
+		// exec keeps the pid, so the test still leads its
+		// process group.
+		argv = append(argv, o.shell, "-c", `umask "$0" && exec "$@"`, j.variant.umask)
+	}
+	argv = append(argv, o.wrapper...)
+	if o.pin != nil {
+		argv = append(argv, "taskset", "-c", o.pin.slotCPUs(j.slot))
+	}
+	if o.coverage == "kcov" {
+		argv = append(argv, "kcov", coverageDir(o.outdir, j.logName()))
+	}
+	// The shell from Git for Windows prefers forward slashes.
+	argv = append(argv, o.shell, filepath.ToSlash(j.name))
+	if j.verbose {
+		argv = append(argv, "-v", "-x")
+	}
+	return argv
+}
+
+// executor creates the processes running test scripts. The scheduler
+// and runTest only start and wait for them, so tests can substitute
+// fakes that finish instantly.
+type executor interface {
+	command(argv []string) *exec.Cmd
+}
+
+// osExecutor runs argv as given.
+type osExecutor struct{}
+
+func (osExecutor) command(argv []string) *exec.Cmd {
+	return exec.Command(argv[0], argv[1:]...)
+}
+
+// logName returns the name of the log file for the job.
+func (j *job) logName() string {
+	name := j.name
+	if j.variant != nil {
+		name = filepath.Join(j.variant.dir(), name)
+	}
+	if j.iteration > 0 {
+		name = fmt.Sprintf("%s.bench%d", name, j.iteration)
+	}
+	if j.attempt > 0 {
+		name = fmt.Sprintf("%s.retry%d", name, j.attempt)
+	}
+	if j.verbose {
+		name += ".verbose"
+	}
+	return name + ".log"
+}
+
+// flaky returns true if the test passed after failing on an earlier
+// attempt.
+func (r *result) flaky() bool {
+	if r.previous == nil || !r.previous.failed() {
+		return false
+	}
+	return r.status == statusOK || r.status == statusSuspect
+}
+
+// failed says whether the test counts as a failure. This includes
+// OOM kills, although the summary lists them on their own.
+func (r *result) failed() bool {
+	switch r.status {
+	case statusFail, statusBadPlan, statusTimeout, statusHung, statusOOM:
+		return true
+	}
+	return false
+}
+
+// testID returns the test number (eg. "t0001") for a script, which is
+// what GIT_SKIP_TESTS patterns are matched against.
+func testID(name string) string {
+	base := strings.TrimSuffix(filepath.Base(name), ".sh")
+	if i := strings.Index(base, "-"); i > 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+func matchSkip(name string, patterns []string) bool {
+	id := testID(name)
+	base := strings.TrimSuffix(filepath.Base(name), ".sh")
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, id); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
 		}
 	}
+	return false
+}
+
+// selectTests expands the globs, leaving out the tests matching the
+// GIT_SKIP_TESTS style patterns.
+func selectTests(globs, skip []string) ([]string, error) {
+	var entries []string
+	for _, f := range globs {
+		es, err := filepath.Glob(f)
+		if err != nil {
+			return nil, fmt.Errorf("glob: %v", err)
+		}
+		for _, e := range es {
+			if !matchSkip(e, skip) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+func runTest(ctx context.Context, j *job, opts *options) *result {
+	r := &result{
+		name:      j.name,
+		variant:   j.variant,
+		attempt:   j.attempt,
+		iteration: j.iteration,
+		worker:    j.slot,
+		logFile:   j.logName(),
+
+		selections: opts.selections[j.name],
+		faketime:   opts.faketime,
+
+		firstOutput: -1,
+	}
+	logName := filepath.Join(opts.outdir, j.logName())
+	err := os.MkdirAll(filepath.Dir(logName), 0755)
+	var f *os.File
+	if err == nil {
+		f, err = os.Create(logName)
+	}
+	if err != nil {
+		return r.setupFailed("create", err)
+	}
 	defer f.Close()
-	cmd := exec.Command("/bin/sh", name)
-	outBuf := bytes.Buffer{}
-	errBuf := bytes.Buffer{}
-	cmd.Stdout = &outBuf
-	cmd.Stderr = &errBuf
-	err = cmd.Run()
+	cmd := opts.exec.command(opts.command(j))
+	cmd.Env = opts.env
+	if j.variant != nil {
+		cmd.Env = append(append([]string{}, opts.env...), j.variant.env...)
+	}
+	if opts.coverage == "gcov" {
+		cmd.Env = append(append([]string{}, cmd.Env...), "GCOV_PREFIX="+coverageDir(opts.outdir, j.logName()))
+	}
+	artifacts := artifactDir(opts.outdir, j.logName())
+	if err := os.MkdirAll(artifacts, 0755); err != nil {
+		return r.setupFailed("create", err)
+	}
+	cmd.Env = append(append([]string{}, cmd.Env...), "RUNGITTEST_ARTIFACT_DIR="+artifacts)
+	// owned are the directories we set up for the test, which must
+	// be the --run-as user's.
+	owned := []string{artifacts}
+	home := ""
+	if opts.isolateHome {
+		var homeEnv []string
+		if home, homeEnv, err = scratchHome(opts.outdir, j); err != nil {
+			return r.setupFailed("create", err)
+		}
+		cmd.Env = append(cmd.Env, homeEnv...)
+		owned = append(owned, home)
+	}
+	if j.variant != nil && j.variant.root != "" {
+		cmd.Env = append(append([]string{}, cmd.Env...),
+			"GIT_TEST_OPTS="+strings.TrimSpace(os.Getenv("GIT_TEST_OPTS")+" --root="+j.variant.root))
+	}
+	if j.isolated {
+		root, err := isolatedRoot(opts.outdir, j)
+		if err != nil {
+			return r.setupFailed("create", err)
+		}
+		// test-lib.sh uses the last --root it is given.
+		cmd.Env = append(append([]string{}, cmd.Env...),
+			"GIT_TEST_OPTS="+strings.TrimSpace(os.Getenv("GIT_TEST_OPTS")+" --root="+root))
+		owned = append(owned, root)
+	}
+	if opts.runAs != nil {
+		if opts.coverage != "" {
+			dir := coverageDir(opts.outdir, j.logName())
+			if err := os.MkdirAll(dir, 0755); err == nil {
+				owned = append(owned, dir)
+			}
+		}
+		if err := opts.runAs.give(owned...); err != nil {
+			return r.setupFailed("create", err)
+		}
+	}
+	act := &activity{}
+	outBuf := &cappedOutput{limit: opts.maxOutput, tap: &tapResult{}, act: act}
+	errBuf := &cappedOutput{limit: opts.maxOutput, act: act}
+	var pty *ptyCapture
+	if opts.pty {
+		if pty, err = capturePTY(cmd, outBuf); err != nil {
+			return r.setupFailed("pty", err)
+		}
+	} else {
+		cmd.Stdout = outBuf
+		cmd.Stderr = errBuf
+		if opts.inheritStdin {
+			// Stay in the foreground process group, so the
+			// test can read from the terminal.
+			cmd.Stdin = os.Stdin
+		} else {
+			// A nil Stdin reads from os.DevNull.
+			cmd.Stdin = nil
+			setProcGroup(cmd)
+		}
+	}
+	if opts.runAs != nil {
+		setCredential(cmd, opts.runAs)
+	}
+	if opts.sandbox != nil {
+		if err := opts.sandbox.wrap(cmd); err != nil {
+			return r.setupFailed("sandbox", err)
+		}
+	}
+	container := ""
+	if j.variant != nil && j.variant.image != "" {
+		container = opts.container.containerName(j)
+		if err := opts.container.wrap(cmd, j.variant.image, container); err != nil {
+			return r.setupFailed("container", err)
+		}
+	}
+	r.env = fingerprintEnv(cmd.Env, opts.fingerprint)
+	var ooms int64
+	if opts.detectOOM {
+		ooms = oomKills()
+	}
+	timeout := opts.timeoutFor(j)
+	start := time.Now()
+	act.begin(start)
+	err = j.start(ctx, cmd)
+	started := err == nil
+	var iow *ioWatch
+	if err == nil {
+		iow = watchIO(cmd.Process.Pid)
+		unwatch := j.watch(ctx, opts.grace)
+		var timer *time.Timer
+		if timeout > 0 {
+			timer = time.AfterFunc(timeout, func() { j.expire(opts.grace) })
+		}
+		stopSilence := func() {}
+		if opts.silence > 0 {
+			stopSilence = act.watchSilence(opts.silence, func() {
+				r.silent = true
+				if opts.silenceKill {
+					log.Printf("%s: no output for %s, stopping it", j.label(), opts.silence)
+					j.hang(opts.grace)
+				} else {
+					log.Printf("%s: no output for %s, likely hung", j.label(), opts.silence)
+				}
+			})
+		}
+		err = cmd.Wait()
+		if timer != nil {
+			timer.Stop()
+		}
+		stopSilence()
+		unwatch()
+		if container != "" && (j.isTimedOut() || j.isHung() || j.isCancelled()) {
+			opts.container.kill(container)
+		}
+		j.release()
+	}
+	io := &ioStats{read: -1, written: -1, syscalls: -1}
+	if iow != nil {
+		iow.stop(io)
+	}
+	if pty != nil {
+		pty.wait()
+	}
+	end := time.Now()
+	duration := end.Sub(start)
+	r.firstOutput = act.firstOutput()
+	r.maxSilence = act.longestSilence(end)
+	stdout, stderr := outBuf.Bytes(), errBuf.Bytes()
+	truncated := outBuf.truncated() || errBuf.truncated()
+	oomKilled := false
+	if opts.detectOOM && err != nil && oomKills() > ooms {
+		oomKilled = killedBySIGKILL(cmd.ProcessState) ||
+			bytes.Contains(stderr, []byte("Killed")) ||
+			bytes.Contains(stdout, []byte("Killed"))
+	}
 
 	errStr := "success"
 	if err != nil {
 		errStr = err.Error()
 	}
 	fmt.Fprintf(f, "*** EXIT: %s ***\n\n", errStr)
-	fmt.Fprintf(f, "*** STDOUT: ***\n\n")
-	f.Write(outBuf.Bytes())
+	r.maxRSS = -1
+	if ps := cmd.ProcessState; ps != nil {
+		r.cpuUser = ps.UserTime()
+		r.cpuSys = ps.SystemTime()
+		r.maxRSS = maxRSS(ps)
+		rss := "unknown"
+		if r.maxRSS >= 0 {
+			rss = formatSize(r.maxRSS)
+		}
+		fmt.Fprintf(f, "*** RUSAGE: user %s, sys %s, maxrss %s ***\n\n", r.cpuUser, r.cpuSys, rss)
+		io.diskRead, io.diskWritten = blockIO(ps)
+	}
+	if io.disk() >= 0 || io.read >= 0 {
+		r.io = io
+		fmt.Fprintf(f, "*** IO: %s ***\n\n", io)
+	}
+	if r.firstOutput >= 0 {
+		fmt.Fprintf(f, "*** OUTPUT: first after %s, longest silence %s ***\n\n",
+			r.firstOutput.Round(time.Millisecond), r.maxSilence.Round(time.Millisecond))
+	} else {
+		fmt.Fprintf(f, "*** OUTPUT: none ***\n\n")
+	}
+	if truncated {
+		fmt.Fprintf(f, "*** TRUNCATED: stdout %s, stderr %s, kept %s of each (--max-output) ***\n\n",
+			formatSize(outBuf.total), formatSize(errBuf.total), formatSize(opts.maxOutput))
+	}
+	var artErr error
+	r.artifacts, artErr = collectArtifacts(opts.outdir, artifacts)
+	if artErr != nil {
+		fmt.Fprintf(f, "*** ARTIFACTS: %v ***\n\n", artErr)
+	} else if len(r.artifacts) > 0 {
+		fmt.Fprintf(f, "*** ARTIFACTS: %s ***\n\n", strings.Join(r.artifacts, " "))
+	}
+	if home != "" {
+		fmt.Fprintf(f, "*** HOME: %s (kept if the test fails) ***\n\n", home)
+	}
+	if r.faketime != "" {
+		fmt.Fprintf(f, "*** FAKETIME: %s ***\n\n", r.faketime)
+	}
+	if j.variant != nil {
+		settings := j.variant.env
+		if j.variant.umask != "" {
+			settings = append([]string{"umask " + j.variant.umask}, settings...)
+		}
+		if j.variant.image != "" {
+			settings = append([]string{"image " + j.variant.image}, settings...)
+		}
+		fmt.Fprintf(f, "*** VARIANT: %s %s ***\n\n", j.variant.name, strings.Join(settings, " "))
+	}
+	if opts.pty {
+		fmt.Fprintf(f, "*** STDOUT (pty, includes stderr): ***\n\n")
+	} else {
+		fmt.Fprintf(f, "*** STDOUT: ***\n\n")
+	}
+	logOut := cleanOutput(stdout, opts.ansi)
+	f.Write(logOut)
 	fmt.Fprintf(f, "\n\n*** STDERR: ***\n\n")
-	f.Write(errBuf.Bytes())
+	f.Write(cleanOutput(stderr, opts.ansi))
 
-	lines := bytes.Split(outBuf.Bytes(), []byte("\n"))
+	lines := bytes.Split(stdout, []byte("\n"))
 	summary := ""
 	if len(lines) >= 3 {
 		lines = lines[len(lines)-3:]
-		summary = string(lines[0])
+		summary = strings.TrimRight(string(lines[0]), "\r")
 	}
 
-	if err != nil {
-		summary = "error: " + summary
-	} else {
-		summary = "ok: " + summary
+	tap := outBuf.result()
+	var noise []string
+	if opts.stderrAllow != nil {
+		noise = unexpectedStderr(stderr, opts.stderrAllow)
+	}
+	status := statusOK
+	if j.isCancelled() {
+		status = statusCancelled
+		summary = errStr
+	} else if j.isTimedOut() {
+		status = statusTimeout
+		summary = fmt.Sprintf("timed out after %s", timeout)
+	} else if j.isHung() {
+		status = statusHung
+		summary = fmt.Sprintf("no output for %s", opts.silence)
+	} else if oomKilled {
+		status = statusOOM
+	} else if err != nil {
+		status = statusFail
+	} else if truncated && opts.maxOutputFail {
+		status = statusFail
+		summary = fmt.Sprintf("output exceeded --max-output=%s", formatSize(opts.maxOutput))
+	} else if msg := tap.checkPlan(); msg != "" {
+		status = statusBadPlan
+		summary = msg
+	} else if len(noise) > 0 {
+		status = statusFail
+		summary = noiseSummary(noise)
+	} else if tap.skippedAll() {
+		status = statusSkipped
+		if tap.skipAll != "" {
+			summary = tap.skipAll
+		}
 	}
 
-	return &result{
-		name:    name,
-		summary: summary,
-		err:     err,
+	r.status = status
+	r.summary = status + ": " + cleanText(summary, summaryMode(opts.ansi))
+	r.err = err
+	if r.failed() && status != statusOOM {
+		r.signal = crashSignal(cmd.ProcessState, stdout, stderr)
 	}
+	switch {
+	case !started && status == statusFail:
+		r.err = &setupError{stage: "start", err: err}
+	case status == statusTimeout:
+		r.err = &timeoutError{limit: timeout, err: err}
+	case status == statusHung:
+		r.err = &timeoutError{limit: opts.silence, silent: true, err: err}
+	case r.signal != "":
+		r.err = &crashError{signal: r.signal, err: err}
+	}
+	r.tap = tap
+	r.truncated = truncated
+	r.counts = readGitCounts(j.name, start)
+	r.start = start
+	r.duration = duration
+	if r.failed() {
+		r.excerpt = cleanText(failureExcerpt(stdout, stderr), summaryMode(opts.ansi))
+	}
+	if r.failed() {
+		r.signature = failureSignature(logOut, status, cleanText(summary, summaryMode(opts.ansi)))
+		annotateFailure(r, opts.history, opts.knownIssues)
+	}
+	if home != "" && !r.failed() {
+		if err := os.RemoveAll(home); err != nil {
+			log.Printf("%s: removing home: %v", j.label(), err)
+		}
+	}
+	if opts.tapOut != nil && !j.verbose {
+		opts.tapOut.add(r, logOut)
+	}
+	return r
 }
 
 func main() {
+	if spec := os.Getenv(sandboxEnv); spec != "" {
+		sandboxMain(spec)
+	}
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ctl":
+			controlMain(os.Args[2:])
+			return
+		case "benchcmp":
+			benchcmpMain(os.Args[2:])
+			return
+		case "split":
+			splitMain(os.Args[2:])
+			return
+		case "merge":
+			mergeMain(os.Args[2:])
+			return
+		case "selftest":
+			selftestMain(os.Args[2:])
+			return
+		case "grep":
+			grepMain(os.Args[2:])
+			return
+		case "show":
+			showMain(os.Args[2:])
+			return
+		case "debug":
+			debugMain(os.Args[2:])
+			return
+		case "report":
+			reportMain(os.Args[2:])
+			return
+		case "serve":
+			serveMain(os.Args[2:])
+			return
+		case "simulate":
+			simulateMain(os.Args[2:])
+			return
+		case "prereqs":
+			prereqsMain(os.Args[2:])
+			return
+		}
+	}
+	os.Exit(runMain())
+}
+
+// runMain runs the tests, and returns the exit status.
+func runMain() int {
+
 	jobs := flag.Int("jobs", runtime.NumCPU(), "jobs")
 	out := flag.String("outdir", "", "output dir")
-	flag.Parse()
+	chdir := flag.String("chdir", "", "change to this directory before expanding globs")
+	skipTests := flag.String("skip-tests", "", "GIT_SKIP_TESTS style patterns of tests to skip")
+	printSkip := flag.Bool("print-skip-tests", false, "print a GIT_SKIP_TESTS value covering the failing tests")
+	markdown := flag.String("markdown-summary", "", "write a GitHub flavored Markdown summary to this file")
+	email := flag.String("email", "", "comma separated addresses to mail a digest of the run to")
+	smtpAddr := flag.String("smtp", "localhost:25", "SMTP server (HOST:PORT) for --email")
+	emailFrom := flag.String("email-from", defaultEmailFrom(), "sender address for --email")
+	durations := flag.Bool("duration-report", false, "print a histogram of the test durations and the share of the test time taken by the slowest tests and per area, and write them to durations.txt")
+	critPath := flag.Bool("critical-path", false, "print how close the run came to the shortest possible wall-clock time and where worker slots were idle, and write it to critical-path.txt")
+	areas := flag.Bool("area-report", false, "print pass rates and test time per area of the test numbering (t0xxx, t1xxx, ...), and write them to areas.txt")
+	watch := flag.Bool("watch", false, "after the run, rerun the tests affected by changes to the scripts, test libraries or build until interrupted")
+	notify := flag.Bool("notify-desktop", false, "show a desktop notification with the counts when the run ends")
+	failureHookURL := flag.String("notify-on-failure", "", "post failures as JSON to this URL while the run goes on, batched by --notify-batch")
+	failureHookWindow := flag.Duration("notify-batch", 30*time.Second, "how long to collect failures for one --notify-on-failure post")
+	emailLink := flag.String("email-link", "", "URL of the uploaded output dir, for the --email digest")
+	teamcity := flag.Bool("teamcity", false, "emit TeamCity service messages")
+	azure := flag.Bool("azure", false, "emit Azure DevOps logging commands")
+	otlp := flag.String("otlp-endpoint", "", "export a trace of the run to this OTLP/HTTP endpoint (HOST:PORT)")
+	appendFlag := flag.Bool("append", false, "keep the results in the output dir from earlier runs, replacing those of the tests run again")
+	serveAddr := flag.String("serve", "", "serve a live view of the run and the output dir on this address (eg. :8080)")
+	debugHTTP := flag.String("debug-http", "", "serve pprof and expvar on this address (eg. :6060)")
+	diskWarn := sizeFlag(1 << 30)
+	var tmpfsRoot sizeFlag
+	compareRootsFlag := flag.String("compare-roots", "", "run the tests twice, on two roots (\"default\", \"tmpfs\" or a directory), and compare their durations, eg. default,tmpfs")
+	flag.Var(&tmpfsRoot, "tmpfs-root", "run the tests in a private tmpfs of this size, eg. 2G, set up and torn down by us")
+	flag.Var(&diskWarn, "disk-warn", "warn if free space on the output or test root drops below this")
+	diskAbort := sizeFlag(100 << 20)
+	flag.Var(&diskAbort, "disk-abort", "abort the run if free space on the output or test root drops below this")
+	inodesWarn := flag.Int64("inodes-warn", 10000, "warn if free inodes drop below this")
+	detectOOM := flag.Bool("detect-oom", false, "classify tests whose processes were OOM-killed as \"oom\"")
+	rerunVerbose := flag.Bool("rerun-verbose-on-failure", false, "rerun failed tests one at a time with -v -x at the end of the run, keeping the log next to the original")
+	retryOnFlag := flag.String("retry-on", "", "comma separated failure classes to retry once after the run: signal (a crash, eg. SIGBUS), timeout, oom or hung")
+	useJobserver := flag.Bool("jobserver", false, "share a GNU make jobserver with the tests, joining the one in MAKEFLAGS if we run under make, so nested parallelism stays within --jobs")
+	retryDelay := flag.Duration("retry-delay", 0, "wait this long before each retry, to let load-induced failures cool down")
+	retrySerial := flag.Bool("retry-serial", false, "run --retry-on retries one at a time instead of in parallel")
+	oomRetry := flag.Bool("oom-retry", false, "rerun OOM-killed tests one at a time at the end of the run")
+	nice := flag.Bool("nice", false, "run tests with nice 19")
+	idle := flag.Bool("idle", false, "run tests with nice 19 and idle CPU and I/O scheduling")
+	cpuset := flag.Bool("cpuset", false, "pin each worker slot to its own CPUs")
+	cpusPerSlot := flag.Int("cpus-per-slot", 1, "number of CPUs per worker slot for --cpuset")
+	bench := flag.Int("bench", 0, "run each test this many times, and report duration statistics")
+	benchWarmup := flag.Int("bench-warmup", 1, "number of unmeasured runs before --bench runs")
+	locales := flag.String("locales", "", "comma separated list of locales (LANG and LC_ALL) to run the tests under")
+	hashes := flag.String("hash", "", "comma separated list of hash functions (GIT_TEST_DEFAULT_HASH) to run the tests with")
+	slowFSFlag := flag.Duration("slow-fs", 0, "pass the test root through a FUSE file system that delays each operation by this much, like a network file system, eg. 2ms")
+	filesystems := flag.String("filesystems", "", "comma separated list of file systems ("+fsFlavorNames()+") to loop mount as test roots and run the tests on")
+	filesystemSize := sizeFlag(2 << 30)
+	flag.Var(&filesystemSize, "filesystem-size", "size of the --filesystems images, which are sparse")
+	containerMatrix := flag.String("container-matrix", "", "comma separated list of container images to run the tests in, eg. debian:12,alpine:3.20")
+	containerEngineFlag := flag.String("container-engine", "", "docker compatible CLI for --container-matrix (default docker or podman, whichever is found)")
+	umasks := flag.String("umask", "", "comma separated list of octal umasks to run the tests under, eg. 0022,0077")
+	timezones := flag.String("timezones", "", "comma separated list of time zones (TZ) to run the tests under, eg. UTC,America/Los_Angeles,Asia/Kathmandu")
+	fuzzN := flag.Int("fuzz-env", 0, "run each test this many times with random combinations of GIT_TEST_* settings")
+	fuzzSeed := flag.Int64("fuzz-seed", 0, "random seed for --fuzz-env (default: based on the time)")
+	usePTY := flag.Bool("pty", false, "run tests on a pseudo-terminal, so TTY tests are not skipped")
+	stdin := flag.String("stdin", "null", "stdin for tests: null or inherit")
+	timeout := flag.Duration("timeout", 0, "kill tests running longer than this (eg. 10m)")
+	autoTimeoutSpec := flag.String("auto-timeout", "", "derive the timeout of each test from its passing runs in the history, as pNxF for F times the N-th percentile (eg. p99x3); --timeout applies to tests without history")
+	grace := flag.Duration("grace", 10*time.Second, "time between SIGTERM and SIGKILL for cancelled tests")
+	leaks := flag.Bool("leaks", false, "report files and directories passing tests leave in the test root")
+	cleanLeftovers := flag.Bool("clean-leaks", false, "remove the leftovers of passing tests (implies --leaks)")
+	runAsFlag := flag.String("run-as", "", "when started as root, run the tests as this user; the runner keeps its privileges")
+	isolateHome := flag.Bool("isolate-home", true, "give each test a fresh HOME, XDG_CONFIG_HOME and GNUPGHOME under the output dir, so the user's settings cannot leak in")
+	isolate := flag.Bool("isolate-failures", false, "rerun failed tests alone in a clean root, to find interference between tests")
+	recordSchedule := flag.Bool("record-schedule", false, "write the dispatch order to schedule.jsonl in the output directory")
+	replaySchedule := flag.String("replay-schedule", "", "dispatch tests in the order and concurrency recorded in this schedule.jsonl")
+	historyFile := flag.String("history", defaultHistoryPath(), "file recording the results of past runs; empty to disable")
+	deadline := flag.Duration("deadline", 0, "run only the tests expected to finish within this time, and stop when it is reached")
+	order := flag.String("order", "", "order of the tests: given, fail-first to start recently failing tests first, or slow or fast for longest or shortest first (default given, fail-first for --deadline)")
+	lastFailed := flag.Bool("last-failed", false, "only run the tests whose last run in the history failed")
+	timer := flag.Bool("timer", false, "show the duration of each test")
+	flag.Bool("prove-compat", false, "accept prove's -j, --exec, --state and --timer flags, and pass arguments after :: to the tests")
+	coverage := flag.String("coverage", "", "collect coverage per test with gcov (a build with coverage instrumentation) or kcov")
+	lint := flag.Bool("lint", false, "check the scripts with sh -n and chainlint.pl before running, and stop if any fail")
+	tapOut := flag.String("tap-out", "", "write the TAP output of all tests to this file as one stream")
+	priority := flag.String("priority", "", "file listing tests or patterns to start first, in that order")
+	constraintsFile := flag.String("constraints", "", "file with \"A before B\" and \"A not-with B\" lines restricting test order")
+	weightsFile := flag.String("weights", "", "file with \"PATTERN SLOTS\" lines giving the number of worker slots tests take")
+	failFast := flag.Bool("fail-fast", false, "stop the run after the first failure")
+	shell := flag.String("shell", defaultShell(), "shell for running the test scripts")
+	inodesAbort := flag.Int64("inodes-abort", 1000, "abort the run if free inodes drop below this")
+	profile := flag.String("profile", "", "use the settings of [profile.NAME] in .rungittest.toml")
+	var maxOutput sizeFlag
+	flag.Var(&maxOutput, "max-output", "keep only the first and last half of this much stdout and stderr per test")
+	maxOutputFail := flag.Bool("max-output-fail", false, "fail tests whose output exceeds --max-output")
+	ansi := flag.String("ansi", "strip", "ANSI escapes and control characters in logs: strip, escape (as \\xNN) or keep; summaries never contain them")
+	silence := flag.Duration("silence-timeout", 0, "report tests without output for this long as likely hung")
+	silenceKill := flag.Bool("silence-kill", false, "stop tests exceeding --silence-timeout, with status \"hung\"")
+	progressMode := flag.String("progress", "auto", "progress output: overwrite a single line, one timestamped line per test, or auto for lines unless stdout is a terminal")
+	statusInterval := flag.Duration("status-interval", 5*time.Minute, "with line progress, print the counts and current failures this often; 0 to disable")
+	failOnFlag := flag.String("fail-on", "", "comma separated list of flaky (failed, then passed when rerun) and skip (skipped scripts) to count as failures")
+	strictStderr := flag.Bool("strict-stderr", false, "fail tests writing anything to stderr that --stderr-allow does not allow")
+	stderrAllow := flag.String("stderr-allow", "", "file with regular expressions, one per line, for stderr lines --strict-stderr accepts (besides blank lines and sh -x traces)")
+	baseline := flag.String("baseline", "", "output dir of an earlier run to compare skips against (default: the last run in the history)")
+	knownIssuesFile := flag.String("known-issues", "", "file listing known failures with bug links and notes, as YAML (.yaml, .yml) or lines \"TEST LINK [REGEXP]\"")
+	ignoreKnown := flag.Bool("ignore-known", false, "don't count failures matching --known-issues for the exit status")
+	fingerprint := flag.String("fingerprint-env", defaultFingerprintEnv, "comma separated patterns of environment variables to record for each test in results.json")
+	var selects listFlag
+	flag.Var(&selects, "select", "run the tests matching GLOB, and break down the results under NAME, given as NAME=GLOB (can be repeated)")
+	faketimeFlag := flag.String("faketime", "", "run the tests under libfaketime, shifted by OFFSET (eg. +1y, -30d, \"@2038-01-19 03:14:00\"), at RATE (eg. x10), or both as OFFSET,RATE")
+	faketimeLib := flag.String("faketime-lib", "", "path of libfaketime (default: search the usual places)")
+	useSandbox := flag.Bool("sandbox", false, "on Linux, let tests write only to the test root and output dir (with Landlock) and use only the loopback network")
+	var sandboxAllow listFlag
+	flag.Var(&sandboxAllow, "sandbox-allow", "with --sandbox, also let tests write below this path (can be repeated)")
+	var extraEnv listFlag
+	flag.Var(&extraEnv, "env", "set VAR=VALUE in the environment of the tests (can be repeated)")
+	var minMem sizeFlag
+	flag.Var(&minMem, "min-mem-available", "don't start tests while available memory is below this")
+	maxMemPressure := flag.Float64("max-mem-pressure", 0, "don't start tests while memory pressure (PSI some avg10, in %) is above this")
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		completionMain(os.Args[2:], flag.CommandLine)
+		return exitOK
+	}
+	if hasProveCompat(os.Args[1:]) {
+		args, err := proveArgs(os.Args[1:])
+		if err != nil {
+			fatalf("--prove-compat: %v", err)
+		}
+		flag.CommandLine.Parse(args)
+	} else {
+		flag.Parse()
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	dir := *chdir
+	if dir == "" {
+		// Without the flag, the directory may come from our
+		// environment or the config files read from here.
+		settings, err := loadSettings(*profile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		dir = os.Getenv("RUNGITTEST_CHDIR")
+		if v := settings["chdir"]; dir == "" && len(v) > 0 {
+			dir = v[len(v)-1]
+		}
+	}
+	if dir != "" {
+		if err := os.Chdir(dir); err != nil {
+			fatalf("chdir: %v", err)
+		}
+	}
+	settings, err := loadSettings(*profile)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := applyDefaults(flag.CommandLine, settings); err != nil {
+		fatalf("%v", err)
+	}
+	globs := flag.Args()
+	if len(globs) == 0 {
+		globs = settings["tests"]
+	}
+	*out = expandOutdir(*out, time.Now())
 
+	proveCompat := hasProveCompat(os.Args[1:])
+	if *out == "" && proveCompat {
+		dir, err := os.MkdirTemp("", "rungittest-")
+		if err != nil {
+			fatalf("%v", err)
+		}
+		*out = dir
+	}
 	if *out == "" {
-		log.Fatalf("must provide --outdir.")
+		fatalf("must provide --outdir.")
+	}
+	selections, err := parseSelections(selects)
+	if err != nil {
+		fatalf("--select: %v", err)
 	}
-	if len(flag.Args()) == 0 {
-		log.Fatalf("usage: provide glob")
+	if len(globs) == 0 && len(selections) == 0 {
+		fatalf("usage: provide glob")
 	}
 
-	var entries []string
-	for _, f := range flag.Args() {
-		es, err := filepath.Glob(f)
+	var runAs *runAsUser
+	if *runAsFlag != "" {
+		if runAs, err = lookupRunAs(*runAsFlag); err != nil {
+			fatalf("--run-as: %v", err)
+		}
+	}
+
+	retryOn, err := parseRetryOn(*retryOnFlag)
+	if err != nil {
+		fatalf("--retry-on: %v", err)
+	}
+
+	var failOnFlaky, failOnSkip bool
+	for _, f := range splitList(*failOnFlag) {
+		switch f {
+		case "flaky":
+			failOnFlaky = true
+		case "skip":
+			failOnSkip = true
+		default:
+			fatalf("--fail-on: unknown value %q, want flaky or skip", f)
+		}
+	}
+
+	skipPatterns := strings.Fields(*skipTests)
+	entries, err := selectTests(globs, skipPatterns)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	entries, selected, err := addSelections(entries, selections, skipPatterns)
+	if err != nil {
+		fatalf("--select: %v", err)
+	}
+	if len(entries) == 0 {
+		here, _ := os.Getwd()
+		fatalf("no tests selected by %s in %s", strings.Join(append(append([]string{}, globs...), selects...), " "), here)
+	}
+	if *compareRootsFlag != "" {
+		if *watch || proveCompat {
+			fatalf("--compare-roots: not supported with --watch or --prove-compat")
+		}
+		roots, err := parseCompareRoots(*compareRootsFlag)
+		if err != nil {
+			fatalf("--compare-roots: %v", err)
+		}
+		return compareRoots(flag.CommandLine, entries, *out, roots, int64(tmpfsRoot))
+	}
+	if *watch {
+		if proveCompat {
+			fatalf("--watch: not supported with --prove-compat")
+		}
+		// The children read .rungittest.toml too, which may
+		// set watch.
+		args := append(childArgs(flag.CommandLine, *out, "watch"), "--watch=false")
+		return watchTests(args, entries, *out)
+	}
+	if tmpfsRoot > 0 {
+		if dir := os.Getenv(tmpfsEnv); dir == "" {
+			return runInTmpfs(int64(tmpfsRoot), wd)
+		} else if err := useTmpfs(dir, int64(tmpfsRoot)); err != nil {
+			fatalf("--tmpfs-root: %v", err)
+		} else if err := runAs.give(dir); err != nil {
+			fatalf("--run-as: %v", err)
+		} else if err := os.Unsetenv(tmpfsEnv); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	runID := newRunID(time.Now())
+	if err := os.Setenv(runIDEnv, runID); err != nil {
+		fatalf("%v", err)
+	}
+
+	// Like the tmpfs, the slow file system must be the test root
+	// before we take the environment of the tests.
+	slowBacking, slowMnt := "", ""
+	if *slowFSFlag > 0 {
+		if os.Geteuid() != 0 {
+			fatalf("--slow-fs: FUSE mounts need root")
+		}
+		if slowBacking, err = filepath.Abs(testRoot()); err == nil {
+			slowMnt, err = filepath.Abs(filepath.Join(*out, "slowfs"))
+		}
+		if err == nil {
+			err = os.MkdirAll(slowMnt, 0755)
+		}
+		if err == nil {
+			err = os.Setenv("GIT_TEST_OPTS", strings.TrimSpace(os.Getenv("GIT_TEST_OPTS")+" --root="+slowMnt))
+		}
 		if err != nil {
-			log.Fatalf("glob: %v", err)
+			fatalf("--slow-fs: %v", err)
+		}
+	}
+
+	env := os.Environ()
+	if runAs != nil {
+		env = append(env, runAs.env()...)
+	}
+	faketime := ""
+	if *faketimeFlag != "" {
+		if faketime, err = parseFaketime(*faketimeFlag); err != nil {
+			fatalf("--faketime: %v", err)
+		}
+		lib := *faketimeLib
+		if lib == "" {
+			if lib, err = findLibfaketime(); err != nil {
+				fatalf("--faketime: %v", err)
+			}
+		} else if _, err := os.Stat(lib); err != nil {
+			fatalf("--faketime-lib: %v", err)
+		}
+		env = append(env, faketimeEnv(lib, faketime)...)
+	}
+	for _, e := range extraEnv {
+		if !strings.Contains(e, "=") {
+			fatalf("--env: want VAR=VALUE, got %q", e)
+		}
+		env = append(env, e)
+	}
+	if len(skipPatterns) > 0 {
+		skip := strings.Join(skipPatterns, " ")
+		if old := os.Getenv("GIT_SKIP_TESTS"); old != "" {
+			skip = old + " " + skip
 		}
-		entries = append(entries, es...)
+		env = append(env, "GIT_SKIP_TESTS="+skip)
 	}
 
 	if err := os.MkdirAll(*out, 0755); err != nil {
-		log.Fatal(err)
+		fatalf("%v", err)
+	}
+	store := dirStore(*out)
+	var prior *priorRun
+	if *appendFlag {
+		if prior, err = loadPriorRun(store); err != nil {
+			fatalf("--append: %v", err)
+		}
+	}
+	if err := writeMeta(*out, runID, time.Now()); err != nil {
+		fatalf("%v", err)
+	}
+	var js *jobserver
+	if *useJobserver {
+		if js, err = connectJobserver(os.Getenv("MAKEFLAGS")); err != nil {
+			fatalf("--jobserver: %v", err)
+		}
+		if js == nil {
+			if js, err = newJobserver(*jobs, *out); err != nil {
+				fatalf("--jobserver: %v", err)
+			}
+			if err := runAs.give(filepath.Join(*out, jobserverFifo)); err != nil {
+				fatalf("--run-as: %v", err)
+			}
+		}
+		env = append(env, "MAKEFLAGS="+js.makeflags)
+	}
+
+	if *lint {
+		if failures := lintScripts(entries, *jobs, *shell); len(failures) > 0 {
+			report := formatLint(failures)
+			fmt.Print(report)
+			if err := ioutil.WriteFile(filepath.Join(*out, "lint.txt"), []byte(report), 0644); err != nil {
+				log.Print(err)
+			}
+			fatalf("not starting: %d scripts failed --lint", len(failures))
+		}
+	}
+
+	// The file systems are only mounted once nothing can stop the
+	// run from starting, so they are always unmounted again.
+	fss, err := loopFilesystems(*out, splitList(*filesystems))
+	if err != nil {
+		fatalf("--filesystems: %v", err)
+	}
+
+	root := testRoot()
+	guard := &diskGuard{
+		paths:       []string{*out, root},
+		warnBytes:   int64(diskWarn),
+		abortBytes:  int64(diskAbort),
+		warnInodes:  *inodesWarn,
+		abortInodes: *inodesAbort,
+		warned:      map[string]bool{},
+	}
+	if err := guard.check(); err != nil {
+		fatalf("not starting: %v", err)
+	}
+
+	queue := newJobs(entries)
+	if *bench > 0 {
+		queue = benchJobs(entries, *benchWarmup, *bench)
+	}
+	for _, tz := range splitList(*timezones) {
+		if _, err := time.LoadLocation(tz); err != nil {
+			log.Printf("--timezones: %v; passing it on anyway", err)
+		}
+	}
+	umaskVariants, err := umaskAxis(*umasks)
+	if err != nil {
+		fatalf("--umask: %v", err)
+	}
+	var engine *containerEngine
+	if *containerMatrix != "" {
+		if runAs != nil || *useSandbox {
+			fatalf("--container-matrix cannot be combined with --run-as or --sandbox")
+		}
+		if engine, err = newContainerEngine(*containerEngineFlag, *out, runID); err != nil {
+			fatalf("--container-matrix: %v", err)
+		}
+	}
+	variants := crossVariants(localeAxis(*locales), hashAxis(*hashes), timezoneAxis(*timezones), umaskVariants, filesystemAxis(fss), containerAxis(*containerMatrix))
+	queue = withVariants(queue, variants)
+	var notes []string
+	if faketime != "" {
+		notes = append(notes, "faketime "+faketime)
+	}
+	if *slowFSFlag > 0 {
+		notes = append(notes, fmt.Sprintf("slow-fs latency %v", *slowFSFlag))
+	}
+	if *fuzzN > 0 {
+		if *fuzzSeed == 0 {
+			*fuzzSeed = time.Now().UnixNano()
+		}
+		queue = fuzzJobs(queue, *fuzzN, *fuzzSeed)
+		notes = append(notes, fmt.Sprintf("fuzz seed %d", *fuzzSeed))
+		log.Printf("--fuzz-env: using --fuzz-seed=%d", *fuzzSeed)
+	}
+
+	hist := &history{runs: map[string][]historyEntry{}}
+	if *historyFile != "" {
+		h, err := readHistory(*historyFile)
+		if err != nil {
+			log.Printf("--history: %v", err)
+		} else {
+			hist = h
+		}
+	}
+	base := baselineFromHistory(hist)
+	if *baseline != "" {
+		b, err := readBaseline(*baseline)
+		if err != nil {
+			fatalf("--baseline: %v", err)
+		}
+		base = b
+	}
+	var knownIssues []knownIssue
+	if *knownIssuesFile != "" {
+		if knownIssues, err = readKnownIssues(*knownIssuesFile); err != nil {
+			fatalf("--known-issues: %v", err)
+		}
+	}
+	if *lastFailed {
+		var failed []*job
+		for _, j := range queue {
+			if hist.lastFailed(j.label()) {
+				failed = append(failed, j)
+			}
+		}
+		log.Printf("--last-failed: running %d of %d tests", len(failed), len(queue))
+		queue = failed
+	}
+	switch *order {
+	case "":
+		if *deadline > 0 {
+			queue = failFirst(queue, hist)
+		}
+	case "given":
+	case "fail-first":
+		queue = failFirst(queue, hist)
+	case "slow", "fast":
+		queue = byDuration(queue, hist, *order == "slow")
+	default:
+		fatalf("--order must be given, fail-first, slow or fast")
+	}
+	if *priority != "" {
+		patterns, err := readPatterns(*priority)
+		if err != nil {
+			fatalf("--priority: %v", err)
+		}
+		queue = prioritize(queue, patterns)
+	}
+	var leftOut []*job
+	if *deadline > 0 {
+		queue, leftOut = fitDeadline(queue, hist, *jobs, *deadline)
+		if len(leftOut) > 0 {
+			notes = append(notes, fmt.Sprintf("partial run: %d tests left out to meet --deadline=%s", len(leftOut), *deadline))
+			log.Printf("--deadline: leaving out %d of %d tests", len(leftOut), len(leftOut)+len(queue))
+		}
 	}
 
 	start := time.Now()
-	N := len(entries)
-	throttle := make(chan int, *jobs)
-	results := make(chan *result, N)
-	for _, e := range entries {
-		go func(nm string) {
-			throttle <- 1
-			defer func() { <-throttle }()
+	N := len(queue)
 
-			results <- runTest(nm, *out)
-		}(e)
+	var rep multiReporter
+	if *teamcity {
+		rep = append(rep, newTeamcityReporter())
+	}
+	if *azure {
+		rep = append(rep, newAzureReporter(N))
+	}
+	var lineProgress bool
+	switch *progressMode {
+	case "auto":
+		// Service messages must start on their own line, and
+		// "\r" only works on terminals.
+		lineProgress = len(rep) > 0 || !isTerminal(os.Stdout)
+	case "line":
+		lineProgress = true
+	case "overwrite":
+	default:
+		fatalf("--progress must be auto, line or overwrite")
+	}
+	if *otlp != "" {
+		rep = append(rep, newOTLPReporter(*otlp, *jobs, runID))
+	}
+	if *debugHTTP != "" {
+		runIDVar.Set(runID)
+		rep = append(rep, expvarReporter{})
+		serveDebug(*debugHTTP)
+	}
+	if *failureHookURL != "" {
+		rep = append(rep, newFailureHook(runID, *failureHookURL, *failureHookWindow, *out, *emailLink))
+	}
+	if *serveAddr != "" {
+		live := newLiveReporter(runID, N)
+		if err := serveLive(*serveAddr, *out, *historyFile, live); err != nil {
+			fatalf("--serve: %v", err)
+		}
+		rep = append(rep, live)
 	}
 
-	var failed []string
-	for i := range entries {
-		r := <-results
+	var timeouts map[string]time.Duration
+	if *autoTimeoutSpec != "" {
+		a, err := parseAutoTimeout(*autoTimeoutSpec)
+		if err != nil {
+			fatalf("--auto-timeout: %v", err)
+		}
+		timeouts = a.timeouts(hist, queue)
+		log.Printf("--auto-timeout: derived timeouts for %d of %d tests", len(timeouts), len(queue))
+	}
 
-		summary := fmt.Sprintf("%-20s - %-60s ", r.name, r.summary)
-		fmt.Printf("\r%d/%d: %s", i+1, N, summary)
-		if r.err != nil {
-			failed = append(failed, summary)
-			fmt.Println()
+	var sb *sandbox
+	if *useSandbox {
+		results := "test-results"
+		if d := os.Getenv("TEST_OUTPUT_DIRECTORY"); d != "" {
+			results = filepath.Join(d, results)
+		}
+		writable := append([]string{*out, root, results}, sandboxAllow...)
+		if js != nil && js.fifo != "" {
+			writable = append(writable, js.fifo)
+		}
+		if sb, err = newSandbox(writable); err == nil {
+			err = sb.probe(*shell)
+		}
+		if err != nil {
+			fatalf("--sandbox: %v", err)
 		}
 	}
-	fmt.Println()
 
-	sort.Strings(failed)
+	opts := &options{
+		outdir:    *out,
+		env:       env,
+		detectOOM: *detectOOM || *oomRetry,
+		timeout:   *timeout,
+		timeouts:  timeouts,
+		grace:     *grace,
+		shell:     *shell,
+
+		maxOutput:     int64(maxOutput),
+		maxOutputFail: *maxOutputFail,
+		ansi:          *ansi,
+		silence:       *silence,
+		fingerprint:   splitList(*fingerprint),
+		silenceKill:   *silenceKill,
+		wrapper:       priorityWrapper(*nice, *idle),
+		pty:           *usePTY,
+		selections:    selected,
+		history:       hist,
+		knownIssues:   knownIssues,
+		isolateHome:   *isolateHome,
+		faketime:      faketime,
+		runAs:         runAs,
+		sandbox:       sb,
+		container:     engine,
+		exec:          osExecutor{},
+	}
+	if *strictStderr {
+		allow, err := readStderrAllow(*stderrAllow)
+		if err != nil {
+			fatalf("--stderr-allow: %v", err)
+		}
+		opts.stderrAllow = allow
+	}
+	switch *ansi {
+	case "strip", "escape", "keep":
+	default:
+		fatalf("--ansi must be strip, escape or keep")
+	}
+	switch *stdin {
+	case "null":
+	case "inherit":
+		if *usePTY {
+			fatalf("--stdin=inherit cannot be combined with --pty")
+		}
+		opts.inheritStdin = true
+	default:
+		fatalf("--stdin must be null or inherit")
+	}
+	if *usePTY {
+		master, slave, err := openPTY()
+		if err != nil {
+			fatalf("--pty: %v", err)
+		}
+		master.Close()
+		slave.Close()
+	}
+	if opts.detectOOM && oomKills() < 0 {
+		log.Printf("--detect-oom: no OOM kill counter on this system, OOM kills are reported as failures")
+		opts.detectOOM = false
+	}
+	if *coverage != "" {
+		if err := checkCoverage(*coverage); err != nil {
+			fatalf("--coverage: %v", err)
+		}
+		opts.coverage = *coverage
+	}
+	if *tapOut != "" {
+		t, err := newTapWriter(*tapOut)
+		if err != nil {
+			fatalf("--tap-out: %v", err)
+		}
+		opts.tapOut = t
+	}
+	if *cpuset {
+		if _, err := exec.LookPath("taskset"); err != nil {
+			fatalf("--cpuset: %v", err)
+		}
+		if *cpusPerSlot < 1 {
+			fatalf("--cpus-per-slot must be positive")
+		}
+		opts.pin = &cpuPinning{cpus: allowedCPUs(), perSlot: *cpusPerSlot}
+		if err := opts.pin.check(*jobs); err != nil {
+			log.Printf("warning: %v", err)
+		}
+	}
+	s := newScheduler(*jobs, queue)
+	s.notes = notes
+	s.id = runID
+	s.grace = *grace
+	for _, j := range leftOut {
+		s.leftOut = append(s.leftOut, j.label())
+	}
+	ctx := context.Background()
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+	if *constraintsFile != "" {
+		cs, err := readConstraints(*constraintsFile)
+		if err != nil {
+			fatalf("--constraints: %v", err)
+		}
+		s.constraints = cs
+	}
+	if *weightsFile != "" {
+		ws, err := readWeights(*weightsFile)
+		if err != nil {
+			fatalf("--weights: %v", err)
+		}
+		s.weights = ws
+	}
+	if *replaySchedule != "" {
+		entries, err := readSchedule(*replaySchedule)
+		if err != nil {
+			fatalf("--replay-schedule: %v", err)
+		}
+		s.replay = newReplay(entries)
+	}
+	if *recordSchedule {
+		f, err := os.Create(filepath.Join(*out, "schedule.jsonl"))
+		if err != nil {
+			fatalf("--record-schedule: %v", err)
+		}
+		defer f.Close()
+		s.record = newScheduleRecorder(f)
+	}
+	if len(fss) > 0 {
+		if err := mountFilesystems(fss, int64(filesystemSize), runAs); err != nil {
+			fatalf("--filesystems: %v", err)
+		}
+		defer unmountFilesystems(fss)
+		for _, l := range fss {
+			guard.paths = append(guard.paths, l.root)
+		}
+	}
+	if slowMnt != "" {
+		sfs, err := startSlowFS(slowBacking, slowMnt, *slowFSFlag)
+		if err != nil {
+			fatalf("--slow-fs: %v", err)
+		}
+		defer func() {
+			if err := sfs.stop(); err != nil {
+				log.Printf("--slow-fs: %v", err)
+			}
+		}()
+		log.Printf("--slow-fs: serving %s on %s with %v latency", slowBacking, slowMnt, *slowFSFlag)
+	}
+	// runTests runs the queued tests, waiting delay before starting
+	// each one.
+	runTests := func(delay time.Duration) <-chan *result {
+		results := make(chan *result)
+		go s.run(ctx, func(ctx context.Context, j *job) *result {
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+				}
+			}
+			if js != nil {
+				release, err := js.acquire(ctx)
+				if err == nil {
+					defer release()
+				} else if ctx.Err() == nil {
+					log.Printf("--jobserver: %v", err)
+				}
+			}
+			if j.verbose {
+				return runTest(ctx, j, opts)
+			}
+			rep.started(j.label())
+			r := runTest(ctx, j, opts)
+			if j.isolated && r.status == statusOK {
+				r.status = statusSuspect
+				r.summary = statusSuspect + ": passed when run alone"
+			}
+			if (*leaks || *cleanLeftovers) && (r.status == statusOK || r.status == statusSkipped) && !s.othersRunning(j) {
+				leakRoot := root
+				if j.variant != nil && j.variant.root != "" {
+					leakRoot = j.variant.root
+				}
+				r.leaks = findLeaks(leakRoot, j.name)
+				if *cleanLeftovers {
+					if err := cleanLeaks(r.leaks); err != nil {
+						log.Printf("--clean-leaks: %v", err)
+					}
+				}
+			}
+			if *failFast && r.failed() {
+				s.abort(exitFailures, "--fail-fast: "+r.label()+" failed")
+			}
+			rep.finished(r)
+			return r
+		}, results)
+		return results
+	}
+
+	summaryFile := filepath.Join(*out, "summary.txt")
+	flush := func() error {
+		return store.save(prior.merged(s.snapshot(time.Now().Sub(start))))
+	}
+	if l, err := serveControl(*out, s, flush); err != nil {
+		log.Printf("control socket: %v", err)
+	} else {
+		defer l.Close()
+	}
+
+	watchJobSignals(s)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	guard.watch(s)
+	throttle := &memThrottle{minAvailable: int64(minMem), maxPressure: *maxMemPressure}
+	throttle.watch(s)
+	go func() {
+		sig := <-sigs
+		log.Printf("got %v, cancelling run", sig)
+		s.stop()
+		sig = <-sigs
+		log.Printf("got %v again, killing tests", sig)
+		s.kill()
+	}()
+
+	stopStatus := func() {}
+	if lineProgress && *statusInterval > 0 {
+		t := time.NewTicker(*statusInterval)
+		stopStatus = t.Stop
+		go func() {
+			for range t.C {
+				now := time.Now()
+				for _, l := range strings.Split(s.interim(now.Sub(start)), "\n") {
+					fmt.Printf("%s %s\n", now.Format("15:04:05"), l)
+				}
+			}
+		}()
+	}
+
+	count := 0
+	prefix := ""
+	var oom, failed []*result
+	progress := func(results <-chan *result) {
+		for r := range results {
+			count++
+			summary := r.line()
+			if *timer {
+				summary += " " + r.duration.Round(time.Millisecond).String()
+			}
+			if lineProgress {
+				fmt.Printf("%s %s%d/%d: %s\n", time.Now().Format("15:04:05"), prefix, count, N, strings.TrimRight(summary, " "))
+			} else {
+				fmt.Printf("\r%s%d/%d: %s", prefix, count, N, summary)
+			}
+			// OOM kills are rerun by --oom-retry rather than
+			// with the other failures.
+			if r.status == statusOOM {
+				oom = append(oom, r)
+			} else if r.failed() && r.attempt == 0 {
+				failed = append(failed, r)
+			}
+			if r.failed() && !lineProgress {
+				fmt.Println()
+			}
+		}
+	}
+	progress(runTests(0))
+	var retry []*result
+	for _, r := range s.snapshot(0).results {
+		if retryOn[r.failureClass()] {
+			retry = append(retry, r)
+		}
+	}
+	if len(retry) > 0 {
+		log.Printf("retrying %d tests because of --retry-on=%s", len(retry), *retryOnFlag)
+		count, N, prefix = 0, len(retry), "retry "
+		limit := 0
+		if *retrySerial {
+			limit = 1
+		}
+		s.requeue(retry, limit, false)
+		progress(runTests(*retryDelay))
+		// The other reruns start from the latest results.
+		oom, failed = nil, nil
+		for _, r := range s.snapshot(0).results {
+			if r.status == statusOOM {
+				oom = append(oom, r)
+			} else if r.failed() {
+				failed = append(failed, r)
+			}
+		}
+	}
+	if *oomRetry && len(oom) > 0 {
+		log.Printf("retrying %d OOM-killed tests one at a time", len(oom))
+		count, N, prefix = 0, len(oom), "retry "
+		s.requeue(oom, 1, false)
+		progress(runTests(*retryDelay))
+	}
+	if *isolate && len(failed) > 0 {
+		log.Printf("rerunning %d failed tests alone", len(failed))
+		results := s.snapshot(0).results
+		for _, r := range failed {
+			r.concurrent = concurrentWith(r, results)
+		}
+		count, N, prefix = 0, len(failed), "isolated "
+		s.requeue(failed, 1, true)
+		progress(runTests(0))
+	}
+	if *rerunVerbose && len(failed) > 0 {
+		log.Printf("rerunning %d failed tests with -v -x", len(failed))
+		count, N, prefix = 0, len(failed), "verbose "
+		s.rerunVerbose(failed)
+		progress(runTests(0))
+	}
+	stopStatus()
+	rep.done()
+	if opts.tapOut != nil {
+		if err := opts.tapOut.close(); err != nil {
+			log.Printf("--tap-out: %v", err)
+		}
+	}
+	if !lineProgress {
+		fmt.Println()
+	}
+
+	pruneArtifacts(*out)
 	elapsed := time.Now().Sub(start)
-	if err := ioutil.WriteFile(filepath.Join(*out, "summary.txt"),
-		[]byte(fmt.Sprintf("# run %s\n# on %s, elapsed %s:\n%s",
-			os.Args, time.Now().Format(time.RFC3339), elapsed,
-			strings.Join(failed, "\n"))), 0644); err != nil {
-		log.Fatal(err)
+	final := s.snapshot(elapsed)
+	final.newSkips = newSkips(final.results, base)
+	// The rest is about this run, but the files cover the earlier
+	// ones too.
+	written := prior.merged(final)
+	if err := store.save(written); err != nil {
+		fatalf("%v", err)
+	}
+	if opts.coverage != "" {
+		if err := mergeCoverage(*out, opts.coverage, final.results); err != nil {
+			log.Printf("--coverage: %v", err)
+		}
+	}
+	if *historyFile != "" {
+		if err := appendHistory(*historyFile, runID, final.results); err != nil {
+			log.Printf("--history: %v", err)
+		}
+	}
+
+	if *bench > 0 {
+		stats := benchStats(final.results, *benchWarmup)
+		fmt.Print(formatBenchStats(stats))
+		if err := writeBenchFile(filepath.Join(*out, "bench.txt"), final.results, *benchWarmup); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	if *areas {
+		report := areaReport(final.results)
+		fmt.Print(report)
+		if err := ioutil.WriteFile(filepath.Join(*out, "areas.txt"), []byte(report), 0644); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	if *durations {
+		report := durationReport(final.results)
+		fmt.Print(report)
+		if err := ioutil.WriteFile(filepath.Join(*out, "durations.txt"), []byte(report), 0644); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	if *critPath {
+		if report := criticalPath(final.results, s.weights, *jobs); report != "" {
+			fmt.Print(report)
+			if err := ioutil.WriteFile(filepath.Join(*out, "critical-path.txt"), []byte(report), 0644); err != nil {
+				fatalf("%v", err)
+			}
+		}
+	}
+
+	if *markdown != "" {
+		if err := ioutil.WriteFile(*markdown, []byte(markdownSummary(written.results, elapsed)), 0644); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if *email != "" {
+		if err := newDigest(final, base, *emailLink).send(*smtpAddr, *emailFrom, splitList(*email)); err != nil {
+			log.Printf("--email: %v", err)
+		}
 	}
 
-	fmt.Printf("%d failures, elapsed %s. Output to %s\n", len(failed), elapsed, *out)
+	skipped, recurring, known := 0, 0, 0
+	var failedIDs, failOn []string
+	for _, r := range final.results {
+		if r.status == statusSkipped {
+			skipped++
+			if failOnSkip {
+				failOn = append(failOn, r.label()+" (skipped)")
+			}
+		}
+		if r.failed() {
+			failedIDs = append(failedIDs, testID(r.name))
+			if r.recurrence != "" {
+				recurring++
+			}
+			if r.known() {
+				known++
+			}
+		}
+		if failOnFlaky && r.flaky() {
+			failOn = append(failOn, r.label()+" (flaky)")
+		}
+	}
+	fmt.Printf("%d failures, %d skipped, elapsed %s. Output to %s\n", len(failedIDs), skipped, elapsed, *out)
+	if *notify {
+		title := "rungittest: all passed"
+		if len(failedIDs) > 0 {
+			title = fmt.Sprintf("rungittest: %d failed", len(failedIDs))
+		}
+		body := fmt.Sprintf("%d passed, %d failed, %d skipped in %s\n%s (run %s)",
+			len(final.results)-len(failedIDs)-skipped, len(failedIDs), skipped, elapsed.Round(time.Second), *out, runID)
+		if err := notifyDesktop(title, body, len(failedIDs) > 0); err != nil {
+			log.Printf("--notify-desktop: %v", err)
+		}
+	}
+	if len(final.newSkips) > 0 {
+		fmt.Printf("%d tests skip more than before, see %s\n", len(final.newSkips), summaryFile)
+	}
+	if recurring > 0 {
+		fmt.Printf("%d failures were seen before or are known issues, see %s\n", recurring, summaryFile)
+	}
+	if *ignoreKnown && known > 0 {
+		fmt.Printf("%d failures are known issues, and ignored for the exit status\n", known)
+	}
+	if len(failOn) > 0 {
+		fmt.Printf("%d more failures because of --fail-on=%s:\n  %s\n", len(failOn), *failOnFlag, strings.Join(failOn, "\n  "))
+	}
+	if *printSkip {
+		// Variants and iterations repeat IDs.
+		sort.Strings(failedIDs)
+		var skip []string
+		for i, id := range failedIDs {
+			if i == 0 || id != failedIDs[i-1] {
+				skip = append(skip, id)
+			}
+		}
+		fmt.Printf("GIT_SKIP_TESTS='%s'\n", strings.Join(skip, " "))
+	}
+	failures := len(failedIDs) + len(failOn)
+	if *ignoreKnown {
+		failures -= known
+	}
+	return s.finalStatus(failures)
 }
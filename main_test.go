@@ -0,0 +1,148 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeScript is what a fake test script prints and how it exits.
+type fakeScript struct {
+	stdout, stderr string
+	exit           int
+	sleep          time.Duration
+}
+
+var fakeScripts = map[string]fakeScript{
+	"t0001-pass.sh":  {stdout: "ok 1 - a\nok 2 - b\n1..2\n"},
+	"t0002-fail.sh":  {stdout: "ok 1 - a\nnot ok 2 - b\n#\tgit frotz\n1..2\n", exit: 1},
+	"t0003-plan.sh":  {stdout: "ok 1 - a\n1..3\n"},
+	"t0004-skip.sh":  {stdout: "1..0 # SKIP skipping svn tests (missing SVN)\n"},
+	"t0005-crash.sh": {stdout: "ok 1 - a\n", stderr: "t0005-crash.sh: line 3: 1234 Segmentation fault git frotz\n", exit: 1},
+	"t0006-slow.sh":  {stdout: "ok 1 - a\n", sleep: time.Minute},
+	"t0007-todo.sh":  {stdout: "not ok 1 - a # TODO known breakage\n1..1\n"},
+}
+
+// fakeExecutor runs the test binary instead of the shell, which then
+// acts as the script in fakeScripts named by the last argument ending
+// in ".sh". If missing is set, the command cannot be started.
+type fakeExecutor struct {
+	missing bool
+}
+
+func (e fakeExecutor) command(argv []string) *exec.Cmd {
+	if e.missing {
+		return exec.Command("/nonexistent/sh")
+	}
+	return exec.Command(os.Args[0], append([]string{"-test.run=^TestHelperProcess$", "--"}, argv...)...)
+}
+
+// TestHelperProcess is the fake test script started by fakeExecutor.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("RUNGITTEST_FAKE_SCRIPT") != "1" {
+		return
+	}
+	name := ""
+	for _, a := range os.Args {
+		if strings.HasSuffix(a, ".sh") {
+			name = a
+		}
+	}
+	s, ok := fakeScripts[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no fake script %q\n", name)
+		os.Exit(2)
+	}
+	fmt.Print(s.stdout)
+	fmt.Fprint(os.Stderr, s.stderr)
+	time.Sleep(s.sleep)
+	os.Exit(s.exit)
+}
+
+func fakeOptions(t *testing.T) *options {
+	return &options{
+		outdir:  t.TempDir(),
+		env:     append(os.Environ(), "RUNGITTEST_FAKE_SCRIPT=1"),
+		shell:   "sh",
+		timeout: 2 * time.Second,
+		exec:    fakeExecutor{},
+	}
+}
+
+func TestRunTest(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		timeout time.Duration
+
+		status  string
+		summary string
+		passed  int
+		todo    int
+		kind    string
+		excerpt string
+	}{
+		{name: "t0001-pass.sh", status: statusOK, passed: 2},
+		{name: "t0002-fail.sh", status: statusFail, summary: "git frotz", passed: 1, excerpt: "not ok 2 - b"},
+		{name: "t0003-plan.sh", status: statusBadPlan, summary: "planned 3, got 1 results", passed: 1},
+		{name: "t0004-skip.sh", status: statusSkipped, summary: "skipping svn tests (missing SVN)"},
+		{name: "t0005-crash.sh", status: statusFail, passed: 1, kind: "crash", excerpt: "Segmentation fault"},
+		{name: "t0006-slow.sh", timeout: 100 * time.Millisecond, status: statusTimeout, summary: "timed out after 100ms", passed: 1, kind: "timeout"},
+		{name: "t0007-todo.sh", status: statusOK, todo: 1},
+	} {
+		opts := fakeOptions(t)
+		if c.timeout > 0 {
+			opts.timeout = c.timeout
+		}
+		r := runTest(context.Background(), &job{name: c.name}, opts)
+		if r.status != c.status || !strings.Contains(r.summary, c.summary) {
+			t.Errorf("%s: got %q, want status %q with %q", c.name, r.summary, c.status, c.summary)
+		}
+		if r.tap == nil {
+			t.Errorf("%s: no TAP result", c.name)
+		} else if r.tap.passed != c.passed || r.tap.todo != c.todo {
+			t.Errorf("%s: %d passed, %d todo, want %d, %d", c.name, r.tap.passed, r.tap.todo, c.passed, c.todo)
+		}
+		if k := errorKind(r.err); k != c.kind {
+			t.Errorf("%s: error %v of kind %q, want %q", c.name, r.err, k, c.kind)
+		}
+		if !strings.Contains(r.excerpt, c.excerpt) {
+			t.Errorf("%s: excerpt %q does not contain %q", c.name, r.excerpt, c.excerpt)
+		}
+	}
+}
+
+func TestRunTestSetupError(t *testing.T) {
+	opts := fakeOptions(t)
+	opts.exec = fakeExecutor{missing: true}
+	r := runTest(context.Background(), &job{name: "t0001-pass.sh"}, opts)
+	if r.status != statusFail || errorKind(r.err) != "setup" {
+		t.Errorf("got status %q, error %v, want a setup error", r.status, r.err)
+	}
+}
+
+func TestRunTestCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := runTest(ctx, &job{name: "t0001-pass.sh"}, fakeOptions(t))
+	if r.status != statusCancelled {
+		t.Errorf("got status %q, want %q", r.status, statusCancelled)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const slowestCount = 10
+
+// markdownSummary renders the results as GitHub flavored Markdown,
+// suitable for posting as a PR comment.
+func markdownSummary(results []*result, elapsed time.Duration) string {
+	counts := map[string]int{}
+	var failed []*result
+	var total time.Duration
+	for _, r := range results {
+		counts[r.status]++
+		total += r.duration
+		if r.failed() {
+			failed = append(failed, r)
+		}
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].label() < failed[j].label() })
+
+	var buf bytes.Buffer
+	icon := ":white_check_mark:"
+	if len(failed) > 0 {
+		icon = ":x:"
+	}
+	fmt.Fprintf(&buf, "## %s rungittest: %d passed, %d failed, %d skipped\n\n",
+		icon, counts[statusOK], len(failed), counts[statusSkipped])
+	fmt.Fprintf(&buf, "%d scripts, elapsed %s, total test time %s.\n\n",
+		len(results), elapsed.Round(time.Millisecond), total.Round(time.Millisecond))
+
+	if sels := bySelection(results); len(sels) > 0 {
+		fmt.Fprintf(&buf, "| Selection | Tests | Passed | Failed | Skipped |\n|---|---|---|---|---|\n")
+		for _, c := range sels {
+			fmt.Fprintf(&buf, "| %s | %d | %d | %d | %d |\n", markdownCell(c.name), c.tests,
+				c.counts[statusOK], len(c.failed), c.counts[statusSkipped])
+		}
+		buf.WriteString("\n")
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(&buf, "<details>\n<summary>Failed tests (%d)</summary>\n\n", len(failed))
+		fmt.Fprintf(&buf, "| Test | Status | Duration | Summary |\n|---|---|---|---|\n")
+		for _, r := range failed {
+			summary := r.summary
+			if r.known() {
+				summary += " (" + r.knownText() + ")"
+			}
+			fmt.Fprintf(&buf, "| `%s` | %s | %s | %s |\n", r.label(), r.status,
+				r.duration.Round(time.Millisecond), markdownCell(summary))
+		}
+		buf.WriteString("\n")
+		for _, r := range failed {
+			fmt.Fprintf(&buf, "<details>\n<summary><code>%s</code></summary>\n\n```\n%s\n```\n\n</details>\n\n",
+				r.label(), strings.Replace(r.excerpt, "```", "` ` `", -1))
+		}
+		buf.WriteString("</details>\n\n")
+	}
+
+	slowest := append([]*result{}, results...)
+	sort.Slice(slowest, func(i, j int) bool { return slowest[i].duration > slowest[j].duration })
+	if len(slowest) > slowestCount {
+		slowest = slowest[:slowestCount]
+	}
+	if len(slowest) > 0 {
+		fmt.Fprintf(&buf, "<details>\n<summary>Slowest tests</summary>\n\n")
+		fmt.Fprintf(&buf, "| Test | Duration |\n|---|---|\n")
+		for _, r := range slowest {
+			fmt.Fprintf(&buf, "| `%s` | %s |\n", r.label(), r.duration.Round(time.Millisecond))
+		}
+		buf.WriteString("\n</details>\n")
+	}
+	return buf.String()
+}
+
+func markdownCell(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.Replace(s, "|", "\\|", -1)
+	return strings.Replace(s, "\n", " ", -1)
+}
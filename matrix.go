@@ -0,0 +1,174 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// variant is one combination of settings from the test matrix.
+type variant struct {
+	// name is eg. "LANG=C,hash=sha256".
+	name string
+	env  []string
+
+	// umask, if set, is the octal umask the test runs under.
+	umask string
+
+	// root, if set, is the test root (--root) for the test.
+	root string
+
+	// image, if set, is the container image the test runs in.
+	image string
+
+	// parts are the settings of each axis, eg. ["LANG=C",
+	// "hash=sha256"].
+	parts []string
+
+	// subdir overrides the log directory derived from the name.
+	subdir string
+
+	// group, if set, is what the variant is reported under in
+	// per-variant summaries, instead of its name.
+	group *string
+}
+
+func (v *variant) groupName() string {
+	if v.group != nil {
+		return *v.group
+	}
+	return v.name
+}
+
+// dir returns the name of the subdirectory holding the logs of the
+// variant.
+func (v *variant) dir() string {
+	if v.subdir != "" {
+		return v.subdir
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', ' ', '*', '?':
+			return '_'
+		}
+		return r
+	}, v.name)
+}
+
+func label(name string, v *variant) string {
+	if v == nil {
+		return name
+	}
+	return name + " [" + v.name + "]"
+}
+
+func (r *result) label() string {
+	return label(r.name, r.variant)
+}
+
+// splitList splits a comma separated flag value.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// envAxis returns a variant for each value, setting the given
+// environment variables to it.
+func envAxis(key, values string, vars ...string) []*variant {
+	var axis []*variant
+	for _, v := range splitList(values) {
+		va := &variant{name: key + "=" + v, parts: []string{key + "=" + v}}
+		for _, e := range vars {
+			va.env = append(va.env, e+"="+v)
+		}
+		axis = append(axis, va)
+	}
+	return axis
+}
+
+func localeAxis(locales string) []*variant {
+	return envAxis("LANG", locales, "LANG", "LC_ALL")
+}
+
+func hashAxis(hashes string) []*variant {
+	return envAxis("hash", hashes, "GIT_TEST_DEFAULT_HASH")
+}
+
+func timezoneAxis(zones string) []*variant {
+	return envAxis("TZ", zones, "TZ")
+}
+
+// umaskAxis returns a variant for each octal umask.
+func umaskAxis(umasks string) ([]*variant, error) {
+	var axis []*variant
+	for _, u := range splitList(umasks) {
+		if m, err := strconv.ParseUint(u, 8, 32); err != nil || m > 0777 {
+			return nil, fmt.Errorf("bad umask %q", u)
+		}
+		axis = append(axis, &variant{name: "umask=" + u, parts: []string{"umask=" + u}, umask: u})
+	}
+	return axis, nil
+}
+
+// crossVariants returns the cross product of the given axes. Empty
+// axes are ignored; if all are empty, it returns nil.
+func crossVariants(axes ...[]*variant) []*variant {
+	var result []*variant
+	for _, axis := range axes {
+		if len(axis) == 0 {
+			continue
+		}
+		if result == nil {
+			result = axis
+			continue
+		}
+		var next []*variant
+		for _, a := range result {
+			for _, b := range axis {
+				next = append(next, &variant{
+					name:  a.name + "," + b.name,
+					env:   append(append([]string{}, a.env...), b.env...),
+					parts: append(append([]string{}, a.parts...), b.parts...),
+					umask: a.umask + b.umask,
+					root:  a.root + b.root,
+					image: a.image + b.image,
+				})
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+// withVariants returns a copy of each job for each variant.
+func withVariants(jobs []*job, variants []*variant) []*job {
+	if len(variants) == 0 {
+		return jobs
+	}
+	var result []*job
+	for _, j := range jobs {
+		for _, v := range variants {
+			result = append(result, &job{name: j.name, variant: v, iteration: j.iteration})
+		}
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const memCheckInterval = 2 * time.Second
+
+// memAvailable returns MemAvailable from /proc/meminfo.
+func memAvailable() (int64, error) {
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb << 10, nil
+		}
+	}
+	return 0, fmt.Errorf("/proc/meminfo: no MemAvailable")
+}
+
+// memPressure returns the "some avg10" value of the memory pressure
+// stall information, as a percentage.
+func memPressure() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/pressure/memory")
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if strings.HasPrefix(f, "avg10=") {
+				return strconv.ParseFloat(strings.TrimPrefix(f, "avg10="), 64)
+			}
+		}
+	}
+	return 0, fmt.Errorf("/proc/pressure/memory: no avg10")
+}
+
+// memThrottle stops dispatching tests while the machine is short on
+// memory.
+type memThrottle struct {
+	minAvailable int64
+	maxPressure  float64
+}
+
+// check returns why tests should not be started, or "".
+func (m *memThrottle) check() string {
+	if m.minAvailable > 0 {
+		if avail, err := memAvailable(); err == nil && avail < m.minAvailable {
+			return fmt.Sprintf("%s memory available", formatSize(avail))
+		}
+	}
+	if m.maxPressure > 0 {
+		if p, err := memPressure(); err == nil && p > m.maxPressure {
+			return fmt.Sprintf("memory pressure %.1f%%", p)
+		}
+	}
+	return ""
+}
+
+func (m *memThrottle) watch(s *scheduler) {
+	if m.minAvailable <= 0 && m.maxPressure <= 0 {
+		return
+	}
+	if _, err := memAvailable(); err != nil {
+		log.Printf("memory throttling disabled: %v", err)
+		return
+	}
+	go func() {
+		last := ""
+		for {
+			reason := m.check()
+			if reason != last {
+				if reason != "" {
+					log.Printf("throttling: %s", reason)
+				} else {
+					log.Printf("memory pressure subsided, resuming")
+				}
+				s.setThrottled(reason)
+				last = reason
+			}
+			time.Sleep(memCheckInterval)
+		}
+	}()
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// mergeRuns combines the results of several output dirs. The logs of
+// the i-th dir are expected under "shardI/" in the merged dir.
+func mergeRuns(dirs []string, runs []*jsonRun) *runResults {
+	rr := &runResults{
+		notes: []string{"merged from " + strings.Join(dirs, ", ")},
+	}
+	variants := map[string]*variant{}
+	shardsOf := map[string][]string{}
+	var aborted []string
+	for i, run := range runs {
+		shard := fmt.Sprintf("shard%d", i+1)
+		if run.ID != "" {
+			rr.notes = append(rr.notes, shard+": run ID "+run.ID)
+		}
+		for _, n := range run.Notes {
+			rr.notes = append(rr.notes, shard+": "+n)
+		}
+		if run.Aborted != "" {
+			aborted = append(aborted, fmt.Sprintf("%s: %s", shard, run.Aborted))
+		}
+		if e := seconds(run.Elapsed); e > rr.elapsed {
+			rr.elapsed = e
+		}
+		rr.notRun += run.NotRun
+		rr.leftOut = append(rr.leftOut, run.LeftOut...)
+		rr.partial = rr.partial || run.Partial
+		for k := range run.Tests {
+			r := run.Tests[k].result(variants)
+			r.logFile = filepath.Join(shard, r.logFile)
+			for k, a := range r.artifacts {
+				r.artifacts[k] = shard + "/" + a
+			}
+			rr.results = append(rr.results, r)
+
+			key := r.label()
+			if r.iteration > 0 {
+				key = fmt.Sprintf("%s #%d", key, r.iteration)
+			}
+			if s := shardsOf[key]; len(s) == 0 || s[len(s)-1] != dirs[i] {
+				shardsOf[key] = append(s, dirs[i])
+			}
+		}
+	}
+	rr.aborted = strings.Join(aborted, "; ")
+	for key, dirs := range shardsOf {
+		if len(dirs) > 1 {
+			rr.duplicates = append(rr.duplicates, fmt.Sprintf("%-20s - %s", key, strings.Join(dirs, ", ")))
+		}
+	}
+	sort.Strings(rr.duplicates)
+	return rr
+}
+
+func mergeMain(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("out", "", "directory for the merged results")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest merge --out MERGED DIR...\n\n"+
+			"Combines the output dirs of sharded runs into one summary.txt and\n"+
+			"results.json. The logs and artifacts of the N-th dir are copied to\n"+
+			"MERGED/shardN.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *out == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	dirs := fs.Args()
+	var runs []*jsonRun
+	for _, d := range dirs {
+		run, err := readResults(d)
+		if err != nil {
+			log.Fatal(err)
+		}
+		runs = append(runs, run)
+	}
+	for i, run := range runs {
+		for _, t := range run.Tests {
+			shard := filepath.Join(*out, fmt.Sprintf("shard%d", i+1))
+			if err := copyFile(filepath.Join(shard, t.Log), filepath.Join(dirs[i], t.Log)); err != nil {
+				log.Printf("copying log: %v", err)
+			}
+			if t.VerboseLog != "" {
+				if err := copyFile(filepath.Join(shard, t.VerboseLog), filepath.Join(dirs[i], t.VerboseLog)); err != nil {
+					log.Printf("copying log: %v", err)
+				}
+			}
+			for _, a := range t.Artifacts {
+				if err := copyFile(filepath.Join(shard, a), filepath.Join(dirs[i], a)); err != nil {
+					log.Printf("copying artifact: %v", err)
+				}
+			}
+		}
+	}
+
+	rr := mergeRuns(dirs, runs)
+	if err := rr.writeSummary(filepath.Join(*out, "summary.txt")); err != nil {
+		log.Fatal(err)
+	}
+	if err := rr.writeResults(filepath.Join(*out, "results.json")); err != nil {
+		log.Fatal(err)
+	}
+	failed := 0
+	for _, r := range rr.results {
+		if r.failed() {
+			failed++
+		}
+	}
+	fmt.Printf("%d tests from %d shards, %d failures, %d run in more than one shard. Output to %s\n",
+		len(rr.results), len(runs), failed, len(rr.duplicates), *out)
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeRuns(t *testing.T) {
+	runs := []*jsonRun{
+		{
+			ID:      "run1",
+			Elapsed: 60,
+			NotRun:  1,
+			Notes:   []string{"first"},
+			Tests: []jsonTest{
+				{Name: "t0001-a.sh", Status: statusOK, Log: "t0001-a.sh.log", Artifacts: []string{"artifacts/t0001-a.sh/x"}},
+				{Name: "t0002-b.sh", Status: statusFail, Log: "t0002-b.sh.log"},
+			},
+		},
+		{
+			ID:      "run2",
+			Elapsed: 90,
+			Aborted: "deadline reached",
+			LeftOut: []string{"t9999-slow.sh"},
+			Tests: []jsonTest{
+				{Name: "t0002-b.sh", Status: statusOK, Log: "t0002-b.sh.log"},
+				{Name: "t0003-c.sh", Variant: "LANG=C", VariantEnv: []string{"LANG=C"}, Status: statusOK, Log: "LANG=C/t0003-c.sh.log"},
+			},
+		},
+	}
+	rr := mergeRuns([]string{"out1", "out2"}, runs)
+
+	var logs []string
+	for _, r := range rr.results {
+		logs = append(logs, r.logFile)
+	}
+	if want := []string{"shard1/t0001-a.sh.log", "shard1/t0002-b.sh.log", "shard2/t0002-b.sh.log", "shard2/LANG=C/t0003-c.sh.log"}; !reflect.DeepEqual(logs, want) {
+		t.Errorf("logs %q, want %q", logs, want)
+	}
+	if a := rr.results[0].artifacts; !reflect.DeepEqual(a, []string{"shard1/artifacts/t0001-a.sh/x"}) {
+		t.Errorf("artifacts %q", a)
+	}
+	if rr.elapsed != 90*time.Second || rr.notRun != 1 {
+		t.Errorf("elapsed %s, not run %d, want 1m30s, 1", rr.elapsed, rr.notRun)
+	}
+	if want := "shard2: deadline reached"; rr.aborted != want {
+		t.Errorf("aborted %q, want %q", rr.aborted, want)
+	}
+	if want := []string{"merged from out1, out2", "shard1: run ID run1", "shard1: first", "shard2: run ID run2"}; !reflect.DeepEqual(rr.notes, want) {
+		t.Errorf("notes %q, want %q", rr.notes, want)
+	}
+	if want := []string{"t9999-slow.sh"}; !reflect.DeepEqual(rr.leftOut, want) {
+		t.Errorf("left out %q, want %q", rr.leftOut, want)
+	}
+	if len(rr.duplicates) != 1 || rr.duplicates[0] != "t0002-b.sh           - out1, out2" {
+		t.Errorf("duplicates %q", rr.duplicates)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runIDEnv, if set, gives the ID of the run, eg. one assigned by CI.
+// The tests get it too.
+const runIDEnv = "RUNGITTEST_RUN_ID"
+
+// newRunID returns an ID for a run starting at start. IDs sort by
+// start time, and the random suffix keeps runs starting in the same
+// second apart.
+func newRunID(start time.Time) string {
+	if id := os.Getenv(runIDEnv); id != "" {
+		return id
+	}
+	return start.UTC().Format("20060102T150405Z") + "-" + randomID(4)
+}
+
+// runMeta is written to meta.json when a run starts, so the output dir
+// can be matched with the notifications, metrics and history of the
+// run even if it never finishes.
+type runMeta struct {
+	ID     string    `json:"id"`
+	Start  time.Time `json:"start"`
+	Args   []string  `json:"args"`
+	Host   string    `json:"host,omitempty"`
+	Outdir string    `json:"outdir"`
+	Pid    int       `json:"pid"`
+}
+
+func writeMeta(outdir, id string, start time.Time) error {
+	m := runMeta{
+		ID:     id,
+		Start:  start,
+		Args:   os.Args,
+		Outdir: outdir,
+		Pid:    os.Getpid(),
+	}
+	if abs, err := filepath.Abs(outdir); err == nil {
+		m.Outdir = abs
+	}
+	m.Host, _ = os.Hostname()
+	data, err := json.MarshalIndent(&m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(outdir, "meta.json"), append(data, '\n'), 0644)
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultStderrAllow are stderr lines that --strict-stderr always
+// accepts: blank lines and shell traces from "sh -x".
+var defaultStderrAllow = []string{`^\s*$`, `^\++ `}
+
+// readStderrAllow returns the default patterns, plus those read from
+// path if it is set, one regular expression per line. Lines starting
+// with "#" are comments.
+func readStderrAllow(path string) ([]*regexp.Regexp, error) {
+	patterns := append([]string{}, defaultStderrAllow...)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			l := strings.TrimSpace(scanner.Text())
+			if l == "" || strings.HasPrefix(l, "#") {
+				continue
+			}
+			patterns = append(patterns, l)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// unexpectedStderr returns the lines of stderr that match none of the
+// allowed patterns.
+func unexpectedStderr(stderr []byte, allow []*regexp.Regexp) []string {
+	var noise []string
+	for _, l := range bytes.Split(cleanOutput(stderr, "strip"), []byte("\n")) {
+		line := string(l)
+		ok := false
+		for _, re := range allow {
+			if re.MatchString(line) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			noise = append(noise, line)
+		}
+	}
+	return noise
+}
+
+// noiseSummary describes the unexpected stderr lines for the summary.
+func noiseSummary(noise []string) string {
+	s := "unexpected stderr: " + noise[0]
+	if len(noise) > 1 {
+		s += fmt.Sprintf(" (and %d more lines)", len(noise)-1)
+	}
+	return s
+}
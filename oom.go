@@ -0,0 +1,78 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// readCounter returns the value following key in a "key value" per
+// line file such as /proc/vmstat, or -1.
+func readCounter(path, key string) int64 {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return -1
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) == 2 && fields[0] == key {
+			n, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return -1
+			}
+			return n
+		}
+	}
+	return -1
+}
+
+// ownCgroup returns the cgroup v2 directory of this process.
+func ownCgroup() string {
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(l, "0::") {
+			return filepath.Join("/sys/fs/cgroup", strings.TrimPrefix(l, "0::"))
+		}
+	}
+	return ""
+}
+
+// oomKills returns the number of OOM kills so far, in our own cgroup
+// if possible, and system-wide otherwise. It returns -1 if the count
+// is not available.
+func oomKills() int64 {
+	if cg := ownCgroup(); cg != "" {
+		if _, err := os.Stat(filepath.Join(cg, "memory.events")); err == nil {
+			return readCounter(filepath.Join(cg, "memory.events"), "oom_kill")
+		}
+	}
+	return readCounter("/proc/vmstat", "oom_kill")
+}
+
+func killedBySIGKILL(ps *os.ProcessState) bool {
+	if ps == nil {
+		return false
+	}
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	return ok && ws.Signaled() && ws.Signal() == syscall.SIGKILL
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// readPatterns reads a file with one test name or GIT_SKIP_TESTS
+// style pattern per line. Empty lines and lines starting with '#' are
+// ignored.
+func readPatterns(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Accept "t/t0001-init.sh" as well as "t0001".
+		patterns = append(patterns, strings.TrimSuffix(filepath.Base(line), ".sh"))
+	}
+	return patterns, scanner.Err()
+}
+
+// prioritize moves the jobs matching one of the patterns to the front
+// of the queue, in the order of the patterns. Other jobs keep their
+// order.
+func prioritize(queue []*job, patterns []string) []*job {
+	rank := func(j *job) int {
+		for i, p := range patterns {
+			if matchSkip(j.name, []string{p}) {
+				return i
+			}
+		}
+		return len(patterns)
+	}
+	ranks := map[*job]int{}
+	for _, j := range queue {
+		ranks[j] = rank(j)
+	}
+	sorted := append([]*job{}, queue...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return ranks[sorted[a]] < ranks[sorted[b]]
+	})
+	return sorted
+}
+
+// failFirst orders the queue so tests that failed in the last run
+// come first, followed by the tests that failed more often recently.
+// Other jobs keep their order.
+func failFirst(queue []*job, h *history) []*job {
+	rates := map[*job]float64{}
+	for _, j := range queue {
+		rates[j] = h.failRate(j.label())
+		if h.lastFailed(j.label()) {
+			rates[j]++
+		}
+	}
+	sorted := append([]*job{}, queue...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return rates[sorted[a]] > rates[sorted[b]]
+	})
+	return sorted
+}
+
+// defaultEstimate is the assumed duration of a test when there is no
+// history at all.
+const defaultEstimate = 30 * time.Second
+
+// fitDeadline splits the queue into the jobs expected to finish within
+// the deadline when run on the given number of slots, going through
+// the queue in order, and the jobs left out. Tests without history
+// are assumed to take the median time.
+func fitDeadline(queue []*job, h *history, slots int, deadline time.Duration) (fit, left []*job) {
+	def := h.medianDuration(defaultEstimate)
+	free := make([]time.Duration, slots)
+	for _, j := range queue {
+		d, ok := h.duration(j.label())
+		if !ok {
+			d = def
+		}
+		// Greedy list scheduling: the job goes to the slot that
+		// frees up first.
+		min := 0
+		for i := range free {
+			if free[i] < free[min] {
+				min = i
+			}
+		}
+		if free[min]+d > deadline {
+			left = append(left, j)
+			continue
+		}
+		free[min] += d
+		fit = append(fit, j)
+	}
+	return fit, left
+}
+
+// byDuration orders the queue by the durations estimated from the
+// history, longest first if slow is set. Tests without history are
+// assumed to take the median time.
+func byDuration(queue []*job, h *history, slow bool) []*job {
+	def := h.medianDuration(defaultEstimate)
+	est := map[*job]time.Duration{}
+	for _, j := range queue {
+		d, ok := h.duration(j.label())
+		if !ok {
+			d = def
+		}
+		est[j] = d
+	}
+	sorted := append([]*job{}, queue...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		if slow {
+			return est[sorted[a]] > est[sorted[b]]
+		}
+		return est[sorted[a]] < est[sorted[b]]
+	})
+	return sorted
+}
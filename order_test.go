@@ -0,0 +1,80 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testHistory returns a history where each test passed once, taking
+// the given number of seconds.
+func testHistory(secs map[string]float64) *history {
+	h := &history{runs: map[string][]historyEntry{}}
+	for name, s := range secs {
+		h.runs[name] = []historyEntry{{Name: name, Status: statusOK, Duration: s}}
+	}
+	return h
+}
+
+func jobNames(jobs []*job) []string {
+	names := []string{}
+	for _, j := range jobs {
+		names = append(names, j.name)
+	}
+	return names
+}
+
+func TestFitDeadline(t *testing.T) {
+	h := testHistory(map[string]float64{"a": 60, "b": 30, "c": 30, "d": 90, "e": 10})
+	for _, c := range []struct {
+		queue     []string
+		slots     int
+		deadline  time.Duration
+		fit, left []string
+	}{
+		{
+			queue: []string{"a", "b", "c"}, slots: 1, deadline: 2 * time.Minute,
+			fit: []string{"a", "b", "c"}, left: []string{},
+		},
+		{
+			// d no longer fits after a, but e still does.
+			queue: []string{"a", "d", "e"}, slots: 1, deadline: 2 * time.Minute,
+			fit: []string{"a", "e"}, left: []string{"d"},
+		},
+		{
+			queue: []string{"a", "d", "e"}, slots: 2, deadline: 2 * time.Minute,
+			fit: []string{"a", "d", "e"}, left: []string{},
+		},
+		{
+			// Without history, a test takes the median of 30s.
+			queue: []string{"new", "a", "b"}, slots: 1, deadline: time.Minute + 30*time.Second,
+			fit: []string{"new", "a"}, left: []string{"b"},
+		},
+		{
+			queue: []string{"d"}, slots: 4, deadline: time.Minute,
+			fit: []string{}, left: []string{"d"},
+		},
+	} {
+		fit, left := fitDeadline(newJobs(c.queue), h, c.slots, c.deadline)
+		if got := jobNames(fit); !reflect.DeepEqual(got, c.fit) {
+			t.Errorf("fitDeadline(%v, %d, %s): fit %v, want %v", c.queue, c.slots, c.deadline, got, c.fit)
+		}
+		if got := jobNames(left); !reflect.DeepEqual(got, c.left) {
+			t.Errorf("fitDeadline(%v, %d, %s): left %v, want %v", c.queue, c.slots, c.deadline, got, c.left)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The types below are the subset of the OTLP/JSON trace encoding
+// that we need.
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpString(k, v string) otlpAttribute {
+	return otlpAttribute{Key: k, Value: otlpValue{StringValue: &v}}
+}
+
+func otlpInt(k string, v int64) otlpAttribute {
+	s := strconv.FormatInt(v, 10)
+	return otlpAttribute{Key: k, Value: otlpValue{IntValue: &s}}
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpReporter collects a span per test, and exports them with a
+// root span for the whole run when the run is done.
+type otlpReporter struct {
+	url   string
+	jobs  int
+	start time.Time
+	runID string
+
+	traceID string
+	rootID  string
+	// parentID is the span from TRACEPARENT, if any.
+	parentID string
+
+	mu     sync.Mutex
+	spans  []otlpSpan
+	failed int
+}
+
+func newOTLPReporter(endpoint string, jobs int, runID string) *otlpReporter {
+	url := endpoint
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	if !strings.Contains(strings.SplitN(url, "://", 2)[1], "/") {
+		url += "/v1/traces"
+	}
+	o := &otlpReporter{
+		url:     url,
+		jobs:    jobs,
+		start:   time.Now(),
+		runID:   runID,
+		traceID: randomID(16),
+		rootID:  randomID(8),
+	}
+
+	// Join the trace of the CI pipeline, if it tells us about it.
+	if tp := strings.Split(os.Getenv("TRACEPARENT"), "-"); len(tp) == 4 && len(tp[1]) == 32 && len(tp[2]) == 16 {
+		o.traceID = tp[1]
+		o.parentID = tp[2]
+	}
+	return o
+}
+
+func (o *otlpReporter) started(name string) {}
+
+func (o *otlpReporter) finished(r *result) {
+	span := otlpSpan{
+		TraceID:           o.traceID,
+		SpanID:            randomID(8),
+		ParentSpanID:      o.rootID,
+		Name:              r.label(),
+		Kind:              1,
+		StartTimeUnixNano: unixNano(r.start),
+		EndTimeUnixNano:   unixNano(r.start.Add(r.duration)),
+		Attributes: []otlpAttribute{
+			otlpString("test.status", r.status),
+			otlpString("test.summary", r.summary),
+			otlpInt("worker.slot", int64(r.worker)),
+		},
+		Status: otlpStatus{Code: otlpStatusOK},
+	}
+	if r.variant != nil {
+		span.Attributes = append(span.Attributes, otlpString("test.variant", r.variant.name))
+	}
+	if r.tap != nil {
+		span.Attributes = append(span.Attributes,
+			otlpInt("tap.passed", int64(r.tap.passed)),
+			otlpInt("tap.failed", int64(r.tap.failed)),
+			otlpInt("tap.skipped", int64(r.tap.skipped)))
+	}
+	if r.maxRSS >= 0 {
+		span.Attributes = append(span.Attributes, otlpInt("process.max_rss", r.maxRSS))
+	}
+	if io := r.io; io != nil && io.disk() >= 0 {
+		span.Attributes = append(span.Attributes,
+			otlpInt("process.disk.read", io.diskRead),
+			otlpInt("process.disk.written", io.diskWritten))
+	}
+	if r.failed() {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: r.summary}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if r.failed() {
+		o.failed++
+	}
+	o.spans = append(o.spans, span)
+}
+
+func (o *otlpReporter) done() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	root := otlpSpan{
+		TraceID:           o.traceID,
+		SpanID:            o.rootID,
+		ParentSpanID:      o.parentID,
+		Name:              "rungittest",
+		Kind:              1,
+		StartTimeUnixNano: unixNano(o.start),
+		EndTimeUnixNano:   unixNano(time.Now()),
+		Attributes: []otlpAttribute{
+			otlpString("run.id", o.runID),
+			otlpString("run.args", strings.Join(os.Args, " ")),
+			otlpInt("run.jobs", int64(o.jobs)),
+			otlpInt("run.tests", int64(len(o.spans))),
+			otlpInt("run.failed", int64(o.failed)),
+		},
+		Status: otlpStatus{Code: otlpStatusOK},
+	}
+	if o.failed > 0 {
+		root.Status = otlpStatus{Code: otlpStatusError, Message: fmt.Sprintf("%d failures", o.failed)}
+	}
+
+	if err := o.export(append([]otlpSpan{root}, o.spans...)); err != nil {
+		log.Printf("otlp export: %v", err)
+	}
+}
+
+func (o *otlpReporter) export(spans []otlpSpan) error {
+	rs := otlpResourceSpans{}
+	rs.Resource.Attributes = []otlpAttribute{otlpString("service.name", "rungittest")}
+	if host, err := os.Hostname(); err == nil {
+		rs.Resource.Attributes = append(rs.Resource.Attributes, otlpString("host.name", host))
+	}
+	ss := otlpScopeSpans{Spans: spans}
+	ss.Scope.Name = "rungittest"
+	rs.ScopeSpans = []otlpScopeSpans{ss}
+
+	data, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(o.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", o.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxLineParse is how much of a line is kept for parsing TAP.
+const maxLineParse = 4096
+
+// cappedOutput collects the output of a test. Once more than limit
+// bytes have been written, only the first and last limit/2 bytes are
+// kept. If tap is set, lines are parsed as TAP as they come in, so the
+// results are complete even when the output is truncated.
+type cappedOutput struct {
+	limit int64
+	total int64
+	head  []byte
+	tail  []byte
+	tap   *tapResult
+	line  []byte
+
+	// act, if set, is told about each write.
+	act *activity
+}
+
+func (c *cappedOutput) Write(p []byte) (int, error) {
+	if c.act != nil {
+		c.act.wrote()
+	}
+	n := len(p)
+	c.total += int64(n)
+	if c.tap != nil {
+		c.parse(p)
+	}
+	if c.limit <= 0 {
+		c.head = append(c.head, p...)
+		return n, nil
+	}
+	headCap := int(c.limit / 2)
+	if room := headCap - len(c.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		c.head = append(c.head, p[:room]...)
+		p = p[room:]
+	}
+	tailCap := int(c.limit) - headCap
+	c.tail = append(c.tail, p...)
+	if len(c.tail) > 2*tailCap {
+		c.tail = append([]byte{}, c.tail[len(c.tail)-tailCap:]...)
+	}
+	return n, nil
+}
+
+func (c *cappedOutput) parse(p []byte) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		chunk := p
+		if i >= 0 {
+			chunk = p[:i]
+		}
+		if room := maxLineParse - len(c.line); room > 0 {
+			if room > len(chunk) {
+				room = len(chunk)
+			}
+			c.line = append(c.line, chunk[:room]...)
+		}
+		if i < 0 {
+			return
+		}
+		c.tap.parseLine(string(c.line))
+		c.line = c.line[:0]
+		p = p[i+1:]
+	}
+}
+
+// result returns the parsed TAP output.
+func (c *cappedOutput) result() *tapResult {
+	if len(c.line) > 0 {
+		c.tap.parseLine(string(c.line))
+		c.line = nil
+	}
+	c.tap.finish()
+	return c.tap
+}
+
+// truncated returns true if output was dropped.
+func (c *cappedOutput) truncated() bool {
+	return c.limit > 0 && c.total > c.limit
+}
+
+// Bytes returns the kept output, with a marker where output was
+// dropped.
+func (c *cappedOutput) Bytes() []byte {
+	if !c.truncated() {
+		return append(c.head[:len(c.head):len(c.head)], c.tail...)
+	}
+	tail := c.tail
+	if keep := int(c.limit) - len(c.head); len(tail) > keep {
+		tail = tail[len(tail)-keep:]
+	}
+	marker := fmt.Sprintf("\n\n*** %s TRUNCATED BY --max-output ***\n\n", formatSize(c.total-int64(len(c.head)+len(tail))))
+	return append(append(c.head[:len(c.head):len(c.head)], marker...), tail...)
+}
+
+// activity tracks when a test writes output, to find tests that are
+// stuck.
+type activity struct {
+	start time.Time
+
+	mu         sync.Mutex
+	first      time.Time
+	last       time.Time
+	maxSilence time.Duration
+}
+
+// begin is called when the test starts.
+func (a *activity) begin(start time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.start, a.last = start, start
+}
+
+func (a *activity) wrote() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if a.first.IsZero() {
+		a.first = now
+	}
+	if d := now.Sub(a.last); d > a.maxSilence {
+		a.maxSilence = d
+	}
+	a.last = now
+}
+
+// silence returns how long the test has been quiet.
+func (a *activity) silence() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Now().Sub(a.last)
+}
+
+// firstOutput returns how long the test ran before writing anything,
+// or -1 if it never did.
+func (a *activity) firstOutput() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.first.IsZero() {
+		return -1
+	}
+	return a.first.Sub(a.start)
+}
+
+// longestSilence returns the longest stretch without output up to
+// end.
+func (a *activity) longestSilence(end time.Time) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if d := end.Sub(a.last); d > a.maxSilence {
+		return d
+	}
+	return a.maxSilence
+}
+
+// watchSilence calls fn once if the test stays quiet for longer than
+// limit. The returned function stops the watch, and returns once fn
+// has finished.
+func (a *activity) watchSilence(limit time.Duration, fn func()) func() {
+	interval := limit / 10
+	if interval > time.Second {
+		interval = time.Second
+	}
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if a.silence() > limit {
+					fn()
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
@@ -0,0 +1,414 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// expectRE matches a test case, with its prerequisites if it
+	// has any.
+	expectRE = regexp.MustCompile(`^\s*test_expect_(?:success|failure)\s+(?:(!?[A-Z][A-Z0-9_]*(?:,!?[A-Z][A-Z0-9_]*)*)\s+)?`)
+
+	// havePrereqRE matches a prerequisite checked in script code.
+	havePrereqRE = regexp.MustCompile(`\btest_have_prereq\s+"?(!?[A-Z][A-Z0-9_]*(?:,!?[A-Z][A-Z0-9_]*)*)`)
+
+	// definePrereqRE matches a prerequisite the script defines
+	// itself.
+	definePrereqRE = regexp.MustCompile(`\btest_(?:lazy|set)_prereq\s+([A-Z][A-Z0-9_]*)`)
+
+	// libRE matches the sourcing of a test library, which may
+	// define prerequisites or skip the script.
+	libRE = regexp.MustCompile(`^\s*\.\s+"?\$\{?TEST_DIRECTORY\}?"?/lib-[\w.-]+\.sh"?\s*$`)
+)
+
+// scriptPrereqs is what a test script needs, found by reading it.
+type scriptPrereqs struct {
+	name string
+
+	// cases are the prerequisites of each test case.
+	cases [][]string
+
+	// checked are the prerequisites tested in script code, and
+	// local those the script defines.
+	checked []string
+	local   []string
+
+	// libs are the lines sourcing test libraries.
+	libs []string
+}
+
+func scanPrereqs(name string, src []byte) *scriptPrereqs {
+	sp := &scriptPrereqs{name: name}
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := expectRE.FindStringSubmatch(line); m != nil {
+			var ps []string
+			if m[1] != "" {
+				ps = strings.Split(m[1], ",")
+			}
+			sp.cases = append(sp.cases, ps)
+		}
+		for _, m := range havePrereqRE.FindAllStringSubmatch(line, -1) {
+			for _, p := range strings.Split(m[1], ",") {
+				sp.checked = appendNew(sp.checked, p)
+			}
+		}
+		for _, m := range definePrereqRE.FindAllStringSubmatch(line, -1) {
+			sp.local = appendNew(sp.local, m[1])
+		}
+		if libRE.MatchString(line) {
+			sp.libs = append(sp.libs, strings.TrimSpace(line))
+		}
+	}
+	return sp
+}
+
+// all returns the prerequisites the script needs, other than those it
+// defines.
+func (sp *scriptPrereqs) all() []string {
+	var all []string
+	for _, c := range sp.cases {
+		for _, p := range c {
+			all = appendNew(all, p)
+		}
+	}
+	for _, p := range sp.checked {
+		all = appendNew(all, p)
+	}
+	var ext []string
+	for _, p := range all {
+		if !sp.isLocal(p) {
+			ext = append(ext, p)
+		}
+	}
+	sort.Strings(ext)
+	return ext
+}
+
+func (sp *scriptPrereqs) isLocal(p string) bool {
+	for _, l := range sp.local {
+		if strings.TrimPrefix(p, "!") == l {
+			return true
+		}
+	}
+	return false
+}
+
+// probeScript is run in place of a test script. It sets up the
+// environment of the script, including its test libraries, and checks
+// each prerequisite with test_have_prereq.
+const probeScript = `test_description='rungittest prerequisite probe'
+. "$TEST_DIRECTORY"/test-lib.sh
+%s
+for rungittest_prereq in $RUNGITTEST_PREREQS
+do
+	if test_have_prereq "$rungittest_prereq"
+	then
+		echo "rungittest-prereq $rungittest_prereq ok"
+	else
+		echo "rungittest-prereq $rungittest_prereq missing"
+	fi
+done
+test_done
+`
+
+// probeResult is the outcome of probing the prerequisites of a script.
+type probeResult struct {
+	// satisfied says for each prerequisite whether it is.
+	satisfied map[string]bool
+
+	// skipAll is why the whole script would be skipped.
+	skipAll string
+
+	err error
+}
+
+// probe runs the probe for sp in a copy named like the script, so
+// GIT_SKIP_TESTS and the trash directory match.
+func probe(ctx context.Context, sp *scriptPrereqs, shell, dir string, timeout time.Duration) *probeResult {
+	pr := &probeResult{satisfied: map[string]bool{}}
+	testDir, err := os.Getwd()
+	if err != nil {
+		pr.err = err
+		return pr
+	}
+	base := filepath.Join(dir, strings.TrimSuffix(filepath.Base(sp.name), ".sh"))
+	if err := os.MkdirAll(base, 0755); err != nil {
+		pr.err = err
+		return pr
+	}
+	defer os.RemoveAll(base)
+	script := filepath.Join(base, filepath.Base(sp.name))
+	if err := ioutil.WriteFile(script, []byte(fmt.Sprintf(probeScript, strings.Join(sp.libs, "\n"))), 0755); err != nil {
+		pr.err = err
+		return pr
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, shell, filepath.ToSlash(script), "--root="+filepath.Join(base, "root"))
+	cmd.Dir = testDir
+	cmd.Env = append(os.Environ(),
+		"TEST_DIRECTORY="+testDir,
+		"RUNGITTEST_PREREQS="+strings.Join(sp.all(), " "))
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	tap := parseTAP(out)
+	pr.skipAll = tap.skipAll
+	for _, l := range strings.Split(string(out), "\n") {
+		f := strings.Fields(l)
+		if len(f) == 3 && f[0] == "rungittest-prereq" {
+			pr.satisfied[f[1]] = f[2] == "ok"
+		}
+	}
+	if err != nil && pr.skipAll == "" {
+		pr.err = err
+	}
+	return pr
+}
+
+// prereqAudit is the outcome of "rungittest prereqs".
+type prereqAudit struct {
+	Prereqs []jsonPrereq    `json:"prereqs"`
+	Scripts []jsonPrereqRun `json:"scripts"`
+
+	// Cases counts the test cases of all scripts, and Runnable
+	// those whose prerequisites are all satisfied here, in scripts
+	// that are not skipped entirely.
+	Cases    int `json:"cases"`
+	Runnable int `json:"runnable"`
+}
+
+// jsonPrereq is a prerequisite, with the number of scripts and test
+// cases needing it.
+type jsonPrereq struct {
+	Name      string `json:"name"`
+	Satisfied *bool  `json:"satisfied"`
+	Scripts   int    `json:"scripts"`
+	Cases     int    `json:"cases"`
+}
+
+type jsonPrereqRun struct {
+	Name     string   `json:"name"`
+	Cases    int      `json:"cases"`
+	Runnable int      `json:"runnable"`
+	Missing  []string `json:"missing,omitempty"`
+	Local    []string `json:"local,omitempty"`
+	SkipAll  string   `json:"skip_all,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+func auditPrereqs(sps []*scriptPrereqs, prs []*probeResult) *prereqAudit {
+	a := &prereqAudit{}
+	byName := map[string]*jsonPrereq{}
+	get := func(p string) *jsonPrereq {
+		jp := byName[p]
+		if jp == nil {
+			jp = &jsonPrereq{Name: p}
+			byName[p] = jp
+		}
+		return jp
+	}
+	for i, sp := range sps {
+		pr := prs[i]
+		run := jsonPrereqRun{Name: sp.name, Cases: len(sp.cases), Local: sp.local, SkipAll: pr.skipAll}
+		if pr.err != nil {
+			run.Error = pr.err.Error()
+		}
+		for _, p := range sp.all() {
+			jp := get(p)
+			jp.Scripts++
+			if ok, found := pr.satisfied[p]; found {
+				jp.Satisfied = &ok
+				if !ok {
+					run.Missing = append(run.Missing, p)
+				}
+			}
+		}
+		for _, c := range sp.cases {
+			runnable := pr.skipAll == "" && pr.err == nil
+			for _, p := range c {
+				if sp.isLocal(p) {
+					continue
+				}
+				get(p).Cases++
+				if !pr.satisfied[p] {
+					runnable = false
+				}
+			}
+			if runnable {
+				run.Runnable++
+			}
+		}
+		a.Cases += run.Cases
+		a.Runnable += run.Runnable
+		a.Scripts = append(a.Scripts, run)
+	}
+	for _, jp := range byName {
+		a.Prereqs = append(a.Prereqs, *jp)
+	}
+	sort.Slice(a.Prereqs, func(i, j int) bool { return a.Prereqs[i].Name < a.Prereqs[j].Name })
+	return a
+}
+
+func (a *prereqAudit) text(scripts bool) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%-24s %-9s %7s %6s\n", "prerequisite", "here", "scripts", "cases")
+	for _, p := range a.Prereqs {
+		st := "unknown"
+		if p.Satisfied != nil {
+			st = "missing"
+			if *p.Satisfied {
+				st = "ok"
+			}
+		}
+		fmt.Fprintf(&buf, "%-24s %-9s %7d %6d\n", p.Name, st, p.Scripts, p.Cases)
+	}
+	var skipped []string
+	for _, s := range a.Scripts {
+		switch {
+		case s.Error != "":
+			skipped = append(skipped, fmt.Sprintf("%-20s - probe failed: %s", s.Name, s.Error))
+		case s.SkipAll != "":
+			skipped = append(skipped, fmt.Sprintf("%-20s - %s", s.Name, s.SkipAll))
+		}
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(&buf, "\nskipped entirely %d:\n%s\n", len(skipped), strings.Join(skipped, "\n"))
+	}
+	if scripts {
+		buf.WriteString("\n")
+		for _, s := range a.Scripts {
+			fmt.Fprintf(&buf, "%-20s - %d of %d test cases", s.Name, s.Runnable, s.Cases)
+			if len(s.Missing) > 0 {
+				fmt.Fprintf(&buf, ", missing %s", strings.Join(s.Missing, ","))
+			}
+			buf.WriteString("\n")
+		}
+	}
+	fmt.Fprintf(&buf, "\n%d of %d test cases in %d scripts can run here (%s)\n",
+		a.Runnable, a.Cases, len(a.Scripts), percentOf(a.Runnable, a.Cases))
+	return buf.String()
+}
+
+func percentOf(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+// prereqsMain implements "rungittest prereqs", which lists the
+// prerequisites of the tests and whether this machine has them.
+func prereqsMain(args []string) {
+	fs := flag.NewFlagSet("prereqs", flag.ExitOnError)
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of scripts to probe in parallel")
+	shell := fs.String("shell", defaultShell(), "shell for running the probes")
+	chdir := fs.String("chdir", "", "change to this directory before expanding globs")
+	skipTests := fs.String("skip-tests", "", "GIT_SKIP_TESTS style patterns of tests to skip")
+	timeout := fs.Duration("timeout", time.Minute, "time limit for probing one script")
+	jsonOut := fs.Bool("json", false, "print the audit as JSON")
+	scripts := fs.Bool("scripts", false, "also list the runnable test cases and missing prerequisites of each script")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest prereqs [flags] GLOB...\n\n"+
+			"Lists the prerequisites the tests matching GLOB need, and whether they are\n"+
+			"satisfied here, with the number of test cases that can run. The scripts are\n"+
+			"not run: their test cases are read from the source, and the prerequisites\n"+
+			"are checked by a probe that sources test-lib.sh and the libraries the script\n"+
+			"uses.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || *jobs < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *chdir != "" {
+		if err := os.Chdir(*chdir); err != nil {
+			log.Fatalf("chdir: %v", err)
+		}
+	}
+	tests, err := selectTests(fs.Args(), strings.Fields(*skipTests))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(tests) == 0 {
+		log.Fatal("no tests")
+	}
+	var sps []*scriptPrereqs
+	for _, t := range tests {
+		src, err := ioutil.ReadFile(t)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sps = append(sps, scanPrereqs(t, src))
+	}
+
+	dir, err := ioutil.TempDir("", "rungittest-prereqs")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	prs := make([]*probeResult, len(sps))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < *jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				prs[i] = probe(context.Background(), sps[i], *shell, dir, *timeout)
+			}
+		}()
+	}
+	for i := range sps {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	a := auditPrereqs(sps, prs)
+	if *jsonOut {
+		data, err := json.MarshalIndent(a, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s\n", data)
+		return
+	}
+	fmt.Print(a.text(*scripts))
+}
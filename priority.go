@@ -0,0 +1,45 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+	"os/exec"
+)
+
+// wrapWith returns prefix extended with the given command, if it is
+// installed.
+func wrapWith(prefix []string, cmd ...string) []string {
+	if _, err := exec.LookPath(cmd[0]); err != nil {
+		log.Printf("%s not found, not using it", cmd[0])
+		return prefix
+	}
+	return append(prefix, cmd...)
+}
+
+// priorityWrapper returns the command prefix for running tests at low
+// priority. With idle, tests only get CPU and I/O time the rest of
+// the system doesn't want.
+func priorityWrapper(nice, idle bool) []string {
+	var prefix []string
+	if nice || idle {
+		prefix = wrapWith(prefix, "nice", "-n", "19")
+	}
+	if idle {
+		prefix = wrapWith(prefix, "ionice", "-c", "3")
+		prefix = wrapWith(prefix, "chrt", "--idle", "0")
+	}
+	return prefix
+}
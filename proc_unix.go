@@ -0,0 +1,100 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// setProcGroup puts the test in its own process group, so it can be
+// killed along with its children.
+func setProcGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// setCredential makes the command run as the given user.
+func setCredential(cmd *exec.Cmd, u *runAsUser) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c := &syscall.Credential{Uid: uint32(u.uid), Gid: uint32(u.gid)}
+	for _, g := range u.groups {
+		c.Groups = append(c.Groups, uint32(g))
+	}
+	cmd.SysProcAttr.Credential = c
+}
+
+// procTree is a started test along with its children.
+type procTree struct {
+	p *os.Process
+}
+
+func newProcTree(p *os.Process) *procTree {
+	return &procTree{p: p}
+}
+
+// kill kills the process group of the test, or just the test if it
+// does not lead a process group.
+func (t *procTree) kill() error {
+	if err := syscall.Kill(-t.p.Pid, syscall.SIGKILL); err != nil {
+		return t.p.Kill()
+	}
+	return nil
+}
+
+// terminate sends SIGTERM to the process group of the test.
+func (t *procTree) terminate() error {
+	if err := syscall.Kill(-t.p.Pid, syscall.SIGTERM); err != nil {
+		return t.p.Signal(syscall.SIGTERM)
+	}
+	return nil
+}
+
+func (t *procTree) release() {}
+
+func defaultShell() string {
+	return "/bin/sh"
+}
+
+// watchJobSignals makes SIGUSR1 and SIGUSR2 add and remove a worker
+// slot respectively.
+func watchJobSignals(s *scheduler) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			delta := 1
+			if sig == syscall.SIGUSR2 {
+				delta = -1
+			}
+			log.Printf("got %v, jobs now %d", sig, s.addJobs(delta))
+		}
+	}()
+}
+
+// forwardSignals are the signals a parent process passes on to the
+// child running the tests.
+var forwardSignals = []os.Signal{syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2}
+
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0600)
+}
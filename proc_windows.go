@@ -0,0 +1,129 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+var (
+	kernel32                 = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObject      = kernel32.NewProc("CreateJobObjectW")
+	procAssignProcessToJob   = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject   = kernel32.NewProc("TerminateJobObject")
+	processSetQuotaTerminate = uint32(0x0100 | 0x0001)
+)
+
+func setProcGroup(cmd *exec.Cmd) {}
+
+// setCredential is never called: lookupRunAs fails on Windows.
+func setCredential(cmd *exec.Cmd, u *runAsUser) {}
+
+// procTree is a started test along with its children, which are
+// tracked with a job object.
+type procTree struct {
+	p   *os.Process
+	job syscall.Handle
+}
+
+// newProcTree puts the test in a new job object. Processes it starts
+// before we get to assign it are not part of the job.
+func newProcTree(p *os.Process) *procTree {
+	t := &procTree{p: p}
+	job, _, _ := procCreateJobObject.Call(0, 0)
+	if job == 0 {
+		return t
+	}
+	h, err := syscall.OpenProcess(processSetQuotaTerminate, false, uint32(p.Pid))
+	if err != nil {
+		syscall.CloseHandle(syscall.Handle(job))
+		return t
+	}
+	defer syscall.CloseHandle(h)
+	if ok, _, _ := procAssignProcessToJob.Call(job, uintptr(h)); ok == 0 {
+		syscall.CloseHandle(syscall.Handle(job))
+		return t
+	}
+	t.job = syscall.Handle(job)
+	return t
+}
+
+func (t *procTree) kill() error {
+	if t.job != 0 {
+		if ok, _, err := procTerminateJobObject.Call(uintptr(t.job), 1); ok == 0 {
+			return err
+		}
+		return nil
+	}
+	return t.p.Kill()
+}
+
+// terminate is not supported: there is no SIGTERM on Windows, so
+// cancelled tests are killed right away.
+func (t *procTree) terminate() error {
+	return errNotSupported
+}
+
+func (t *procTree) release() {
+	if t.job != 0 {
+		syscall.CloseHandle(t.job)
+		t.job = 0
+	}
+}
+
+// defaultShell finds the sh.exe that comes with Git for Windows.
+func defaultShell() string {
+	if sh, err := exec.LookPath("sh"); err == nil {
+		return sh
+	}
+	var candidates []string
+	if git, err := exec.LookPath("git"); err == nil {
+		// git.exe lives in cmd/ or bin/ of the installation.
+		root := filepath.Dir(filepath.Dir(git))
+		candidates = append(candidates,
+			filepath.Join(root, "usr", "bin", "sh.exe"),
+			filepath.Join(root, "bin", "sh.exe"))
+	}
+	for _, env := range []string{"ProgramFiles", "ProgramW6432", "LOCALAPPDATA"} {
+		dir := os.Getenv(env)
+		if dir == "" {
+			continue
+		}
+		if env == "LOCALAPPDATA" {
+			dir = filepath.Join(dir, "Programs")
+		}
+		candidates = append(candidates,
+			filepath.Join(dir, "Git", "usr", "bin", "sh.exe"),
+			filepath.Join(dir, "Git", "bin", "sh.exe"))
+	}
+	for _, c := range candidates {
+		if _, err := os.Stat(c); err == nil {
+			return c
+		}
+	}
+	return "sh.exe"
+}
+
+// watchJobSignals is a no-op: there are no SIGUSR1/SIGUSR2 on Windows.
+func watchJobSignals(s *scheduler) {}
+
+var forwardSignals = []os.Signal{syscall.SIGTERM}
+
+func mkfifo(path string) error {
+	return errNotSupported
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// hasProveCompat returns true if --prove-compat is among the
+// arguments.
+func hasProveCompat(args []string) bool {
+	for _, a := range args {
+		if a == "--prove-compat" || a == "-prove-compat" {
+			return true
+		}
+	}
+	return false
+}
+
+// proveArgs translates a prove command line, as used by git's
+// Makefile, into our flags:
+//
+//	-jN, -j N, --jobs=N    --jobs=N
+//	--exec SH, -e SH       --shell=SH
+//	--state=save,slow,...  --order, --last-failed
+//	--timer                --timer
+//	-- ARGS, :: ARGS       appended to GIT_TEST_OPTS
+//
+// Our own flags pass through, and flags of prove without an
+// equivalent are ignored with a warning.
+func proveArgs(args []string) ([]string, error) {
+	var flags, tests []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		value := func() (string, error) {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("%s needs a value", a)
+			}
+			i++
+			return args[i], nil
+		}
+		switch {
+		case a == "::" || a == "--":
+			extra := strings.Join(args[i+1:], " ")
+			os.Setenv("GIT_TEST_OPTS", strings.TrimSpace(os.Getenv("GIT_TEST_OPTS")+" "+extra))
+			i = len(args)
+		case a == "--prove-compat" || a == "-prove-compat":
+		case a == "-j" || a == "--jobs":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			flags = append(flags, "--jobs="+v)
+		case strings.HasPrefix(a, "-j") && !strings.HasPrefix(a, "-jobs"):
+			flags = append(flags, "--jobs="+a[2:])
+		case a == "-e" || a == "--exec":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			flags = append(flags, "--shell="+v)
+		case strings.HasPrefix(a, "--exec="):
+			flags = append(flags, "--shell="+strings.TrimPrefix(a, "--exec="))
+		case strings.HasPrefix(a, "--state="):
+			for _, st := range strings.Split(strings.TrimPrefix(a, "--state="), ",") {
+				switch st {
+				case "save", "":
+					// The history is always saved.
+				case "slow":
+					flags = append(flags, "--order=slow")
+				case "fast":
+					flags = append(flags, "--order=fast")
+				case "failed":
+					flags = append(flags, "--last-failed")
+				default:
+					log.Printf("--prove-compat: ignoring --state=%s", st)
+				}
+			}
+		case a == "-v" || a == "--verbose" || a == "-q" || a == "-Q" ||
+			a == "--quiet" || a == "--QUIET" || a == "--merge" || a == "--color" || a == "--nocolor":
+			log.Printf("--prove-compat: ignoring %s", a)
+		case strings.HasPrefix(a, "-"):
+			flags = append(flags, a)
+			// Our flags may take their value separately.
+			if !strings.Contains(a, "=") && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") && takesValue(a) {
+				i++
+				flags = append(flags, args[i])
+			}
+		default:
+			tests = append(tests, a)
+		}
+	}
+	return append(flags, tests...), nil
+}
+
+// takesValue returns true if the flag is one of ours and is not a
+// boolean.
+func takesValue(arg string) bool {
+	f := flag.Lookup(strings.TrimLeft(arg, "-"))
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
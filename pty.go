@@ -0,0 +1,53 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// ptyCapture runs a command on a pseudo-terminal, and collects
+// everything written to it.
+type ptyCapture struct {
+	master, slave *os.File
+	done          chan struct{}
+}
+
+// capturePTY sets up cmd to run on a new pseudo-terminal, and copies
+// its output to w.
+func capturePTY(cmd *exec.Cmd, w io.Writer) (*ptyCapture, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	attachPTY(cmd, slave)
+	p := &ptyCapture{master: master, slave: slave, done: make(chan struct{})}
+	go func() {
+		// Reading fails with EIO once all slave fds are closed.
+		io.Copy(w, master)
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// wait waits for the output to be drained. It must be called after
+// the command has exited.
+func (p *ptyCapture) wait() {
+	p.slave.Close()
+	<-p.done
+	p.master.Close()
+}
@@ -0,0 +1,254 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	hexRE   = regexp.MustCompile(`\b[0-9a-f]{7,}\b`)
+	digitRE = regexp.MustCompile(`[0-9]+`)
+)
+
+// failureSignature identifies how a test failed, so the same failure
+// can be recognized across runs: the first failing test case, or the
+// status and summary if no test case failed. Object IDs and numbers
+// are masked, as they vary between runs.
+func failureSignature(stdout []byte, status, summary string) string {
+	sig := ""
+	for _, l := range strings.Split(string(stdout), "\n") {
+		l = strings.TrimRight(l, "\r")
+		if !strings.HasPrefix(l, "not ok ") {
+			continue
+		}
+		if d, _ := directive(l); d == "todo" {
+			continue
+		}
+		sig = strings.TrimPrefix(l, "not ok ")
+		if i := strings.Index(sig, " - "); i >= 0 {
+			sig = sig[i+3:]
+		}
+		break
+	}
+	if sig == "" {
+		sig = summary
+	}
+	sig = hexRE.ReplaceAllString(sig, "H")
+	return status + ": " + digitRE.ReplaceAllString(sig, "N")
+}
+
+// signatureStats counts the earlier occurrences of a failure.
+type signatureStats struct {
+	count int
+	first time.Time
+}
+
+// knownIssue links failures to a bug. An empty pattern matches all
+// tests, and a nil re all signatures.
+type knownIssue struct {
+	pattern string
+	re      *regexp.Regexp
+	link    string
+	note    string
+}
+
+// readKnownIssues reads the --known-issues file. Files ending in .yaml
+// or .yml are read with readKnownIssuesYAML; others have lines such as
+//
+//	t5310      https://bugs.example.com/123  bitmap.*corrupt
+//	t9*-svn-*  https://bugs.example.com/456
+//
+// giving a GIT_SKIP_TESTS style pattern for the test, the link, and
+// optionally a regular expression for the failure signature. Empty
+// lines and lines starting with '#' are ignored.
+func readKnownIssues(path string) ([]knownIssue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		return parseKnownIssuesYAML(path, string(data))
+	}
+	var issues []knownIssue
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if len(fields) < 2 || fields[1] == "" {
+			return nil, fmt.Errorf("%s:%d: want \"TEST LINK [REGEXP]\"", path, n+1)
+		}
+		issue := knownIssue{pattern: fields[0], link: fields[1]}
+		if len(fields) == 3 && fields[2] != "" {
+			if issue.re, err = regexp.Compile(fields[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, n+1, err)
+			}
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// parseKnownIssuesYAML parses the subset of YAML we need: a list of
+// entries such as
+//
+//   - test: t5310-pack-bitmaps
+//     signature: "bitmap.*corrupt"
+//     url: https://bugs.example.com/123
+//     note: racy on NFS
+//
+// Each entry needs a test pattern or a signature regular expression,
+// and a url or a note.
+func parseKnownIssuesYAML(name, data string) ([]knownIssue, error) {
+	var issues []knownIssue
+	var cur *knownIssue
+	start := 0
+	finish := func() error {
+		if cur == nil {
+			return nil
+		}
+		if cur.pattern == "" && cur.re == nil {
+			return fmt.Errorf("%s:%d: entry needs a test or a signature", name, start)
+		}
+		if cur.link == "" && cur.note == "" {
+			return fmt.Errorf("%s:%d: entry needs a url or a note", name, start)
+		}
+		issues = append(issues, *cur)
+		return nil
+	}
+	for i, line := range strings.Split(data, "\n") {
+		n := i + 1
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			cur, start = &knownIssue{}, n
+			line = strings.TrimPrefix(line, "-")
+		} else if cur == nil || line[0] != ' ' {
+			return nil, fmt.Errorf("%s:%d: want a list of entries", name, n)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("%s:%d: want key: value", name, n)
+		}
+		key := strings.TrimSpace(line[:colon])
+		value, err := yamlScalar(strings.TrimSpace(line[colon+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		switch key {
+		case "test":
+			cur.pattern = value
+		case "signature":
+			if cur.re, err = regexp.Compile(value); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+			}
+		case "url":
+			cur.link = value
+		case "note":
+			cur.note = value
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown key %q", name, n, key)
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return issues, nil
+}
+
+// yamlScalar returns a plain or quoted YAML string.
+func yamlScalar(v string) (string, error) {
+	if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "'") {
+		return parseScalar(v)
+	}
+	return v, nil
+}
+
+// stripYAMLComment removes a '#' comment that starts a line or follows
+// a space, outside quotes, leaving URL fragments alone.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// matchKnownIssue returns the first issue matching the failure, or
+// nil.
+func matchKnownIssue(issues []knownIssue, r *result) *knownIssue {
+	for i := range issues {
+		k := &issues[i]
+		if (k.pattern == "" || matchSkip(r.name, []string{k.pattern})) && (k.re == nil || k.re.MatchString(r.signature)) {
+			return k
+		}
+	}
+	return nil
+}
+
+// annotateFailure says in recurrence whether a failure was seen before
+// in the history, and which known issue it is.
+func annotateFailure(r *result, h *history, issues []knownIssue) {
+	var notes []string
+	if h != nil {
+		if st := h.signatures[r.label()+"\x00"+r.signature]; st != nil {
+			notes = append(notes, fmt.Sprintf("seen %d times before, first on %s", st.count, st.first.Format("2006-01-02")))
+		}
+	}
+	if k := matchKnownIssue(issues, r); k != nil {
+		r.knownIssue, r.knownNote = k.link, k.note
+		notes = append(notes, r.knownText())
+	}
+	r.recurrence = strings.Join(notes, ", ")
+}
+
+// known returns true if the failure matched --known-issues.
+func (r *result) known() bool {
+	return r.knownIssue != "" || r.knownNote != ""
+}
+
+// knownText describes the known issue for reports.
+func (r *result) knownText() string {
+	return strings.TrimSpace("known issue " + r.knownIssue + " " + r.knownNote)
+}
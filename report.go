@@ -0,0 +1,349 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// loadRun reads back the results of a finished run from its output
+// dir, with the excerpts of the failures taken from the logs.
+func loadRun(dir string) (*runResults, error) {
+	run, err := readResults(dir)
+	if err != nil {
+		return nil, err
+	}
+	rr := &runResults{
+		elapsed:    seconds(run.Elapsed),
+		notRun:     run.NotRun,
+		aborted:    run.Aborted,
+		notes:      run.Notes,
+		leftOut:    run.LeftOut,
+		partial:    run.Partial,
+		duplicates: run.Duplicates,
+		newSkips:   run.NewSkips,
+		args:       run.Args,
+		start:      run.Start,
+		id:         run.ID,
+		appended:   run.Appended,
+	}
+	variants := map[string]*variant{}
+	for i := range run.Tests {
+		r := run.Tests[i].result(variants)
+		if r.failed() {
+			if stdout, stderr, err := readLog(dir, r.logFile); err == nil {
+				r.excerpt = failureExcerpt(stdout, stderr)
+			}
+		}
+		rr.results = append(rr.results, r)
+	}
+	return rr, nil
+}
+
+// readLog returns the stdout and stderr sections of a log written by
+// runTest.
+func readLog(dir, logFile string) ([]byte, []byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, logFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	var stdout, stderr []byte
+	if i := bytes.Index(data, []byte("*** STDOUT")); i >= 0 {
+		if j := bytes.Index(data[i:], []byte(" ***\n\n")); j >= 0 {
+			stdout = data[i+j+len(" ***\n\n"):]
+		}
+	}
+	sep := []byte("\n\n*** STDERR: ***\n\n")
+	if i := bytes.LastIndex(stdout, sep); i >= 0 {
+		stdout, stderr = stdout[:i], stdout[i+len(sep):]
+	}
+	return stdout, stderr, nil
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      float64     `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitReport renders the results as JUnit XML, with a test case per
+// script, classed by area.
+func junitReport(rr *runResults) ([]byte, error) {
+	suite := junitSuite{
+		Name:      "rungittest",
+		Time:      rr.elapsed.Seconds(),
+		Timestamp: rr.start.Format(time.RFC3339),
+	}
+	for _, r := range rr.results {
+		c := junitCase{
+			Name:      r.label(),
+			Classname: "rungittest." + testArea(r.name),
+			Time:      r.duration.Seconds(),
+		}
+		msg := &junitMessage{Message: r.summary, Type: r.status, Text: cleanText(r.excerpt, "escape")}
+		switch {
+		case r.status == statusOOM:
+			c.Error = msg
+			suite.Errors++
+		case r.failed():
+			c.Failure = msg
+			suite.Failures++
+		case r.status == statusSkipped || r.status == statusCancelled:
+			c.Skipped = &junitMessage{Message: r.summary}
+			suite.Skipped++
+		}
+		if r.known() {
+			c.SystemOut = r.knownText()
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, c)
+	}
+	data, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>rungittest: {{.Failed}} failed, {{.Passed}} passed</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+.failed { color: #b00; } .ok { color: #070; } .other { color: #777; }
+pre { background: #f6f6f6; padding: 0.5em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>rungittest: {{.Failed}} failed, {{.Passed}} passed, {{.Skipped}} skipped</h1>
+<p>{{len .Tests}} scripts, started {{.Start}}, elapsed {{.Elapsed}}.{{if .Aborted}} Aborted: {{.Aborted}}.{{end}}</p>
+{{range .Notes}}<p>{{.}}</p>
+{{end}}
+{{- if .Failures}}
+<h2>Failures</h2>
+{{range .Failures}}<details>
+<summary><span class="failed">{{.Label}}</span> {{.Summary}}{{if .Recurrence}} ({{.Recurrence}}){{end}}</summary>
+<p><a href="{{.Log}}">log</a>{{if .VerboseLog}} <a href="{{.VerboseLog}}">verbose log</a>{{end}}</p>
+<pre>{{.Excerpt}}</pre>
+</details>
+{{end}}
+{{- end}}
+<h2>Tests</h2>
+<table>
+<tr><th>Test</th><th>Status</th><th>Duration</th><th>Summary</th><th>Files</th></tr>
+{{range .Tests}}<tr><td>{{.Label}}</td><td class="{{.Class}}">{{.Status}}</td><td>{{.Duration}}</td><td>{{.Summary}}</td><td><a href="{{.Log}}">log</a>{{range .Artifacts}} <a href="{{.}}">{{.}}</a>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type htmlTest struct {
+	Label, Status, Class, Summary, Duration string
+	Log, VerboseLog                         string
+	Recurrence, Excerpt                     string
+	Artifacts                               []string
+}
+
+// htmlReport renders the results as a single HTML page. Links to logs
+// and artifacts are relative to base, which is where the page goes
+// relative to the output dir.
+func htmlReport(rr *runResults, base string) ([]byte, error) {
+	link := func(p string) string {
+		if p == "" {
+			return ""
+		}
+		return filepath.ToSlash(filepath.Join(base, p))
+	}
+	data := struct {
+		Failed, Passed, Skipped int
+		Start, Elapsed, Aborted string
+		Notes                   []string
+		Tests, Failures         []htmlTest
+	}{
+		Start:   rr.start.Format(time.RFC3339),
+		Elapsed: rr.elapsed.Round(time.Millisecond).String(),
+		Aborted: rr.aborted,
+		Notes:   rr.notes,
+	}
+	results := append([]*result{}, rr.results...)
+	sort.SliceStable(results, func(i, j int) bool { return results[i].label() < results[j].label() })
+	for _, r := range results {
+		t := htmlTest{
+			Label:      r.label(),
+			Status:     r.status,
+			Class:      "other",
+			Summary:    r.summary,
+			Duration:   r.duration.Round(time.Millisecond).String(),
+			Log:        link(r.logFile),
+			VerboseLog: link(r.verboseLog),
+			Recurrence: r.recurrence,
+			Excerpt:    r.excerpt,
+		}
+		for _, a := range r.artifacts {
+			t.Artifacts = append(t.Artifacts, link(a))
+		}
+		switch {
+		case r.failed():
+			t.Class = "failed"
+			data.Failed++
+			data.Failures = append(data.Failures, t)
+		case r.status == statusOK:
+			t.Class = "ok"
+			data.Passed++
+		case r.status == statusSkipped:
+			data.Skipped++
+		}
+		data.Tests = append(data.Tests, t)
+	}
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tapReport rebuilds the --tap-out stream from the logs.
+func tapReport(rr *runResults, dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	t := &tapWriter{w: bufio.NewWriter(&buf)}
+	for _, r := range rr.results {
+		stdout, _, err := readLog(dir, r.logFile)
+		if err != nil {
+			log.Printf("%s: %v", r.label(), err)
+		}
+		t.add(r, stdout)
+	}
+	if err := t.finish(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func reportMain(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	format := fs.String("format", "html", "report format: html, json, junit, markdown, tap, badge-svg or badge-json")
+	historyFile := fs.String("history", defaultHistoryPath(), "history of past runs, for the duration trend of the badge")
+	out := fs.String("out", "", "file to write the report to (default: stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest report [flags] DIR\n\n"+
+			"Renders a report from the results.json and logs of the finished run\n"+
+			"in DIR, so any format can be had after the fact. Links in the HTML\n"+
+			"report are relative to where it is written.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := fs.Arg(0)
+	rr, err := loadRun(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var data []byte
+	switch *format {
+	case "html":
+		base := dir
+		if *out != "" {
+			absDir, err1 := filepath.Abs(dir)
+			absOut, err2 := filepath.Abs(filepath.Dir(*out))
+			if err1 != nil || err2 != nil {
+				log.Fatalf("%v %v", err1, err2)
+			}
+			if base, err = filepath.Rel(absOut, absDir); err != nil {
+				log.Fatal(err)
+			}
+		}
+		data, err = htmlReport(rr, base)
+	case "json":
+		data, err = rr.resultsJSON()
+	case "junit":
+		data, err = junitReport(rr)
+	case "markdown":
+		data = []byte(markdownSummary(rr.results, rr.elapsed))
+	case "tap":
+		data, err = tapReport(rr, dir)
+	case "badge-svg", "badge-json":
+		var h *history
+		if *historyFile != "" {
+			var err error
+			if h, err = readHistory(*historyFile); err != nil {
+				log.Printf("--history: %v", err)
+			}
+		}
+		st := healthOf(rr, h)
+		if *format == "badge-svg" {
+			data = st.svg()
+		} else {
+			data, err = st.json()
+		}
+	default:
+		log.Fatalf("--format: unknown format %q, want html, json, junit, markdown, tap, badge-svg or badge-json", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = ioutil.WriteFile(*out, data, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
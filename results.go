@@ -0,0 +1,424 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// results.json holds the same information as summary.txt, for mining
+// results across many runs.
+
+type jsonTAP struct {
+	Planned int      `json:"planned"`
+	Passed  int      `json:"passed"`
+	Failed  int      `json:"failed"`
+	Skipped int      `json:"skipped"`
+	Todo    int      `json:"todo"`
+	Missing []string `json:"missing,omitempty"`
+
+	SkipReasons []jsonSkipReason `json:"skip_reasons,omitempty"`
+}
+
+// jsonSkipReason is a normalized reason for skipping, see skipReason.
+// In the totals of the run, Tests is the number of scripts with tests
+// skipped for the reason, and Scripts those skipped entirely.
+type jsonSkipReason struct {
+	Kind   string `json:"kind"`
+	Key    string `json:"key,omitempty"`
+	Text   string `json:"text,omitempty"`
+	Count  int    `json:"count"`
+	Script bool   `json:"script,omitempty"`
+
+	Tests   int `json:"tests,omitempty"`
+	Scripts int `json:"scripts,omitempty"`
+}
+
+type jsonPrereqs struct {
+	Satisfied   []string `json:"satisfied,omitempty"`
+	Unsatisfied []string `json:"unsatisfied,omitempty"`
+}
+
+type jsonCounts struct {
+	Total   int `json:"total"`
+	Success int `json:"success"`
+	Fixed   int `json:"fixed"`
+	Broken  int `json:"broken"`
+	Failed  int `json:"failed"`
+}
+
+// jsonError is the error of a test. Kind is "setup" if the test could
+// not be started, "timeout" if it was stopped for running too long or
+// going silent, "crash" if it died of a signal, and absent otherwise.
+type jsonError struct {
+	Kind    string `json:"kind,omitempty"`
+	Message string `json:"message"`
+}
+
+type jsonIO struct {
+	DiskRead    int64   `json:"disk_read"`
+	DiskWritten int64   `json:"disk_written"`
+	Read        int64   `json:"read"`
+	Written     int64   `json:"written"`
+	Syscalls    int64   `json:"syscalls"`
+	Wait        float64 `json:"wait,omitempty"`
+}
+
+type jsonTest struct {
+	Name       string   `json:"name"`
+	Variant    string   `json:"variant,omitempty"`
+	VariantEnv []string `json:"variant_env,omitempty"`
+
+	VariantParts []string `json:"variant_parts,omitempty"`
+	VariantGroup *string  `json:"variant_group,omitempty"`
+
+	Status  string `json:"status"`
+	Summary string `json:"summary"`
+	Log     string `json:"log"`
+
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration"`
+	CPUUser  float64   `json:"cpu_user"`
+	CPUSys   float64   `json:"cpu_sys"`
+	MaxRSS   int64     `json:"max_rss,omitempty"`
+	Worker   int       `json:"worker"`
+
+	// IO are the I/O counters of the test, -1 where unknown.
+	IO *jsonIO `json:"io,omitempty"`
+
+	Attempt        int    `json:"attempt,omitempty"`
+	PreviousStatus string `json:"previous_status,omitempty"`
+	Iteration      int    `json:"iteration,omitempty"`
+
+	TAP       *jsonTAP    `json:"tap,omitempty"`
+	GitCounts *jsonCounts `json:"git_counts,omitempty"`
+	Leaks     []string    `json:"leaks,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+
+	// FirstOutput is the time until the test first wrote output,
+	// absent if it never did.
+	FirstOutput *float64 `json:"first_output,omitempty"`
+	MaxSilence  float64  `json:"max_silence"`
+	Silent      bool     `json:"silent,omitempty"`
+
+	// Prereqs are the lazy prerequisites test-lib.sh reported, and
+	// Env the --fingerprint-env variables.
+	Prereqs *jsonPrereqs      `json:"prereqs,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+
+	// Artifacts are the files the test saved in
+	// RUNGITTEST_ARTIFACT_DIR, relative to the output dir.
+	Artifacts []string `json:"artifacts,omitempty"`
+
+	// Signature identifies the failure. Recurrence says whether it
+	// was seen before, and KnownIssue and KnownNote are the link
+	// and note from --known-issues.
+	Signature  string `json:"signature,omitempty"`
+	Recurrence string `json:"recurrence,omitempty"`
+	KnownIssue string `json:"known_issue,omitempty"`
+	KnownNote  string `json:"known_note,omitempty"`
+
+	// Signal is the signal the script or a command it ran died of.
+	Signal string `json:"signal,omitempty"`
+
+	// Faketime is the libfaketime setting the test ran with.
+	Faketime string `json:"faketime,omitempty"`
+
+	// Selections are the --select names the test was chosen by.
+	Selections []string `json:"selections,omitempty"`
+
+	// VerboseLog is the log of the --rerun-verbose-on-failure run.
+	VerboseLog string `json:"verbose_log,omitempty"`
+
+	// Error says why the test did not complete.
+	Error *jsonError `json:"error,omitempty"`
+
+	// Concurrent lists the tests that were running at some point
+	// while this one was.
+	Concurrent []string `json:"concurrent"`
+}
+
+type jsonRun struct {
+	ID      string    `json:"id,omitempty"`
+	Args    []string  `json:"args"`
+	Start   time.Time `json:"start"`
+	Elapsed float64   `json:"elapsed"`
+	Aborted string    `json:"aborted,omitempty"`
+	Notes   []string  `json:"notes,omitempty"`
+	NotRun  int       `json:"not_run"`
+	Partial bool      `json:"partial"`
+	LeftOut []string  `json:"left_out,omitempty"`
+
+	// Appended are the IDs of the runs whose results --append
+	// kept.
+	Appended []string `json:"appended,omitempty"`
+
+	Duplicates []string `json:"duplicates,omitempty"`
+	NewSkips   []string `json:"new_skips,omitempty"`
+
+	// SkipReasons are the totals of the skip reasons of the tests.
+	SkipReasons []jsonSkipReason `json:"skip_reasons,omitempty"`
+
+	Tests []jsonTest `json:"tests"`
+}
+
+func (r *result) json(all []*result) jsonTest {
+	t := jsonTest{
+		Name:       r.name,
+		Status:     r.status,
+		Summary:    r.summary,
+		Log:        r.logFile,
+		Start:      r.start,
+		Duration:   r.duration.Seconds(),
+		CPUUser:    r.cpuUser.Seconds(),
+		CPUSys:     r.cpuSys.Seconds(),
+		Worker:     r.worker,
+		Attempt:    r.attempt,
+		Iteration:  r.iteration,
+		Truncated:  r.truncated,
+		MaxSilence: r.maxSilence.Seconds(),
+		Silent:     r.silent,
+		Env:        r.env,
+		Artifacts:  r.artifacts,
+		Signature:  r.signature,
+		Recurrence: r.recurrence,
+		KnownIssue: r.knownIssue,
+		KnownNote:  r.knownNote,
+		VerboseLog: r.verboseLog,
+		Selections: r.selections,
+		Faketime:   r.faketime,
+		Signal:     r.signal,
+		Concurrent: r.concurrent,
+	}
+	if r.firstOutput >= 0 {
+		first := r.firstOutput.Seconds()
+		t.FirstOutput = &first
+	}
+	if t.Concurrent == nil {
+		t.Concurrent = concurrentWith(r, all)
+	}
+	if r.variant != nil {
+		t.Variant = r.variant.name
+		t.VariantEnv = r.variant.env
+		t.VariantParts = r.variant.parts
+		t.VariantGroup = r.variant.group
+	}
+	if r.maxRSS > 0 {
+		t.MaxRSS = r.maxRSS
+	}
+	if r.err != nil {
+		t.Error = &jsonError{Kind: errorKind(r.err), Message: r.err.Error()}
+	}
+	if io := r.io; io != nil {
+		t.IO = &jsonIO{
+			DiskRead:    io.diskRead,
+			DiskWritten: io.diskWritten,
+			Read:        io.read,
+			Written:     io.written,
+			Syscalls:    io.syscalls,
+			Wait:        io.wait.Seconds(),
+		}
+	}
+	if r.previous != nil {
+		t.PreviousStatus = r.previous.status
+	}
+	if r.tap != nil {
+		t.TAP = &jsonTAP{
+			Planned: r.tap.planned,
+			Passed:  r.tap.passed,
+			Failed:  r.tap.failed,
+			Skipped: r.tap.skipped,
+			Todo:    r.tap.todo,
+			Missing: r.tap.missing,
+		}
+		for _, s := range r.tap.skipReasons {
+			t.TAP.SkipReasons = append(t.TAP.SkipReasons, jsonSkipReason{
+				Kind: s.kind, Key: s.key, Text: s.text, Count: s.count, Script: s.script})
+		}
+		if len(r.tap.satisfied)+len(r.tap.unsatisfied) > 0 {
+			t.Prereqs = &jsonPrereqs{Satisfied: r.tap.satisfied, Unsatisfied: r.tap.unsatisfied}
+		}
+	}
+	if c := r.counts; c != nil {
+		t.GitCounts = &jsonCounts{Total: c.total, Success: c.success, Fixed: c.fixed, Broken: c.broken, Failed: c.failed}
+	}
+	for _, l := range r.leaks {
+		t.Leaks = append(t.Leaks, l.String())
+	}
+	if t.Concurrent == nil {
+		t.Concurrent = []string{}
+	}
+	return t
+}
+
+// result converts back from JSON. variants holds the variants seen so
+// far by name, so results of the same variant share it.
+func (t *jsonTest) result(variants map[string]*variant) *result {
+	r := &result{
+		name:       t.Name,
+		status:     t.Status,
+		summary:    t.Summary,
+		logFile:    t.Log,
+		start:      t.Start,
+		duration:   seconds(t.Duration),
+		cpuUser:    seconds(t.CPUUser),
+		cpuSys:     seconds(t.CPUSys),
+		maxRSS:     -1,
+		worker:     t.Worker,
+		attempt:    t.Attempt,
+		iteration:  t.Iteration,
+		truncated:  t.Truncated,
+		maxSilence: seconds(t.MaxSilence),
+		silent:     t.Silent,
+		env:        t.Env,
+		artifacts:  t.Artifacts,
+		signature:  t.Signature,
+		recurrence: t.Recurrence,
+		knownIssue: t.KnownIssue,
+		knownNote:  t.KnownNote,
+		verboseLog: t.VerboseLog,
+		selections: t.Selections,
+		faketime:   t.Faketime,
+		signal:     t.Signal,
+		concurrent: t.Concurrent,
+	}
+	r.firstOutput = -1
+	if t.FirstOutput != nil {
+		r.firstOutput = seconds(*t.FirstOutput)
+	}
+	if t.MaxRSS > 0 {
+		r.maxRSS = t.MaxRSS
+	}
+	if e := t.Error; e != nil {
+		r.err = &loadedError{kind: e.Kind, msg: e.Message}
+	}
+	if t.Variant != "" {
+		v := variants[t.Variant]
+		if v == nil {
+			v = &variant{name: t.Variant, env: t.VariantEnv, parts: t.VariantParts, group: t.VariantGroup}
+			variants[t.Variant] = v
+		}
+		r.variant = v
+	}
+	if io := t.IO; io != nil {
+		r.io = &ioStats{
+			diskRead:    io.DiskRead,
+			diskWritten: io.DiskWritten,
+			read:        io.Read,
+			written:     io.Written,
+			syscalls:    io.Syscalls,
+			wait:        seconds(io.Wait),
+		}
+	}
+	if t.PreviousStatus != "" {
+		r.previous = &result{name: t.Name, variant: r.variant, status: t.PreviousStatus}
+	}
+	if t.TAP != nil {
+		r.tap = &tapResult{
+			planned: t.TAP.Planned,
+			hasPlan: true,
+			passed:  t.TAP.Passed,
+			failed:  t.TAP.Failed,
+			skipped: t.TAP.Skipped,
+			todo:    t.TAP.Todo,
+			missing: t.TAP.Missing,
+		}
+		for _, s := range t.TAP.SkipReasons {
+			r.tap.skipReasons = append(r.tap.skipReasons, &skipReason{
+				kind: s.Kind, key: s.Key, text: s.Text, count: s.Count, script: s.Script})
+		}
+		if p := t.Prereqs; p != nil {
+			r.tap.satisfied = p.Satisfied
+			r.tap.unsatisfied = p.Unsatisfied
+		}
+	}
+	if c := t.GitCounts; c != nil {
+		r.counts = &gitCounts{total: c.Total, success: c.Success, fixed: c.Fixed, broken: c.Broken, failed: c.Failed}
+	}
+	for _, l := range t.Leaks {
+		// The socket count stays part of the text.
+		r.leaks = append(r.leaks, leak{path: l})
+	}
+	return r
+}
+
+// seconds converts seconds as stored in results.json back to a
+// duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Round(s * float64(time.Second)))
+}
+
+// readResults reads results.json from an output dir.
+func readResults(dir string) (*jsonRun, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "results.json"))
+	if err != nil {
+		return nil, err
+	}
+	var run jsonRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("%s: %v", dir, err)
+	}
+	return &run, nil
+}
+
+// writeResults writes results.json.
+func (rr *runResults) writeResults(path string) error {
+	data, err := rr.resultsJSON()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// resultsJSON renders results.json.
+func (rr *runResults) resultsJSON() ([]byte, error) {
+	args, start := rr.args, rr.start
+	if args == nil {
+		args, start = os.Args, time.Now().Add(-rr.elapsed)
+	}
+	run := jsonRun{
+		ID:      rr.id,
+		Args:    args,
+		Start:   start,
+		Elapsed: rr.elapsed.Seconds(),
+		Aborted: rr.aborted,
+		Notes:   rr.notes,
+		NotRun:  rr.notRun,
+		Partial: rr.partial || len(rr.leftOut) > 0,
+		LeftOut: rr.leftOut,
+
+		Appended:   rr.appended,
+		Duplicates: rr.duplicates,
+		NewSkips:   rr.newSkips,
+
+		SkipReasons: skipTotals(rr.results),
+
+		Tests: []jsonTest{},
+	}
+	for _, r := range rr.results {
+		run.Tests = append(run.Tests, r.json(rr.results))
+	}
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
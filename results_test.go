@@ -0,0 +1,109 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// testResults returns a run with one result of each interesting kind.
+func testResults() *runResults {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Minute) }
+	v := &variant{name: "hash=sha256", env: []string{"GIT_TEST_DEFAULT_HASH=sha256"}, parts: []string{"hash=sha256"}}
+	return &runResults{
+		args:    []string{"rungittest", "--outdir", "out", "t*.sh"},
+		start:   start,
+		elapsed: 90 * time.Second,
+		id:      "20260102-030405-abcd",
+		notes:   []string{"a note"},
+		notRun:  1,
+		leftOut: []string{"t9999-slow.sh"},
+		results: []*result{
+			{
+				name: "t0001-init.sh", status: statusOK, summary: "ok: # passed all 3 test(s)",
+				logFile: "t0001-init.sh.log", start: at(0), duration: 2 * time.Second,
+				maxRSS: 1 << 20, firstOutput: -1,
+				tap: &tapResult{planned: 3, hasPlan: true, passed: 3},
+			},
+			{
+				name: "t0002-crash.sh", status: statusFail, summary: "error: not ok 2 - b",
+				logFile: "t0002-crash.sh.log", start: at(1), duration: time.Second,
+				maxRSS: -1, firstOutput: 10 * time.Millisecond, signal: "segmentation fault",
+				err: &crashError{signal: "segmentation fault", err: &exec.ExitError{}},
+				tap: &tapResult{planned: 2, hasPlan: true, passed: 1, failed: 1},
+			},
+			{
+				name: "t0003-svn.sh", status: statusSkipped, summary: "skipped: missing SVN",
+				logFile: "t0003-svn.sh.log", start: at(2), maxRSS: -1, firstOutput: -1,
+				tap: &tapResult{hasPlan: true, skipAll: "missing SVN", missing: []string{"SVN"}},
+			},
+			{
+				name: "t0004-slow.sh", variant: v, status: statusTimeout, summary: "timeout: timed out after 1m0s",
+				logFile: "hash=sha256/t0004-slow.sh.log", start: at(3), duration: time.Minute,
+				maxRSS: -1, firstOutput: -1,
+				err: &timeoutError{limit: time.Minute},
+			},
+			{
+				name: "t0005-log.sh", status: statusFail, summary: "create error",
+				logFile: "t0005-log.sh.log", start: at(4), maxRSS: -1, firstOutput: -1,
+				err: &setupError{stage: "create", err: errors.New("disk full")},
+			},
+		},
+	}
+}
+
+var writtenRE = regexp.MustCompile(`(?m)^# written: .*$`)
+
+func TestResultsRoundTrip(t *testing.T) {
+	rr := testResults()
+	want, err := rr.resultsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "results.json"), want, 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadRun(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := loaded.resultsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("results.json changed in the round trip:\n%s\nwant:\n%s", got, want)
+	}
+	if g, w := writtenRE.ReplaceAllString(loaded.summaryText(), ""), writtenRE.ReplaceAllString(rr.summaryText(), ""); g != w {
+		t.Errorf("summary changed in the round trip:\n%s\nwant:\n%s", g, w)
+	}
+
+	for i, kind := range []string{"", "crash", "", "timeout", "setup"} {
+		if k := errorKind(loaded.results[i].err); k != kind {
+			t.Errorf("%s: error kind %q after loading, want %q", loaded.results[i].name, k, kind)
+		}
+	}
+	if v := loaded.results[3].variant; v == nil || v.name != "hash=sha256" {
+		t.Errorf("variant lost: %+v", v)
+	}
+}
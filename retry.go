@@ -0,0 +1,88 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// retryClasses are the kinds of failure --retry-on accepts.
+var retryClasses = []string{"signal", "timeout", "oom", "hung"}
+
+// parseRetryOn parses the --retry-on list.
+func parseRetryOn(s string) (map[string]bool, error) {
+	m := map[string]bool{}
+	for _, c := range splitList(s) {
+		ok := false
+		for _, k := range retryClasses {
+			ok = ok || c == k
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown failure class %q, want %s", c, strings.Join(retryClasses, ", "))
+		}
+		m[c] = true
+	}
+	return m, nil
+}
+
+// failureClass returns the class of a failure for --retry-on, or ""
+// for failures such as a failed assertion that are not worth a retry.
+func (r *result) failureClass() string {
+	switch {
+	case r.status == statusTimeout:
+		return "timeout"
+	case r.status == statusOOM:
+		return "oom"
+	case r.status == statusHung:
+		return "hung"
+	case r.failed() && r.signal != "":
+		return "signal"
+	}
+	return ""
+}
+
+// crashRE matches what test_must_fail and the shell print when a
+// command dies of a signal.
+var crashRE = regexp.MustCompile(`died by signal (\d+)|\b(Bus error|Segmentation fault|Illegal instruction|Floating point exception|Aborted)\b`)
+
+// crashSignal returns the signal that killed the script or one of the
+// commands it ran, or "" if there was none.
+func crashSignal(ps *os.ProcessState, stdout, stderr []byte) string {
+	if ps != nil {
+		if ws, ok := ps.Sys().(syscall.WaitStatus); ok {
+			if ws.Signaled() {
+				return ws.Signal().String()
+			}
+			// The shell exits with 128+N if its last command
+			// died of signal N.
+			if n := ws.ExitStatus(); n > 128 && n < 128+65 {
+				return syscall.Signal(n - 128).String()
+			}
+		}
+	}
+	for _, out := range [][]byte{stderr, stdout} {
+		if m := crashRE.FindSubmatch(out); m != nil {
+			if len(m[1]) > 0 {
+				return "signal " + string(m[1])
+			}
+			return strings.ToLower(string(m[2]))
+		}
+	}
+	return ""
+}
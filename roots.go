@@ -0,0 +1,240 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// rootDefault stands for the root GIT_TEST_OPTS gives, and
+	// rootTmpfs for a --tmpfs-root.
+	rootDefault = "default"
+	rootTmpfs   = "tmpfs"
+
+	defaultTmpfsSize = 4 << 30
+)
+
+// parseCompareRoots parses the --compare-roots value: two of
+// "default", "tmpfs" or a directory.
+func parseCompareRoots(v string) ([]string, error) {
+	roots := splitList(v)
+	if len(roots) != 2 {
+		return nil, fmt.Errorf("want two roots, eg. default,tmpfs, got %q", v)
+	}
+	for i, r := range roots {
+		if r == rootDefault || r == rootTmpfs {
+			continue
+		}
+		abs, err := filepath.Abs(r)
+		if err != nil {
+			return nil, err
+		}
+		roots[i] = abs
+	}
+	return roots, nil
+}
+
+// compareRoots runs the tests once on each root, in child processes
+// writing to outdir/root1 and outdir/root2, and reports how long each
+// test took on both.
+func compareRoots(fs *flag.FlagSet, scripts []string, outdir string, roots []string, tmpfsSize int64) int {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, append([]os.Signal{os.Interrupt}, forwardSignals...)...)
+	defer signal.Stop(sigs)
+
+	self, err := os.Executable()
+	if err != nil {
+		fatalf("--compare-roots: %v", err)
+	}
+	if tmpfsSize <= 0 {
+		tmpfsSize = defaultTmpfsSize
+	}
+	var runs []*jsonRun
+	for i, root := range roots {
+		dir := filepath.Join(outdir, fmt.Sprintf("root%d", i+1))
+		// The experiment should not skew the history. The
+		// children read .rungittest.toml and RUNGITTEST_* too,
+		// which must not make them compare roots again.
+		args := append(childArgs(fs, dir, "compare-roots", "tmpfs-root"),
+			"--history=", "--compare-roots=", "--tmpfs-root=0")
+		var env []string
+		for _, e := range os.Environ() {
+			if !strings.HasPrefix(e, "RUNGITTEST_COMPARE_ROOTS=") && !strings.HasPrefix(e, "RUNGITTEST_TMPFS_ROOT=") {
+				env = append(env, e)
+			}
+		}
+		switch root {
+		case rootDefault:
+		case rootTmpfs:
+			args = append(args, "--tmpfs-root="+formatSize(tmpfsSize))
+		default:
+			if err := os.MkdirAll(root, 0755); err != nil {
+				fatalf("--compare-roots: %v", err)
+			}
+			// test-lib.sh uses the last --root it is given.
+			env = append(env, "GIT_TEST_OPTS="+strings.TrimSpace(os.Getenv("GIT_TEST_OPTS")+" --root="+root))
+		}
+		fmt.Printf("--compare-roots: run %d on %s\n", i+1, describeRoot(root))
+		cmd := exec.Command(self, append(args, scripts...)...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.Env = env
+		if err := cmd.Start(); err != nil {
+			fatalf("--compare-roots: %v", err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		interrupted := false
+	wait:
+		for {
+			select {
+			case sig := <-sigs:
+				// The child gets ^C from the terminal
+				// itself.
+				if sig != os.Interrupt {
+					cmd.Process.Signal(sig)
+				}
+				interrupted = true
+			case err = <-done:
+				break wait
+			}
+		}
+		if err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				fatalf("--compare-roots: %v", err)
+			}
+		}
+		if interrupted {
+			return exitCancelled
+		}
+		run, err := readResults(dir)
+		if err != nil {
+			fatalf("--compare-roots: %v", err)
+		}
+		runs = append(runs, run)
+	}
+
+	report := rootsReport(roots, runs[0], runs[1])
+	fmt.Print(report)
+	path := filepath.Join(outdir, "roots.txt")
+	if err := ioutil.WriteFile(path, []byte(report), 0644); err != nil {
+		log.Printf("--compare-roots: %v", err)
+	}
+	fmt.Printf("Output to %s\n", path)
+	return exitOK
+}
+
+func describeRoot(root string) string {
+	switch root {
+	case rootDefault:
+		root, _ := filepath.Abs(testRoot())
+		return "the default root " + root
+	case rootTmpfs:
+		return "a tmpfs"
+	}
+	return root
+}
+
+// testTimes adds up the durations of the tests that passed or
+// skipped, by label, and returns the statuses of the others.
+func testTimes(run *jsonRun) (map[string]time.Duration, map[string]string) {
+	times := map[string]time.Duration{}
+	failed := map[string]string{}
+	variants := map[string]*variant{}
+	for i := range run.Tests {
+		r := run.Tests[i].result(variants)
+		if r.failed() || r.status == statusCancelled {
+			failed[r.label()] = r.status
+			continue
+		}
+		times[r.label()] += r.duration
+	}
+	for l := range failed {
+		delete(times, l)
+	}
+	return times, failed
+}
+
+// rootsReport compares the test durations of runs on two roots, the
+// tests that gain most from the second root first.
+func rootsReport(roots []string, a, b *jsonRun) string {
+	ta, fa := testTimes(a)
+	tb, fb := testTimes(b)
+	var labels []string
+	var totalA, totalB time.Duration
+	for l, d := range ta {
+		if e, ok := tb[l]; ok {
+			labels = append(labels, l)
+			totalA += d
+			totalB += e
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		si, sj := ta[labels[i]]-tb[labels[i]], ta[labels[j]]-tb[labels[j]]
+		if si != sj {
+			return si > sj
+		}
+		return labels[i] < labels[j]
+	})
+	speedup := func(a, b time.Duration) string {
+		if b <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2fx", float64(a)/float64(b))
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "# root1: %s\n# root2: %s\n", describeRoot(roots[0]), describeRoot(roots[1]))
+	fmt.Fprintf(&buf, "%-30s %10s %10s %8s\n", "test", "root1", "root2", "speedup")
+	for _, l := range labels {
+		fmt.Fprintf(&buf, "%-30s %10s %10s %8s\n", l,
+			ta[l].Round(time.Millisecond), tb[l].Round(time.Millisecond), speedup(ta[l], tb[l]))
+	}
+	fmt.Fprintf(&buf, "%-30s %10s %10s %8s\n", "total test time",
+		totalA.Round(time.Millisecond), totalB.Round(time.Millisecond), speedup(totalA, totalB))
+	ea, eb := seconds(a.Elapsed), seconds(b.Elapsed)
+	fmt.Fprintf(&buf, "%-30s %10s %10s %8s\n", "elapsed",
+		ea.Round(time.Millisecond), eb.Round(time.Millisecond), speedup(ea, eb))
+
+	var differ []string
+	for l, s := range fa {
+		if fb[l] == "" {
+			differ = append(differ, fmt.Sprintf("%-20s - %s on root1 only", l, s))
+		}
+	}
+	for l, s := range fb {
+		if fa[l] == "" {
+			differ = append(differ, fmt.Sprintf("%-20s - %s on root2 only", l, s))
+		}
+	}
+	sort.Strings(differ)
+	if len(differ) > 0 {
+		fmt.Fprintf(&buf, "\n# failing on one root only %d:\n%s\n", len(differ), strings.Join(differ, "\n"))
+	}
+	if n := len(fa) + len(fb) - len(differ); n > 0 {
+		fmt.Fprintf(&buf, "\n(%d failures on both roots are left out)\n", n/2)
+	}
+	return buf.String()
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// runAsUser is the unprivileged user --run-as runs the tests as.
+type runAsUser struct {
+	name, home string
+	uid, gid   int
+	groups     []int
+}
+
+// lookupRunAs looks up the user to run the tests as, by name or uid.
+func lookupRunAs(name string) (*runAsUser, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errNotSupported
+	}
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("only root can run tests as another user")
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, fmt.Errorf("no user %q", name)
+		}
+	}
+	r := &runAsUser{name: u.Username, home: u.HomeDir}
+	if r.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, err
+	}
+	if r.gid, err = strconv.Atoi(u.Gid); err != nil {
+		return nil, err
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range gids {
+		if n, err := strconv.Atoi(g); err == nil {
+			r.groups = append(r.groups, n)
+		}
+	}
+	return r, nil
+}
+
+// env returns the variables describing the user, which would
+// otherwise still describe root.
+func (u *runAsUser) env() []string {
+	return []string{"USER=" + u.name, "LOGNAME=" + u.name, "HOME=" + u.home}
+}
+
+// give hands the files we created for a test over to the user, so it
+// can write there. It does nothing if u is nil.
+func (u *runAsUser) give(paths ...string) error {
+	if u == nil {
+		return nil
+	}
+	for _, p := range paths {
+		err := filepath.Walk(p, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(p, u.uid, u.gid)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// maxRSS returns the peak resident set size of the process and the
+// children it waited for, in bytes. Linux and the BSDs report
+// kilobytes; macOS reports bytes.
+func maxRSS(ps *os.ProcessState) int64 {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return -1
+	}
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		return int64(ru.Maxrss)
+	}
+	return int64(ru.Maxrss) << 10
+}
+
+// blockIO returns the bytes the process and the children it waited
+// for read from and wrote to disk, or -1 where the units of the counts
+// are unknown. Linux counts 512 byte blocks.
+func blockIO(ps *os.ProcessState) (read, written int64) {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok || runtime.GOOS != "linux" {
+		return -1, -1
+	}
+	return int64(ru.Inblock) << 9, int64(ru.Oublock) << 9
+}
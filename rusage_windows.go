@@ -0,0 +1,27 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "os"
+
+// maxRSS is not available on Windows: the process handle is closed
+// by the time we get the exit status.
+func maxRSS(ps *os.ProcessState) int64 {
+	return -1
+}
+
+func blockIO(ps *os.ProcessState) (read, written int64) {
+	return -1, -1
+}
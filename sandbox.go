@@ -0,0 +1,112 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sandboxEnv carries the --sandbox settings to the rungittest process
+// that sets up the sandbox and then executes the test. Like tmpfsEnv,
+// it stays out of the RUNGITTEST_<FLAG> defaults.
+const sandboxEnv = "_RUNGITTEST_SANDBOX_SPEC"
+
+// sandbox confines tests to writing below some directories, and to
+// the loopback network.
+type sandbox struct {
+	// Writable are the directories and files tests may write.
+	Writable []string `json:"writable"`
+
+	self string
+}
+
+func newSandbox(writable []string) (*sandbox, error) {
+	if err := checkSandbox(); err != nil {
+		return nil, err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	s := &sandbox{self: self}
+	for _, w := range writable {
+		abs, err := filepath.Abs(w)
+		if err != nil {
+			return nil, err
+		}
+		s.Writable = append(s.Writable, abs)
+	}
+	return s, nil
+}
+
+// wrap makes cmd set up the sandbox before running.
+func (s *sandbox) wrap(cmd *exec.Cmd) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(append([]string{}, env...), sandboxEnv+"="+string(data))
+	cmd.Args = append([]string{s.self}, cmd.Args...)
+	cmd.Path = s.self
+	setSandboxAttr(cmd)
+	return nil
+}
+
+// probe runs a command in the sandbox, to fail early if it cannot be
+// set up.
+func (s *sandbox) probe(shell string) error {
+	cmd := exec.Command(shell, "-c", ":")
+	if err := s.wrap(cmd); err != nil {
+		return err
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// sandboxMain runs in the child that os/exec started: it enters the
+// sandbox given in the environment and executes the command in its
+// arguments.
+func sandboxMain(spec string) {
+	var s sandbox
+	err := json.Unmarshal([]byte(spec), &s)
+	if err == nil {
+		err = s.enter(os.Args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "rungittest --sandbox: %v\n", err)
+	os.Exit(126)
+}
+
+// sandboxExecEnv returns the environment for the test, without our
+// settings.
+func sandboxExecEnv() []string {
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, sandboxEnv+"=") {
+			env = append(env, e)
+		}
+	}
+	return env
+}
@@ -0,0 +1,230 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+
+	prSetNoNewPrivs = 38
+
+	// oPath is O_PATH, which package syscall lacks, as on all but
+	// a few exotic architectures.
+	oPath = 0x200000
+
+	capNetAdmin = 12
+
+	siocgifflags = 0x8913
+	siocsifflags = 0x8914
+)
+
+// Landlock file system access rights.
+const (
+	llExecute = 1 << iota
+	llWriteFile
+	llReadFile
+	llReadDir
+	llRemoveDir
+	llRemoveFile
+	llMakeChar
+	llMakeDir
+	llMakeReg
+	llMakeSock
+	llMakeFifo
+	llMakeBlock
+	llMakeSym
+	llRefer    // ABI 2
+	llTruncate // ABI 3
+	llIoctlDev // ABI 5
+
+	llFileRights = llExecute | llWriteFile | llReadFile | llTruncate | llIoctlDev
+	llReadRights = llExecute | llReadFile | llReadDir
+)
+
+// sandboxDevices are writable in the sandbox besides the Writable
+// directories.
+var sandboxDevices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/tty", "/dev/ptmx", "/dev/pts"}
+
+// landlockABI returns the Landlock version of the kernel.
+func landlockABI() (int, error) {
+	v, _, e := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if e != 0 {
+		return 0, fmt.Errorf("Landlock is not available: %v", e)
+	}
+	return int(v), nil
+}
+
+// handledAccess returns the access rights the given Landlock version
+// knows about.
+func handledAccess(abi int) uint64 {
+	access := uint64(llRefer - 1)
+	if abi >= 2 {
+		access |= llRefer
+	}
+	if abi >= 3 {
+		access |= llTruncate
+	}
+	if abi >= 5 {
+		access |= llIoctlDev
+	}
+	return access
+}
+
+func checkSandbox() error {
+	_, err := landlockABI()
+	return err
+}
+
+// setSandboxAttr runs the command in a network namespace of its own,
+// and so without network access besides loopback. Users other than
+// root also get a user namespace in which they keep their uid. The
+// child needs CAP_NET_ADMIN for bringing up the loopback device; it
+// drops it before executing the test.
+func setSandboxAttr(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	a := cmd.SysProcAttr
+	a.Cloneflags |= syscall.CLONE_NEWNET
+	if os.Geteuid() != 0 {
+		a.Cloneflags |= syscall.CLONE_NEWUSER
+		a.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Geteuid(), HostID: os.Geteuid(), Size: 1}}
+		a.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getegid(), HostID: os.Getegid(), Size: 1}}
+	}
+	a.AmbientCaps = append(a.AmbientCaps, capNetAdmin)
+}
+
+// loopbackUp brings up the loopback device, which starts out down in a
+// new network namespace.
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	// struct ifreq: the name, then the flags as a short.
+	var ifr [40]byte
+	copy(ifr[:], "lo")
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocgifflags, uintptr(unsafe.Pointer(&ifr[0]))); e != 0 {
+		return e
+	}
+	*(*uint16)(unsafe.Pointer(&ifr[16])) |= syscall.IFF_UP
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocsifflags, uintptr(unsafe.Pointer(&ifr[0]))); e != 0 {
+		return e
+	}
+	return nil
+}
+
+// landlockAllow lets the ruleset grant access below path. Missing
+// paths are skipped.
+func landlockAllow(ruleset int, path string, access uint64) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if !fi.IsDir() {
+		access &= llFileRights
+	}
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	// struct landlock_path_beneath_attr is packed; its 12 bytes are
+	// the start of this struct.
+	attr := struct {
+		allowedAccess uint64
+		parentFd      int32
+	}{access, int32(fd)}
+	if _, _, e := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath,
+		uintptr(unsafe.Pointer(&attr)), 0, 0, 0); e != 0 {
+		return fmt.Errorf("landlock rule for %s: %v", path, e)
+	}
+	return nil
+}
+
+// restrict confines the calling thread, and the programs it executes,
+// to reading anywhere but writing only below the Writable paths.
+func (s *sandbox) restrict() error {
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+	handled := handledAccess(abi)
+	attr := struct{ handledAccessFS uint64 }{handled}
+	fd, _, e := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if e != 0 {
+		return fmt.Errorf("landlock ruleset: %v", e)
+	}
+	ruleset := int(fd)
+	defer syscall.Close(ruleset)
+
+	if err := landlockAllow(ruleset, "/", handled&llReadRights); err != nil {
+		return err
+	}
+	for _, d := range sandboxDevices {
+		if err := landlockAllow(ruleset, d, handled&(llReadFile|llWriteFile|llReadDir|llTruncate|llIoctlDev)); err != nil {
+			return err
+		}
+	}
+	for _, w := range s.Writable {
+		if err := landlockAllow(ruleset, w, handled); err != nil {
+			return err
+		}
+	}
+	if _, _, e := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); e != 0 {
+		return fmt.Errorf("no_new_privs: %v", e)
+	}
+	if _, _, e := syscall.Syscall(sysLandlockRestrictSelf, uintptr(ruleset), 0, 0); e != 0 {
+		return fmt.Errorf("landlock: %v", e)
+	}
+	return nil
+}
+
+// enter sets up the sandbox and executes argv in it. Landlock and
+// capabilities apply per thread, so this thread must do the exec.
+func (s *sandbox) enter(argv []string) error {
+	if len(argv) == 0 {
+		return fmt.Errorf("no command")
+	}
+	runtime.LockOSThread()
+	if err := loopbackUp(); err != nil {
+		return fmt.Errorf("bringing up lo: %v", err)
+	}
+	if err := s.restrict(); err != nil {
+		return err
+	}
+	if _, _, e := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); e != 0 {
+		return e
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, argv, sandboxExecEnv())
+}
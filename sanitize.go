@@ -0,0 +1,77 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ansiRE matches ANSI escape sequences: CSI (eg. colors), OSC (eg.
+// window titles) and two character escapes.
+var ansiRE = regexp.MustCompile("\x1b(\\[[0-?]*[ -/]*[@-~]|\\][^\x07\x1b]*(\x07|\x1b\\\\)|[@-Z\\\\-_])")
+
+// isControl returns true for control characters other than tab and
+// newline.
+func isControl(c byte) bool {
+	return (c < 0x20 && c != '\t' && c != '\n') || c == 0x7f
+}
+
+// cleanOutput handles control characters in test output according to
+// mode: "strip" removes ANSI sequences and other control characters,
+// "escape" writes them as \xNN and "keep" leaves the output alone.
+// CRLF line endings become LF unless the output is kept.
+func cleanOutput(b []byte, mode string) []byte {
+	switch mode {
+	case "strip":
+		b = ansiRE.ReplaceAll(b, nil)
+	case "escape":
+	default:
+		return b
+	}
+	i := 0
+	for i < len(b) && !isControl(b[i]) {
+		i++
+	}
+	if i == len(b) {
+		return b
+	}
+	out := append(make([]byte, 0, len(b)+16), b[:i]...)
+	for k, c := range b[i:] {
+		switch {
+		case !isControl(c):
+			out = append(out, c)
+		case c == '\r' && i+k+1 < len(b) && b[i+k+1] == '\n':
+			// Drop CRLF line endings.
+		case mode == "escape":
+			out = append(out, fmt.Sprintf(`\x%02x`, c)...)
+		}
+	}
+	return out
+}
+
+// summaryMode is how control characters are handled in summaries and
+// the progress line, which must not contain them.
+func summaryMode(mode string) string {
+	if mode == "strip" {
+		return mode
+	}
+	return "escape"
+}
+
+// cleanText is cleanOutput for strings.
+func cleanText(s, mode string) string {
+	return string(cleanOutput([]byte(s), mode))
+}
@@ -0,0 +1,759 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errCancelled = errors.New("cancelled")
+
+// job is a single test script being run.
+type job struct {
+	name    string
+	variant *variant
+	slot    int
+
+	// attempt counts retries; the first run is attempt 0.
+	attempt int
+
+	// iteration numbers the runs in benchmark mode, starting at 1.
+	iteration int
+
+	mu        sync.Mutex
+	tree      *procTree
+	started   time.Time
+	cancelled bool
+	timedOut  bool
+	hung      bool
+
+	// isolated jobs run in a fresh test root.
+	isolated bool
+
+	// verbose jobs rerun a failure with -v -x, only for the log.
+	verbose bool
+}
+
+// start starts the command, unless the job or the run was cancelled
+// already.
+func (j *job) start(ctx context.Context, cmd *exec.Cmd) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if ctx.Err() != nil {
+		j.cancelled = true
+	}
+	if j.cancelled {
+		return errCancelled
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	j.tree = newProcTree(cmd.Process)
+	j.started = time.Now()
+	return nil
+}
+
+// release frees resources after the command has exited.
+func (j *job) release() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.tree != nil {
+		j.tree.release()
+		j.tree = nil
+	}
+}
+
+// cancel stops the test and all its children.
+func (j *job) cancel(grace time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cancelled = true
+	j.interrupt(grace)
+}
+
+// watch cancels the job once ctx is done. The returned function ends
+// the watch, and must be called when the test has exited.
+func (j *job) watch(ctx context.Context, grace time.Duration) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			j.cancel(grace)
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// interrupt asks the test to terminate, so its traps can clean up
+// daemons and trash directories, and kills it if it is still running
+// after the grace period. It must be called with j.mu held.
+func (j *job) interrupt(grace time.Duration) {
+	t := j.tree
+	if t == nil {
+		return
+	}
+	if grace <= 0 || t.terminate() != nil {
+		t.kill()
+		return
+	}
+	time.AfterFunc(grace, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if j.tree == t {
+			t.kill()
+		}
+	})
+}
+
+// label names the job for humans, eg. "t0001-init.sh [LANG=C]".
+func (j *job) label() string {
+	return label(j.name, j.variant)
+}
+
+// expire stops the test because it ran out of time.
+func (j *job) expire(grace time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.timedOut = true
+	j.interrupt(grace)
+}
+
+// hang stops the test because it stopped producing output.
+func (j *job) hang(grace time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.hung = true
+	j.interrupt(grace)
+}
+
+func (j *job) isHung() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.hung
+}
+
+func (j *job) isTimedOut() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.timedOut
+}
+
+func (j *job) isCancelled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.cancelled
+}
+
+// scheduler dispatches tests to a pool of worker slots, whose size
+// can change while running.
+type scheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    int
+	queue   []*job
+	running map[int]*job
+	results []*result
+	stopped bool
+	paused  bool
+
+	// dropped are queued jobs cancelled by cancelTest. run()
+	// records them as cancelled without starting them.
+	dropped []*job
+
+	// cancelRun cancels the context of the current run() call.
+	cancelRun context.CancelFunc
+
+	// grace is how long cancelled tests get to clean up before
+	// they are killed.
+	grace time.Duration
+
+	// throttled is why dispatching is held back for lack of
+	// resources.
+	throttled string
+
+	// aborted is the reason the run was aborted, and abortStatus
+	// the exit status it calls for.
+	aborted     string
+	abortStatus int
+
+	// notes are extra lines for the summary header.
+	notes []string
+
+	// id is the ID of the run.
+	id string
+
+	// leftOut are the tests that were not queued to meet the
+	// deadline.
+	leftOut []string
+
+	// rerunLimit, if positive, caps the worker slots while reruns
+	// queued by requeue or rerunVerbose are pending, without
+	// touching jobs, which "ctl jobs" may change meanwhile.
+	rerunLimit int
+
+	// deadlineReached is set once the --deadline stopped the run.
+	deadlineReached bool
+
+	// record, if set, writes the dispatch order.
+	record *scheduleRecorder
+
+	// replay, if set, dispatches in a recorded order.
+	replay *replayState
+
+	// constraints restrict which tests may run together.
+	constraints []constraint
+
+	// weights give the number of slots tests take.
+	weights []weight
+}
+
+// newJobs returns a job for each test.
+func newJobs(names []string) []*job {
+	var jobs []*job
+	for _, n := range names {
+		jobs = append(jobs, &job{name: n})
+	}
+	return jobs
+}
+
+func newScheduler(jobs int, queue []*job) *scheduler {
+	s := &scheduler{
+		jobs:    jobs,
+		queue:   queue,
+		running: map[int]*job{},
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// next blocks until a slot is free, and returns the next job to run,
+// or nil if there is nothing left to do.
+func (s *scheduler) next() *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var i int
+	for {
+		if s.stopped {
+			return nil
+		}
+		if len(s.dropped) > 0 {
+			j := s.dropped[0]
+			s.dropped = s.dropped[1:]
+			return j
+		}
+		if len(s.queue) == 0 {
+			return nil
+		}
+		if !s.blocked() {
+			if i = s.pick(); i >= 0 {
+				break
+			}
+		}
+		s.cond.Wait()
+	}
+	j := s.queue[i]
+	s.queue = append(s.queue[:i:i], s.queue[i+1:]...)
+	if s.replay != nil {
+		if slot := s.replay.dispatched(j); slot >= 0 && slot < s.capacity() && s.running[slot] == nil {
+			j.slot = slot
+		}
+	}
+	for s.running[j.slot] != nil {
+		j.slot++
+	}
+	s.running[j.slot] = j
+	if s.record != nil {
+		if err := s.record.dispatched(j); err != nil {
+			log.Printf("--record-schedule: %v", err)
+			s.record = nil
+		}
+	}
+	return j
+}
+
+// pick returns the index in the queue of the job to start next, or -1
+// if none may start yet.
+func (s *scheduler) pick() int {
+	i := -1
+	if s.replay != nil {
+		i = s.replay.pick(s.queue)
+		if i >= 0 && (!s.fits(s.queue[i]) || !allowed(s.constraints, s.queue[i], s.queue, s.running)) {
+			i = -1
+		}
+	} else {
+		for k, j := range s.queue {
+			if s.fits(j) && allowed(s.constraints, j, s.queue, s.running) {
+				i = k
+				break
+			}
+		}
+	}
+	if i < 0 && len(s.running) == 0 {
+		// Nothing will finish to unblock us, eg. because of a
+		// cycle of "before" constraints.
+		log.Printf("constraints cannot be met for %s, starting it anyway", s.queue[0].label())
+		return 0
+	}
+	return i
+}
+
+// capacity returns the number of worker slots tests may use now.
+func (s *scheduler) capacity() int {
+	if s.rerunLimit > 0 && s.rerunLimit < s.jobs {
+		return s.rerunLimit
+	}
+	return s.jobs
+}
+
+// slots returns the number of worker slots j takes. A test weighing
+// more than there are slots takes all of them.
+func (s *scheduler) slots(j *job) int {
+	n := slotsFor(s.weights, j)
+	if c := s.capacity(); n > c {
+		n = c
+	}
+	return n
+}
+
+// load returns the number of worker slots taken by running tests.
+func (s *scheduler) load() int {
+	n := 0
+	for _, j := range s.running {
+		n += s.slots(j)
+	}
+	return n
+}
+
+// fits returns true if the free worker slots suffice for j.
+func (s *scheduler) fits(j *job) bool {
+	return s.load()+s.slots(j) <= s.capacity()
+}
+
+// blocked returns true if no test may be started now.
+func (s *scheduler) blocked() bool {
+	if s.paused || s.load() >= s.capacity() {
+		return true
+	}
+	// Always allow one test, so the run makes progress.
+	return s.throttled != "" && len(s.running) > 0
+}
+
+// othersRunning returns true if another job runs the same script as
+// j, and so shares its trash directory.
+func (s *scheduler) othersRunning(j *job) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.running {
+		if o != j && o.name == j.name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *scheduler) finish(j *job, r *result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[j.slot] == j {
+		delete(s.running, j.slot)
+		if s.record != nil {
+			s.record.finished(j)
+		}
+		if s.replay != nil {
+			s.replay.finished(j)
+		}
+	}
+	s.cond.Broadcast()
+	if j.verbose {
+		// The result the job was queued for may have been
+		// replaced by an isolated rerun since.
+		for _, old := range s.results {
+			if old.name == r.name && old.variant == r.variant && old.iteration == r.iteration {
+				old.verboseLog = r.logFile
+			}
+		}
+		return
+	}
+	if j.attempt > 0 {
+		for i, old := range s.results {
+			if old.name == r.name && old.variant == r.variant &&
+				old.iteration == r.iteration && old.attempt == j.attempt-1 {
+				r.previous = old
+				s.results[i] = r
+				return
+			}
+		}
+	}
+	s.results = append(s.results, r)
+}
+
+// requeue schedules another attempt for the given results, optionally
+// in a fresh test root. If limit is positive, at most that many slots
+// are used until the next run() is done. It does nothing if the run
+// was stopped.
+func (s *scheduler) requeue(rs []*result, limit int, isolated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	for _, r := range rs {
+		s.queue = append(s.queue, &job{
+			name:      r.name,
+			variant:   r.variant,
+			attempt:   r.attempt + 1,
+			iteration: r.iteration,
+			isolated:  isolated,
+		})
+	}
+	s.rerunLimit = limit
+	s.cond.Broadcast()
+}
+
+// rerunVerbose schedules a run with -v -x of the given results, one
+// at a time. The results of these runs only add their log to the
+// original results.
+func (s *scheduler) rerunVerbose(rs []*result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	for _, r := range rs {
+		s.queue = append(s.queue, &job{
+			name:      r.name,
+			variant:   r.variant,
+			attempt:   r.attempt,
+			iteration: r.iteration,
+			verbose:   true,
+		})
+	}
+	s.rerunLimit = 1
+	s.cond.Broadcast()
+}
+
+// run runs fn for all tests, and sends the results on the given
+// channel, which is closed once all tests have finished. The context
+// passed to fn is done once the run is stopped; cancelling ctx aborts
+// the run.
+func (s *scheduler) run(ctx context.Context, fn func(context.Context, *job) *result, results chan<- *result) {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	s.cancelRun = cancel
+	if s.stopped {
+		cancel()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				s.reachDeadline()
+			} else {
+				s.abort(exitCancelled, "cancelled")
+			}
+		case <-done:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		j := s.next()
+		if j == nil {
+			break
+		}
+		if j.slot < 0 {
+			r := droppedResult(j)
+			s.finish(j, r)
+			results <- r
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := fn(runCtx, j)
+			s.finish(j, r)
+			results <- r
+		}()
+	}
+	wg.Wait()
+	s.mu.Lock()
+	s.rerunLimit = 0
+	s.mu.Unlock()
+	close(results)
+}
+
+// setJobs changes the number of worker slots. If it shrinks, running
+// tests are allowed to finish.
+func (s *scheduler) setJobs(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = n
+	s.cond.Broadcast()
+}
+
+// addJobs changes the number of worker slots by delta, and returns
+// the new number.
+func (s *scheduler) addJobs(delta int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs += delta
+	if s.jobs < 1 {
+		s.jobs = 1
+	}
+	s.cond.Broadcast()
+	return s.jobs
+}
+
+// droppedResult is the result of a job cancelled while it was queued.
+func droppedResult(j *job) *result {
+	return &result{
+		name:      j.name,
+		variant:   j.variant,
+		attempt:   j.attempt,
+		iteration: j.iteration,
+		worker:    -1,
+		status:    statusCancelled,
+		summary:   statusCancelled + ": cancelled while queued",
+		start:     time.Now(),
+		maxRSS:    -1,
+
+		firstOutput: -1,
+	}
+}
+
+// cancelTest kills the running tests with the given name or label,
+// and takes them off the queue, to be reported as cancelled. It
+// returns false if the test was not found.
+func (s *scheduler) cancelTest(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for _, j := range s.running {
+		if j.name == name || j.label() == name {
+			go j.cancel(s.grace)
+			found = true
+		}
+	}
+	var queue []*job
+	for _, q := range s.queue {
+		if q.name == name || q.label() == name {
+			q.cancelled = true
+			q.slot = -1
+			s.dropped = append(s.dropped, q)
+			found = true
+			continue
+		}
+		queue = append(queue, q)
+	}
+	s.queue = queue
+	s.cond.Broadcast()
+	return found
+}
+
+// setPaused stops or resumes dispatching new tests. Running tests are
+// not affected.
+func (s *scheduler) setPaused(p bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = p
+	s.cond.Broadcast()
+}
+
+func (s *scheduler) setThrottled(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttled = reason
+	s.cond.Broadcast()
+}
+
+// stop stops dispatching tests, and cancels the context of the
+// running ones.
+func (s *scheduler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	if s.cancelRun != nil {
+		s.cancelRun()
+	}
+	s.cond.Broadcast()
+}
+
+// kill stops the run, killing running tests without a grace period.
+func (s *scheduler) kill() {
+	s.mu.Lock()
+	s.grace = 0
+	for _, j := range s.running {
+		go j.cancel(0)
+	}
+	s.mu.Unlock()
+	s.stop()
+}
+
+// abort stops the run for the given reason, which should end the
+// program with the given exit status.
+func (s *scheduler) abort(status int, reason string) {
+	s.mu.Lock()
+	if s.aborted == "" {
+		s.aborted = reason
+		s.abortStatus = status
+	}
+	s.mu.Unlock()
+	s.stop()
+}
+
+// reachDeadline stops the run because the --deadline passed, which
+// leaves the results partial.
+func (s *scheduler) reachDeadline() {
+	s.mu.Lock()
+	if !s.deadlineReached {
+		s.deadlineReached = true
+		s.notes = append(s.notes, "partial run: --deadline reached")
+	}
+	s.mu.Unlock()
+	s.abort(exitTimeout, "deadline reached")
+}
+
+// exitStatus returns the exit status called for by how the run was
+// stopped, or exitOK if it ran to completion.
+func (s *scheduler) exitStatus() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aborted != "" {
+		return s.abortStatus
+	}
+	if s.stopped {
+		return exitCancelled
+	}
+	return exitOK
+}
+
+// finalStatus returns the exit status of a finished run with the
+// given number of failures.
+func (s *scheduler) finalStatus(failures int) int {
+	status := s.exitStatus()
+	if status == exitOK && failures > 0 {
+		status = exitFailures
+	}
+	return status
+}
+
+// snapshot returns the results so far.
+func (s *scheduler) snapshot(elapsed time.Duration) *runResults {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &runResults{
+		results: append([]*result{}, s.results...),
+		elapsed: elapsed,
+		notRun:  len(s.queue) + len(s.dropped),
+		aborted: s.aborted,
+		leftOut: s.leftOut,
+		partial: s.deadlineReached || len(s.leftOut) > 0,
+		notes:   s.notes,
+		id:      s.id,
+	}
+}
+
+// interim returns a short summary of the run so far, for periodic
+// reports in the progress output.
+func (s *scheduler) interim(elapsed time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var failed []string
+	for _, r := range s.results {
+		if r.failed() {
+			failed = append(failed, r.label())
+		}
+	}
+	sort.Strings(failed)
+	msg := fmt.Sprintf("--- after %s: %d finished, %d failed, %d running, %d queued",
+		elapsed.Round(time.Second), len(s.results), len(failed), len(s.running), len(s.queue))
+	const maxListed = 10
+	if len(failed) > maxListed {
+		failed = append(failed[:maxListed], fmt.Sprintf("and %d more", len(failed)-maxListed))
+	}
+	if len(failed) > 0 {
+		msg += "\n--- failing: " + strings.Join(failed, ", ")
+	}
+	return msg
+}
+
+func (s *scheduler) status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failed := 0
+	for _, r := range s.results {
+		if r.failed() {
+			failed++
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("jobs: %d", s.jobs),
+		fmt.Sprintf("finished: %d, failed: %d, running: %d, queued: %d",
+			len(s.results), failed, len(s.running), len(s.queue)),
+	}
+	if c := s.capacity(); c < s.jobs {
+		lines[0] += fmt.Sprintf(", %d for reruns", c)
+	}
+	if len(s.weights) > 0 {
+		lines[0] += fmt.Sprintf(", slots in use: %d", s.load())
+	}
+	if s.stopped {
+		lines = append(lines, "stopped")
+	} else if s.paused {
+		lines = append(lines, "paused")
+	} else if s.throttled != "" {
+		lines = append(lines, "throttled: "+s.throttled)
+	}
+
+	var slots []int
+	for k := range s.running {
+		slots = append(slots, k)
+	}
+	sort.Ints(slots)
+	for _, k := range slots {
+		j := s.running[k]
+		j.mu.Lock()
+		started := j.started
+		j.mu.Unlock()
+		elapsed := time.Duration(0)
+		if !started.IsZero() {
+			elapsed = time.Now().Sub(started).Round(time.Second)
+		}
+		weight := ""
+		if n := s.slots(j); n > 1 {
+			weight = fmt.Sprintf(", %d slots", n)
+		}
+		lines = append(lines, fmt.Sprintf("  %3d: %s (%s%s)", k, j.label(), elapsed, weight))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,340 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTests stands in for runTest, returning results right away.
+// outcomes has the status of each attempt of a test, the last one
+// repeating; "crash" is a failure with a signal and anything missing
+// passes.
+type fakeTests struct {
+	outcomes map[string][]string
+
+	mu         sync.Mutex
+	running    int
+	maxRunning int
+	ran        []string
+	isolated   []string
+}
+
+func (f *fakeTests) run(ctx context.Context, j *job) *result {
+	f.mu.Lock()
+	f.running++
+	if f.running > f.maxRunning {
+		f.maxRunning = f.running
+	}
+	f.ran = append(f.ran, fmt.Sprintf("%s#%d", j.name, j.attempt))
+	if j.isolated {
+		f.isolated = append(f.isolated, j.name)
+	}
+	f.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	defer func() {
+		f.mu.Lock()
+		f.running--
+		f.mu.Unlock()
+	}()
+
+	status := statusOK
+	if o := f.outcomes[j.name]; len(o) > 0 {
+		k := j.attempt
+		if k >= len(o) {
+			k = len(o) - 1
+		}
+		status = o[k]
+	}
+	r := &result{
+		name:      j.name,
+		variant:   j.variant,
+		attempt:   j.attempt,
+		iteration: j.iteration,
+		logFile:   j.logName(),
+		status:    status,
+	}
+	if status == "crash" {
+		r.status, r.signal = statusFail, "bus error"
+	}
+	if ctx.Err() != nil {
+		r.status = statusCancelled
+	}
+	r.summary = r.status
+	return r
+}
+
+// runAll runs the queued jobs of s with f, and returns the labels of
+// the results in the order they came in.
+func runAll(s *scheduler, f *fakeTests) []string {
+	results := make(chan *result)
+	go s.run(context.Background(), f.run, results)
+	var got []string
+	for r := range results {
+		got = append(got, r.label())
+	}
+	return got
+}
+
+func statuses(s *scheduler) map[string]string {
+	m := map[string]string{}
+	for _, r := range s.snapshot(0).results {
+		st := r.status
+		if r.previous != nil {
+			st = fmt.Sprintf("%s (attempt %d, was %s)", st, r.attempt+1, r.previous.status)
+		}
+		m[r.label()] = st
+	}
+	return m
+}
+
+func TestSchedulerJobs(t *testing.T) {
+	var names []string
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("t%04d-x.sh", i))
+	}
+	for _, jobs := range []int{1, 3, 8} {
+		f := &fakeTests{}
+		s := newScheduler(jobs, newJobs(names))
+		got := runAll(s, f)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, names) {
+			t.Errorf("jobs %d: ran %v, want %v", jobs, got, names)
+		}
+		if f.maxRunning > jobs {
+			t.Errorf("jobs %d: %d tests ran at once", jobs, f.maxRunning)
+		}
+		if st := s.exitStatus(); st != exitOK {
+			t.Errorf("jobs %d: exit status %d", jobs, st)
+		}
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	f := &fakeTests{}
+	s := newScheduler(2, newJobs([]string{"t1.sh", "t2.sh", "t3.sh"}))
+	s.stop()
+	if got := runAll(s, f); len(got) != 0 {
+		t.Errorf("stopped scheduler ran %v", got)
+	}
+	if st := s.exitStatus(); st != exitCancelled {
+		t.Errorf("exit status %d, want %d", st, exitCancelled)
+	}
+	if rr := s.snapshot(0); rr.notRun != 3 {
+		t.Errorf("not run %d, want 3", rr.notRun)
+	}
+}
+
+func TestCancelQueued(t *testing.T) {
+	f := &fakeTests{}
+	s := newScheduler(1, newJobs([]string{"t1.sh", "t2.sh", "t3.sh"}))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context, j *job) *result {
+		if j.name == "t1.sh" {
+			close(started)
+			<-release
+		}
+		return f.run(ctx, j)
+	}
+	results := make(chan *result)
+	go s.run(context.Background(), fn, results)
+	<-started
+	if !s.cancelTest("t2.sh") {
+		t.Fatal("cancelTest did not find t2.sh")
+	}
+	close(release)
+	n := 0
+	for range results {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("got %d results, want 3", n)
+	}
+	want := map[string]string{
+		"t1.sh": statusOK,
+		"t2.sh": statusCancelled,
+		"t3.sh": statusOK,
+	}
+	if got := statuses(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if rr := s.snapshot(0); rr.notRun != 0 {
+		t.Errorf("not run %d, want 0", rr.notRun)
+	}
+}
+
+func TestOOMFails(t *testing.T) {
+	f := &fakeTests{outcomes: map[string][]string{"t2-oom.sh": {statusOOM}}}
+	s := newScheduler(2, newJobs([]string{"t1.sh", "t2-oom.sh"}))
+	runAll(s, f)
+	failures := 0
+	for _, r := range s.snapshot(0).results {
+		if r.failed() {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Errorf("%d failures, want the OOM kill", failures)
+	}
+	if st := s.finalStatus(failures); st != exitFailures {
+		t.Errorf("exit status %d, want %d", st, exitFailures)
+	}
+	if msg := s.interim(0); !strings.Contains(msg, "1 failed") {
+		t.Errorf("interim summary %q does not count the OOM kill", msg)
+	}
+}
+
+func TestSchedulerDeadline(t *testing.T) {
+	s := newScheduler(1, newJobs([]string{"t1.sh", "t2.sh"}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	results := make(chan *result)
+	go s.run(ctx, func(ctx context.Context, j *job) *result {
+		<-ctx.Done()
+		return &result{name: j.name, status: statusCancelled}
+	}, results)
+	for range results {
+	}
+	rr := s.snapshot(0)
+	if !rr.partial || rr.aborted != "deadline reached" || s.exitStatus() != exitTimeout {
+		t.Errorf("partial %v, aborted %q, exit status %d", rr.partial, rr.aborted, s.exitStatus())
+	}
+	if want := []string{"partial run: --deadline reached"}; !reflect.DeepEqual(rr.notes, want) {
+		t.Errorf("notes %q, want %q", rr.notes, want)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	f := &fakeTests{outcomes: map[string][]string{
+		"t1-flaky.sh":   {"crash", statusOK},
+		"t2-broken.sh":  {statusFail},
+		"t3-crashes.sh": {"crash"},
+		"t4-slow.sh":    {statusTimeout, statusOK},
+	}}
+	s := newScheduler(4, newJobs([]string{"t1-flaky.sh", "t2-broken.sh", "t3-crashes.sh", "t4-slow.sh", "t5-ok.sh"}))
+	runAll(s, f)
+
+	retryOn, err := parseRetryOn("signal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var retry []*result
+	for _, r := range s.snapshot(0).results {
+		if retryOn[r.failureClass()] {
+			retry = append(retry, r)
+		}
+	}
+	s.requeue(retry, 1, false)
+	got := runAll(s, f)
+	sort.Strings(got)
+	if want := []string{"t1-flaky.sh", "t3-crashes.sh"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("retried %v, want %v", got, want)
+	}
+	want := map[string]string{
+		"t1-flaky.sh":   "ok (attempt 2, was error)",
+		"t2-broken.sh":  "error",
+		"t3-crashes.sh": "error (attempt 2, was error)",
+		"t4-slow.sh":    "timeout",
+		"t5-ok.sh":      "ok",
+	}
+	if got := statuses(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("after retry: %v, want %v", got, want)
+	}
+}
+
+func TestRerunFailures(t *testing.T) {
+	f := &fakeTests{outcomes: map[string][]string{
+		"t1-alone.sh": {statusFail, statusOK},
+		"t2-fail.sh":  {statusFail},
+	}}
+	s := newScheduler(4, newJobs([]string{"t1-alone.sh", "t2-fail.sh", "t3-ok.sh"}))
+	runAll(s, f)
+	var failed []*result
+	for _, r := range s.snapshot(0).results {
+		if r.failed() {
+			failed = append(failed, r)
+		}
+	}
+
+	s.requeue(failed, 1, true)
+	runAll(s, f)
+	sort.Strings(f.isolated)
+	if want := []string{"t1-alone.sh", "t2-fail.sh"}; !reflect.DeepEqual(f.isolated, want) {
+		t.Errorf("isolated %v, want %v", f.isolated, want)
+	}
+	s.rerunVerbose(failed)
+	runAll(s, f)
+
+	want := map[string]string{
+		"t1-alone.sh": "ok (attempt 2, was error)",
+		"t2-fail.sh":  "error (attempt 2, was error)",
+		"t3-ok.sh":    "ok",
+	}
+	if got := statuses(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("after rerunning alone: %v, want %v", got, want)
+	}
+	for _, r := range s.snapshot(0).results {
+		want := ""
+		if r.name != "t3-ok.sh" {
+			want = r.name + ".verbose.log"
+		}
+		if r.verboseLog != want {
+			t.Errorf("%s: verbose log %q, want %q", r.name, r.verboseLog, want)
+		}
+	}
+	if len(f.ran) != 7 {
+		t.Errorf("ran %v, want 7 runs", f.ran)
+	}
+}
+
+func TestRerunKeepsJobs(t *testing.T) {
+	names := []string{"t1.sh", "t2.sh", "t3.sh", "t4.sh"}
+	f := &fakeTests{outcomes: map[string][]string{}}
+	for _, n := range names {
+		f.outcomes[n] = []string{statusFail}
+	}
+	s := newScheduler(4, newJobs(names))
+	runAll(s, f)
+	failed := s.snapshot(0).results
+
+	f.maxRunning = 0
+	s.requeue(failed, 1, true)
+	// As "ctl jobs 6" would while the reruns are going.
+	s.setJobs(6)
+	runAll(s, f)
+	if f.maxRunning != 1 {
+		t.Errorf("%d isolated reruns at once, want 1", f.maxRunning)
+	}
+	if s.jobs != 6 || s.capacity() != 6 {
+		t.Errorf("after reruns: jobs %d, capacity %d, want 6", s.jobs, s.capacity())
+	}
+
+	s.rerunVerbose(failed)
+	if c := s.capacity(); c != 1 {
+		t.Errorf("capacity %d for verbose reruns, want 1", c)
+	}
+	runAll(s, f)
+	if s.jobs != 6 || s.capacity() != 6 {
+		t.Errorf("after verbose reruns: jobs %d, capacity %d, want 6", s.jobs, s.capacity())
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// scheduleEntry records the dispatch of one job. After holds the
+// dispatch numbers of the jobs that finished since the previous
+// dispatch, so a replay can wait for the same jobs.
+type scheduleEntry struct {
+	Seq       int    `json:"seq"`
+	Slot      int    `json:"slot"`
+	Name      string `json:"name"`
+	Variant   string `json:"variant,omitempty"`
+	Iteration int    `json:"iteration,omitempty"`
+	Attempt   int    `json:"attempt,omitempty"`
+	After     []int  `json:"after,omitempty"`
+}
+
+func (e *scheduleEntry) matches(j *job) bool {
+	v := ""
+	if j.variant != nil {
+		v = j.variant.name
+	}
+	return e.Name == j.name && e.Variant == v && e.Iteration == j.iteration && e.Attempt == j.attempt
+}
+
+// readSchedule reads a schedule written by --record-schedule.
+func readSchedule(path string) ([]scheduleEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []scheduleEntry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		var e scheduleEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// replayState follows a recorded schedule.
+type replayState struct {
+	entries []scheduleEntry
+	pos     int
+
+	// done holds the recorded dispatch numbers of finished jobs,
+	// and of entries that do not occur in this run.
+	done map[int]bool
+
+	// seqs maps dispatched jobs to their recorded dispatch number.
+	seqs map[*job]int
+}
+
+func newReplay(entries []scheduleEntry) *replayState {
+	return &replayState{
+		entries: entries,
+		done:    map[int]bool{},
+		seqs:    map[*job]int{},
+	}
+}
+
+// pick returns the index in queue of the job to dispatch next, or -1
+// if it must wait for other jobs to finish. Once the schedule is
+// exhausted, the queue is run in order.
+func (r *replayState) pick(queue []*job) int {
+	for ; r.pos < len(r.entries); r.pos++ {
+		e := &r.entries[r.pos]
+		for i, j := range queue {
+			if !e.matches(j) {
+				continue
+			}
+			for _, a := range e.After {
+				if !r.done[a] {
+					return -1
+				}
+			}
+			return i
+		}
+		// Not part of this run.
+		r.done[e.Seq] = true
+	}
+	return 0
+}
+
+// dispatched records that j was started for the current entry, and
+// returns the slot it ran in.
+func (r *replayState) dispatched(j *job) int {
+	if r.pos >= len(r.entries) || !r.entries[r.pos].matches(j) {
+		return -1
+	}
+	e := r.entries[r.pos]
+	r.seqs[j] = e.Seq
+	r.pos++
+	return e.Slot
+}
+
+func (r *replayState) finished(j *job) {
+	if seq, ok := r.seqs[j]; ok {
+		r.done[seq] = true
+		delete(r.seqs, j)
+	}
+}
+
+// scheduleRecorder writes the dispatches of a run.
+type scheduleRecorder struct {
+	w   io.Writer
+	seq int
+
+	// seqs maps running jobs to their dispatch number.
+	seqs map[*job]int
+
+	// since holds the jobs that finished since the last dispatch.
+	since []int
+}
+
+func newScheduleRecorder(w io.Writer) *scheduleRecorder {
+	return &scheduleRecorder{w: w, seqs: map[*job]int{}}
+}
+
+func (r *scheduleRecorder) dispatched(j *job) error {
+	e := scheduleEntry{
+		Seq:       r.seq,
+		Slot:      j.slot,
+		Name:      j.name,
+		Iteration: j.iteration,
+		Attempt:   j.attempt,
+		After:     r.since,
+	}
+	if j.variant != nil {
+		e.Variant = j.variant.name
+	}
+	r.seqs[j] = r.seq
+	r.seq++
+	r.since = nil
+	data, err := json.Marshal(&e)
+	if err != nil {
+		return err
+	}
+	_, err = r.w.Write(append(data, '\n'))
+	return err
+}
+
+func (r *scheduleRecorder) finished(j *job) {
+	if seq, ok := r.seqs[j]; ok {
+		r.since = append(r.since, seq)
+		delete(r.seqs, j)
+	}
+}
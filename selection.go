@@ -0,0 +1,121 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// selection is a named set of globs from --select NAME=GLOB.
+type selection struct {
+	name  string
+	globs []string
+}
+
+// parseSelections groups the --select values by name, in the order
+// the names first appear.
+func parseSelections(values []string) ([]selection, error) {
+	var sels []selection
+	index := map[string]int{}
+	for _, v := range values {
+		eq := strings.Index(v, "=")
+		if eq <= 0 || eq == len(v)-1 {
+			return nil, fmt.Errorf("want NAME=GLOB, got %q", v)
+		}
+		name, glob := v[:eq], v[eq+1:]
+		i, ok := index[name]
+		if !ok {
+			i = len(sels)
+			index[name] = i
+			sels = append(sels, selection{name: name})
+		}
+		sels[i].globs = append(sels[i].globs, glob)
+	}
+	return sels, nil
+}
+
+// addSelections appends the tests of the selections to entries, and
+// returns which selections each test is in.
+func addSelections(entries []string, sels []selection, skip []string) ([]string, map[string][]string, error) {
+	have := map[string]bool{}
+	for _, e := range entries {
+		have[e] = true
+	}
+	in := map[string][]string{}
+	for _, sel := range sels {
+		names, err := selectTests(sel.globs, skip)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, n := range names {
+			if l := in[n]; len(l) > 0 && l[len(l)-1] == sel.name {
+				continue
+			}
+			in[n] = append(in[n], sel.name)
+			if !have[n] {
+				have[n] = true
+				entries = append(entries, n)
+			}
+		}
+	}
+	return entries, in, nil
+}
+
+// selectionCounts holds the results per status of a selection.
+type selectionCounts struct {
+	name   string
+	tests  int
+	counts map[string]int
+	failed []string
+}
+
+// bySelection breaks the results down per selection, sorted by name.
+// Tests not in any selection are left out.
+func bySelection(results []*result) []*selectionCounts {
+	m := map[string]*selectionCounts{}
+	for _, r := range results {
+		for _, name := range r.selections {
+			c := m[name]
+			if c == nil {
+				c = &selectionCounts{name: name, counts: map[string]int{}}
+				m[name] = c
+			}
+			c.tests++
+			c.counts[r.status]++
+			if r.failed() {
+				c.failed = append(c.failed, r.label())
+			}
+		}
+	}
+	var list []*selectionCounts
+	for _, c := range m {
+		sort.Strings(c.failed)
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+// line summarizes the selection for summary.txt.
+func (c *selectionCounts) line() string {
+	l := fmt.Sprintf("%-20s - %d tests: %d passed, %d failed, %d skipped",
+		c.name, c.tests, c.counts[statusOK], len(c.failed), c.counts[statusSkipped])
+	if len(c.failed) > 0 {
+		l += "\n\tfailed: " + strings.Join(c.failed, ", ")
+	}
+	return l
+}
@@ -0,0 +1,230 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// selftestScripts is the synthetic suite run by "rungittest selftest".
+var selftestScripts = map[string]string{
+	"t0001-fast.sh": `echo "ok 1 - fast"
+echo "1..1"
+`,
+	"t0002-slow.sh": `sleep 2
+echo "ok 1 - slow"
+echo "1..1"
+`,
+	"t0003-fail.sh": `echo "ok 1 - setup"
+echo "not ok 2 - broken"
+echo "1..2"
+exit 1
+`,
+	"t0004-flaky.sh": `# Fails on the first run only.
+marker="${0%.sh}.ran"
+if test -e "$marker"
+then
+	echo "ok 1 - flaky"
+	echo "1..1"
+	exit 0
+fi
+: >"$marker"
+echo "not ok 1 - flaky"
+echo "1..1"
+exit 1
+`,
+	"t0005-hang.sh": `echo "ok 1 - before hang"
+sleep 60 &
+wait
+echo "1..1"
+`,
+	"t0006-huge.sh": `i=1
+while test $i -le 5000
+do
+	echo "ok $i - output line $i"
+	echo "noise on stderr for line $i" >&2
+	i=$((i + 1))
+done
+echo "1..5000"
+`,
+	"t0007-skip.sh": `echo "1..0 # SKIP missing prerequisite SELFTEST"
+`,
+}
+
+// selftestCheck is one expectation on the results of the selftest run.
+type selftestCheck struct {
+	name string
+	err  error
+}
+
+// checkSelftest checks the output of the selftest run in dir.
+func checkSelftest(dir string, jobs int) []selftestCheck {
+	var checks []selftestCheck
+	check := func(name string, err error) {
+		checks = append(checks, selftestCheck{name, err})
+	}
+
+	run, err := readResults(dir)
+	if err != nil {
+		check("results.json", err)
+		return checks
+	}
+	check("results.json", nil)
+	tests := map[string]*jsonTest{}
+	for i := range run.Tests {
+		tests[run.Tests[i].Name] = &run.Tests[i]
+	}
+	status := func(name, want string) {
+		t := tests[name]
+		if t == nil {
+			check(name, fmt.Errorf("no result"))
+		} else if t.Status != want {
+			check(name, fmt.Errorf("status %q, want %q (%s)", t.Status, want, t.Summary))
+		} else {
+			check(name, nil)
+		}
+	}
+	status("t0001-fast.sh", statusOK)
+	status("t0002-slow.sh", statusOK)
+	status("t0003-fail.sh", statusFail)
+	status("t0005-hang.sh", statusTimeout)
+	status("t0006-huge.sh", statusOK)
+	status("t0007-skip.sh", statusSkipped)
+
+	if t := tests["t0004-flaky.sh"]; t == nil {
+		check("retry", fmt.Errorf("no result for t0004-flaky.sh"))
+	} else if t.Attempt != 1 || t.PreviousStatus != statusFail || t.Status != statusSuspect {
+		check("retry", fmt.Errorf("t0004-flaky.sh: attempt %d, status %q after %q; want attempt 1, %q after %q",
+			t.Attempt, t.Status, t.PreviousStatus, statusSuspect, statusFail))
+	} else {
+		check("retry", nil)
+	}
+
+	if t := tests["t0006-huge.sh"]; t != nil {
+		if t.TAP == nil || t.TAP.Passed != 5000 {
+			check("huge output", fmt.Errorf("TAP %+v, want 5000 passed", t.TAP))
+		} else {
+			check("huge output", nil)
+		}
+	}
+
+	if slow := tests["t0002-slow.sh"]; slow != nil && jobs > 1 {
+		if len(slow.Concurrent) == 0 {
+			check("scheduling", fmt.Errorf("t0002-slow.sh ran alone with --jobs=%d", jobs))
+		} else {
+			check("scheduling", nil)
+		}
+	}
+
+	summary, err := ioutil.ReadFile(filepath.Join(dir, "summary.txt"))
+	if err == nil && !strings.Contains(string(summary), "t0003-fail.sh") {
+		err = fmt.Errorf("t0003-fail.sh is not listed")
+	}
+	check("summary.txt", err)
+
+	tap, err := ioutil.ReadFile(filepath.Join(dir, "all.tap"))
+	if err == nil && !strings.Contains(string(tap), "not ok") {
+		err = fmt.Errorf("no failing test points")
+	}
+	check("--tap-out", err)
+	return checks
+}
+
+// selftestMain implements "rungittest selftest", which runs a
+// synthetic suite through the whole program and checks the results.
+func selftestMain(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	jobs := fs.Int("jobs", 4, "number of parallel jobs for the selftest run")
+	keep := fs.Bool("keep", false, "keep the generated suite and its results")
+	verbose := fs.Bool("v", false, "show the output of the selftest run")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest selftest [flags]\n\n"+
+			"Generates a suite of fast, slow, failing, flaky, hanging and noisy scripts in a\n"+
+			"temporary directory, runs it and checks scheduling, timeouts, retries and\n"+
+			"reporting. Exits with status 1 if a check fails.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "rungittest-selftest-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	suite := filepath.Join(dir, "t")
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(suite, 0755); err != nil {
+		log.Fatal(err)
+	}
+	for name, content := range selftestScripts {
+		if err := ioutil.WriteFile(filepath.Join(suite, name), []byte(content), 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(self, "--chdir", suite, "--outdir", out,
+		fmt.Sprintf("--jobs=%d", *jobs), "--history=", "--timeout=3s", "--grace=1s",
+		"--isolate-failures", "--tap-out", filepath.Join(out, "all.tap"), "t*.sh")
+	// Keep the user's configuration out of the run.
+	cmd.Env = []string{"HOME=" + dir}
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "HOME=") && !strings.HasPrefix(e, "RUNGITTEST_") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	if *verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	checks := []selftestCheck{{name: "exit status"}}
+	if err := cmd.Run(); err == nil {
+		checks[0].err = fmt.Errorf("run succeeded, want status %d", exitFailures)
+	} else if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != exitFailures {
+		checks[0].err = fmt.Errorf("%v, want status %d", err, exitFailures)
+	}
+
+	failed := 0
+	for _, c := range append(checks, checkSelftest(out, *jobs)...) {
+		if c.err != nil {
+			failed++
+			fmt.Printf("FAIL %-20s %v\n", c.name, c.err)
+		} else {
+			fmt.Printf("ok   %s\n", c.name)
+		}
+	}
+	if failed > 0 || *keep {
+		fmt.Printf("suite and results kept in %s\n", dir)
+	} else {
+		os.RemoveAll(dir)
+	}
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+}
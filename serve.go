@@ -0,0 +1,100 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+const serveNav = `<body>
+<p><a href="summary.txt">summary.txt</a> <a href="results.json">results.json</a> <a href="files/">all files</a></p>
+`
+
+// serveHandler serves the files of the output dir, with a report of
+// the run rendered afresh for each request as the index, so a running
+// test suite can be followed by reloading. If historyPath is set, the
+// history DB is served under /api/.
+func serveHandler(dir, historyPath string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+	mux := http.NewServeMux()
+	if historyPath != "" {
+		mux.Handle("/api/", historyAPI(historyPath))
+	}
+	mux.Handle("/files/", http.StripPrefix("/files/", files))
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			files.ServeHTTP(w, req)
+			return
+		}
+		rr, err := loadRun(dir)
+		if os.IsNotExist(err) {
+			// Not started yet, or no results.json at all.
+			http.Redirect(w, req, "/files/", http.StatusFound)
+			return
+		}
+		var page []byte
+		if err == nil {
+			page, err = htmlReport(rr, "")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page = bytes.Replace(page, []byte("<body>\n"), []byte(serveNav), 1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	})
+	return mux
+}
+
+// serveMain implements "rungittest serve DIR", which serves an output
+// dir over HTTP.
+func serveMain(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "address to listen on")
+	historyFile := fs.String("history", defaultHistoryPath(), "history DB to serve under /api/; empty to disable")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest serve [flags] DIR\n\n"+
+			"Serves the output dir DIR over HTTP. The index is the HTML report of\n"+
+			"the run, linking to the logs and artifacts; /files/ lists all files.\n"+
+			"The history DB is served as JSON: /api/runs, /api/runs/ID/tests and\n"+
+			"/api/tests/NAME/history.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	// Allow flags after DIR too.
+	dir := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		log.Fatal(err)
+	} else if !fi.IsDir() {
+		log.Fatalf("%s is not a directory", dir)
+	}
+	log.Printf("serving %s on %s", dir, *listen)
+	log.Fatal(http.ListenAndServe(*listen, serveHandler(dir, *historyFile)))
+}
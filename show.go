@@ -0,0 +1,149 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// findResult returns the result for a test given by label, script name
+// or test number (eg. "t0001"), or the first failure if name is empty.
+func findResult(results []*result, name string) (*result, error) {
+	if name == "" {
+		var failed []*result
+		for _, r := range results {
+			if r.failed() {
+				failed = append(failed, r)
+			}
+		}
+		if len(failed) == 0 {
+			return nil, fmt.Errorf("no failed tests")
+		}
+		sort.SliceStable(failed, func(i, j int) bool { return failed[i].start.Before(failed[j].start) })
+		return failed[0], nil
+	}
+	var found []*result
+	for _, r := range results {
+		if r.label() == name || r.name == name || testID(r.name) == name || strings.TrimSuffix(r.name, ".sh") == name {
+			found = append(found, r)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no test %q", name)
+	case 1:
+		return found[0], nil
+	}
+	var labels []string
+	for _, r := range found {
+		labels = append(labels, r.label())
+	}
+	return nil, fmt.Errorf("%q is ambiguous: %s", name, strings.Join(labels, ", "))
+}
+
+// page shows text in $PAGER if stdout is a terminal.
+func page(text io.Reader) error {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	args := strings.Fields(pager)
+	if len(args) == 0 || !isTerminal(os.Stdout) {
+		_, err := io.Copy(os.Stdout, text)
+		return err
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		_, err := io.Copy(os.Stdout, text)
+		return err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = text
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// showMain implements "rungittest show DIR [TEST]", which shows the log
+// of a test.
+func showMain(args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	allFailed := fs.Bool("all-failed", false, "show the failing test cases of all failed tests")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest show [flags] DIR [TEST]\n\n"+
+			"Shows the log of TEST (a script name, test number or label) from the run in\n"+
+			"DIR in $PAGER, by default that of the first failed test.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 || (*allFailed && fs.NArg() != 1) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := fs.Arg(0)
+	run, err := readResults(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var results []*result
+	variants := map[string]*variant{}
+	for i := range run.Tests {
+		results = append(results, run.Tests[i].result(variants))
+	}
+
+	if *allFailed {
+		var buf bytes.Buffer
+		for _, r := range results {
+			if !r.failed() {
+				continue
+			}
+			data, err := ioutil.ReadFile(filepath.Join(dir, r.logFile))
+			if err != nil {
+				log.Printf("%s: %v", r.label(), err)
+				continue
+			}
+			fmt.Fprintf(&buf, "=== %s (%s) %s\n%s\n\n", r.label(), r.status, r.logFile, failureExcerpt(data, nil))
+		}
+		if buf.Len() == 0 {
+			log.Fatal("no failed tests")
+		}
+		if err := page(&buf); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	r, err := findResult(results, fs.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, r.logFile))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := page(f); err != nil {
+		log.Fatal(err)
+	}
+}
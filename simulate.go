@@ -0,0 +1,234 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// orderQueue orders the queue as --order does.
+func orderQueue(queue []*job, h *history, order string) ([]*job, error) {
+	switch order {
+	case "given":
+		return queue, nil
+	case "fail-first":
+		return failFirst(queue, h), nil
+	case "slow", "fast":
+		return byDuration(queue, h, order == "slow"), nil
+	}
+	return nil, fmt.Errorf("unknown order %q", order)
+}
+
+// simulation is the outcome of a simulated run.
+type simulation struct {
+	wall time.Duration
+
+	// busy is the slot time taken by tests, for the utilization.
+	busy time.Duration
+}
+
+// simulateRun plays a run of the queue on the given number of slots,
+// dispatching like the scheduler: whenever slots free up, the first
+// job in the queue that fits is started. Constraints are not taken
+// into account.
+func simulateRun(queue []*job, est map[*job]time.Duration, ws []weight, jobs int) simulation {
+	type running struct {
+		end   time.Duration
+		slots int
+	}
+	var sim simulation
+	var now time.Duration
+	var run []running
+	load := 0
+	q := append([]*job{}, queue...)
+	for len(q) > 0 || len(run) > 0 {
+		for i := 0; i < len(q); {
+			n := slotsFor(ws, q[i])
+			if n > jobs {
+				n = jobs
+			}
+			if load+n > jobs {
+				i++
+				continue
+			}
+			d := est[q[i]]
+			run = append(run, running{now + d, n})
+			load += n
+			sim.busy += d * time.Duration(n)
+			q = append(q[:i:i], q[i+1:]...)
+			// Start over, as the scheduler looks at the queue
+			// from the front for every free slot.
+			i = 0
+		}
+		first := 0
+		for k := range run {
+			if run[k].end < run[first].end {
+				first = k
+			}
+		}
+		now = run[first].end
+		load -= run[first].slots
+		run = append(run[:first:first], run[first+1:]...)
+	}
+	sim.wall = now
+	return sim
+}
+
+// historyTests returns the tests of the history DB that ran without a
+// variant.
+func historyTests(h *history) []string {
+	var tests []string
+	for l, runs := range h.runs {
+		if len(runs) > 0 && runs[0].Variant == "" && l == runs[0].Name {
+			tests = append(tests, l)
+		}
+	}
+	sort.Strings(tests)
+	return tests
+}
+
+// simulateMain implements "rungittest simulate", which predicts the
+// wall-clock time of a run for several job counts and orders.
+func simulateMain(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	historyFile := fs.String("history", defaultHistoryPath(), "history of past runs to take the durations from")
+	jobList := fs.String("jobs", "4,8,16,32", "comma separated numbers of jobs to simulate")
+	orders := fs.String("order", "given,fail-first,slow,fast", "comma separated orders of the tests to simulate, as for --order")
+	weightsFile := fs.String("weights", "", "file with \"PATTERN SLOTS\" lines giving the number of worker slots tests take")
+	chdir := fs.String("chdir", "", "change to this directory before expanding globs")
+	skipTests := fs.String("skip-tests", "", "GIT_SKIP_TESTS style patterns of tests to skip")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest simulate [flags] [GLOB...]\n\n"+
+			"Predicts the wall-clock time of running the tests matching GLOB, or all tests\n"+
+			"in the history, for each number of jobs and order, from the durations in the\n"+
+			"history. Nothing is run.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var counts []int
+	for _, s := range splitList(*jobList) {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			log.Fatalf("--jobs: bad number of jobs %q", s)
+		}
+		counts = append(counts, n)
+	}
+	policies := splitList(*orders)
+	if len(counts) == 0 || len(policies) == 0 || *historyFile == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	h, err := readHistory(*historyFile)
+	if err != nil {
+		log.Fatalf("--history: %v", err)
+	}
+	var ws []weight
+	if *weightsFile != "" {
+		if ws, err = readWeights(*weightsFile); err != nil {
+			log.Fatalf("--weights: %v", err)
+		}
+	}
+	if *chdir != "" {
+		if err := os.Chdir(*chdir); err != nil {
+			log.Fatalf("chdir: %v", err)
+		}
+	}
+	tests := historyTests(h)
+	if fs.NArg() > 0 {
+		if tests, err = selectTests(fs.Args(), strings.Fields(*skipTests)); err != nil {
+			log.Fatal(err)
+		}
+	} else if skip := strings.Fields(*skipTests); len(skip) > 0 {
+		var kept []string
+		for _, t := range tests {
+			if !matchSkip(t, skip) {
+				kept = append(kept, t)
+			}
+		}
+		tests = kept
+	}
+	if len(tests) == 0 {
+		log.Fatal("no tests")
+	}
+
+	queue := newJobs(tests)
+	def := h.medianDuration(defaultEstimate)
+	est := map[*job]time.Duration{}
+	var total, longest time.Duration
+	unknown := 0
+	for _, j := range queue {
+		d, ok := h.duration(j.label())
+		if !ok {
+			d = def
+			unknown++
+		}
+		est[j] = d
+		total += d * time.Duration(slotsFor(ws, j))
+		if d > longest {
+			longest = d
+		}
+	}
+	ordered := map[string][]*job{}
+	for _, p := range policies {
+		q, err := orderQueue(queue, h, p)
+		if err != nil {
+			log.Fatalf("--order: %v", err)
+		}
+		ordered[p] = q
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d tests, %s of test time", len(tests), total.Round(time.Second))
+	if unknown > 0 {
+		fmt.Fprintf(&buf, "; %d without passing runs in the history, assumed to take %s", unknown, def.Round(time.Second))
+	}
+	fmt.Fprintf(&buf, "\n\n%5s", "jobs")
+	for _, p := range policies {
+		fmt.Fprintf(&buf, " %10s", p)
+	}
+	fmt.Fprintf(&buf, " %10s %6s\n", "bound", "util")
+	for _, n := range counts {
+		fmt.Fprintf(&buf, "%5d", n)
+		var best simulation
+		for i, p := range policies {
+			sim := simulateRun(ordered[p], est, ws, n)
+			if i == 0 || sim.wall < best.wall {
+				best = sim
+			}
+			fmt.Fprintf(&buf, " %10s", sim.wall.Round(time.Second))
+		}
+		// No order can beat the longest test, or the test time
+		// spread evenly over the slots.
+		bound := total / time.Duration(n)
+		if longest > bound {
+			bound = longest
+		}
+		util := "-"
+		if best.wall > 0 {
+			util = fmt.Sprintf("%.0f%%", 100*float64(best.busy)/float64(best.wall*time.Duration(n)))
+		}
+		fmt.Fprintf(&buf, " %10s %6s\n", bound.Round(time.Second), util)
+	}
+	fmt.Print(buf.String())
+}
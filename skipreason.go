@@ -0,0 +1,222 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of skip reasons.
+const (
+	// skipPrereq is a missing test-lib.sh prerequisite; the key is
+	// its name, eg. PERL.
+	skipPrereq = "prereq"
+
+	// skipList is GIT_SKIP_TESTS (or --skip-tests), and skipRunFilter
+	// the --run option of the script.
+	skipList      = "skip-list"
+	skipRunFilter = "run-filter"
+
+	// skipOptIn are tests that must be enabled, mostly by a
+	// GIT_TEST_* variable, which is the key.
+	skipOptIn = "opt-in"
+
+	// skipDependency is a tool or service that is not installed; the
+	// key names it, eg. httpd.
+	skipDependency = "dependency"
+
+	// skipEnvironment is a property of the machine, such as the
+	// file system or running as root, and skipPlatform the OS.
+	skipEnvironment = "environment"
+	skipPlatform    = "platform"
+
+	skipOther = "other"
+)
+
+// skipReason is a normalized reason for skipping tests.
+type skipReason struct {
+	kind, key string
+
+	// text is the reason as given by the first skip.
+	text string
+
+	// count is the number of test cases skipped for the reason.
+	// script is set if the whole script was skipped.
+	count  int
+	script bool
+}
+
+// skipRules classify the free text reasons for skipping a script, in
+// order. A rule without a key takes the first submatch, if any.
+var skipRules = []struct {
+	re        *regexp.Regexp
+	kind, key string
+}{
+	{regexp.MustCompile(`altogether|GIT_SKIP_TESTS`), skipList, ""},
+	{regexp.MustCompile(`\b(GIT_TEST_[A-Z0-9_]+|GIT_SVN_[A-Z0-9_]+)`), skipOptIn, ""},
+	{regexp.MustCompile(`\bEXPENSIVE\b|(?i)long[- ]running`), skipOptIn, "EXPENSIVE"},
+	{regexp.MustCompile(`(?i)web ?server|\bhttpd\b|\bapache\b`), skipDependency, "httpd"},
+	{regexp.MustCompile(`(?i)\bsvn\b|subversion`), skipDependency, "svn"},
+	{regexp.MustCompile(`(?i)\bcvs(ps)?\b`), skipDependency, "cvs"},
+	{regexp.MustCompile(`(?i)\bp4d?\b|perforce`), skipDependency, "p4"},
+	{regexp.MustCompile(`(?i)\bgpg(sm)?\b|gnupg`), skipDependency, "gpg"},
+	{regexp.MustCompile(`(?i)\bperl\b`), skipDependency, "perl"},
+	{regexp.MustCompile(`(?i)\bpython\b`), skipDependency, "python"},
+	{regexp.MustCompile(`(?i)\bgit[- ]daemon\b`), skipDependency, "git-daemon"},
+	{regexp.MustCompile(`(?i)case[- ]insensitive|unicode|symlink|file ?system|utf-?8`), skipEnvironment, "filesystem"},
+	{regexp.MustCompile(`(?i)\broot\b|permission`), skipEnvironment, "permissions"},
+	{regexp.MustCompile(`(?i)\b(windows|mingw|cygwin|macos|darwin)\b`), skipPlatform, ""},
+	{regexp.MustCompile(`(?i)\b([a-z][\w.+-]*) (?:is )?not (?:found|available|installed)|\bno ([a-z][\w.+-]*) (?:found|available|installed)`), skipDependency, ""},
+}
+
+// classifySkip returns the kind and key of a reason for skipping a
+// whole script.
+func classifySkip(text string) (kind, key string) {
+	for _, r := range skipRules {
+		m := r.re.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		key = r.key
+		if key == "" {
+			for _, s := range m[1:] {
+				if s != "" {
+					key = strings.ToLower(s)
+					if r.kind == skipOptIn {
+						key = s
+					}
+					break
+				}
+			}
+		}
+		return r.kind, key
+	}
+	return skipOther, ""
+}
+
+// addSkipReason counts a skipped test case, or the whole script.
+func (t *tapResult) addSkipReason(kind, key, text string, script bool) {
+	for _, r := range t.skipReasons {
+		if r.kind == kind && r.key == key {
+			if script {
+				r.script = true
+			} else {
+				r.count++
+			}
+			return
+		}
+	}
+	r := &skipReason{kind: kind, key: key, text: text, script: script}
+	if !script {
+		r.count = 1
+	}
+	t.skipReasons = append(t.skipReasons, r)
+}
+
+// caseReasonRE matches the reason test-lib.sh puts after a skipped
+// test case: "ok 3 # skip DESCRIPTION (REASON)".
+var caseReasonRE = regexp.MustCompile(`\(([^()]*)\)\s*$`)
+
+// skippedCase classifies the reason of a skipped test case.
+func (t *tapResult) skippedCase(line string) {
+	m := caseReasonRE.FindStringSubmatch(line)
+	if m == nil {
+		t.addSkipReason(skipOther, "", "", false)
+		return
+	}
+	reason := strings.TrimSpace(m[1])
+	switch {
+	case strings.HasPrefix(reason, "missing "):
+		for _, p := range prereqList(reason[len("missing "):]) {
+			t.addSkipReason(skipPrereq, p, reason, false)
+		}
+	case reason == "GIT_SKIP_TESTS":
+		t.addSkipReason(skipList, "", reason, false)
+	case reason == "--run":
+		t.addSkipReason(skipRunFilter, "", reason, false)
+	default:
+		kind, key := classifySkip(reason)
+		t.addSkipReason(kind, key, reason, false)
+	}
+}
+
+// skippedScript classifies the reason for skipping the whole script.
+func (t *tapResult) skippedScript(text string) {
+	if m := missingRE.FindStringSubmatch(text); m != nil {
+		for _, p := range prereqList(m[1]) {
+			t.addSkipReason(skipPrereq, p, text, true)
+		}
+		return
+	}
+	if strings.HasPrefix(text, "missing ") {
+		for _, p := range prereqList(text[len("missing "):]) {
+			t.addSkipReason(skipPrereq, p, text, true)
+		}
+		return
+	}
+	kind, key := classifySkip(text)
+	t.addSkipReason(kind, key, text, true)
+}
+
+// prereqList splits "A,B of A,B,C" into A and B.
+func prereqList(s string) []string {
+	if i := strings.Index(s, " of "); i >= 0 {
+		s = s[:i]
+	}
+	var list []string
+	for _, p := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		list = appendNew(list, p)
+	}
+	return list
+}
+
+// skipTotals adds up the skip reasons over the results, most skipped
+// scripts first.
+func skipTotals(results []*result) []jsonSkipReason {
+	byKey := map[string]*jsonSkipReason{}
+	var totals []*jsonSkipReason
+	for _, r := range results {
+		if r.tap == nil {
+			continue
+		}
+		for _, s := range r.tap.skipReasons {
+			k := s.kind + "\x00" + s.key
+			tot := byKey[k]
+			if tot == nil {
+				tot = &jsonSkipReason{Kind: s.kind, Key: s.key, Text: s.text}
+				byKey[k] = tot
+				totals = append(totals, tot)
+			}
+			tot.Tests++
+			tot.Count += s.count
+			if s.script {
+				tot.Scripts++
+			}
+		}
+	}
+	sort.SliceStable(totals, func(i, j int) bool {
+		if totals[i].Tests != totals[j].Tests {
+			return totals[i].Tests > totals[j].Tests
+		}
+		return totals[i].Count > totals[j].Count
+	})
+	var list []jsonSkipReason
+	for _, t := range totals {
+		list = append(list, *t)
+	}
+	return list
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// skipBaseline holds how much of each test, by label, was skipped in
+// an earlier run.
+type skipBaseline map[string]historyEntry
+
+// baselineFromHistory uses the most recent run of each test.
+func baselineFromHistory(h *history) skipBaseline {
+	b := skipBaseline{}
+	for l, runs := range h.runs {
+		if len(runs) > 0 {
+			b[l] = runs[len(runs)-1]
+		}
+	}
+	return b
+}
+
+// readBaseline reads the results.json of an earlier run.
+func readBaseline(dir string) (skipBaseline, error) {
+	run, err := readResults(dir)
+	if err != nil {
+		return nil, err
+	}
+	b := skipBaseline{}
+	variants := map[string]*variant{}
+	for i := range run.Tests {
+		r := run.Tests[i].result(variants)
+		b[r.label()] = historyEntryFor(r)
+	}
+	return b, nil
+}
+
+// historyEntryFor returns what the history records about a result.
+func historyEntryFor(r *result) historyEntry {
+	e := historyEntry{
+		Time:     r.start,
+		Name:     r.name,
+		Status:   r.status,
+		Duration: r.duration.Seconds(),
+
+		Signature: r.signature,
+	}
+	if r.variant != nil {
+		e.Variant = r.variant.name
+	}
+	if r.tap != nil {
+		e.Skipped = r.tap.skipped
+		e.Missing = r.tap.missing
+	}
+	return e
+}
+
+// newSkips describes the tests that skip more than they did in the
+// baseline: scripts that are skipped altogether but ran before, and
+// scripts skipping more test cases, with the prerequisites that are
+// newly missing.
+func newSkips(results []*result, base skipBaseline) []string {
+	var lines []string
+	for _, r := range results {
+		old, ok := base[r.label()]
+		if !ok || r.tap == nil || old.Status == statusCancelled {
+			continue
+		}
+		was := map[string]bool{}
+		for _, m := range old.Missing {
+			was[m] = true
+		}
+		var missing []string
+		for _, m := range r.tap.missing {
+			if !was[m] {
+				missing = append(missing, m)
+			}
+		}
+		detail := ""
+		if len(missing) > 0 {
+			detail = ", newly missing " + strings.Join(missing, ", ")
+		}
+		switch {
+		case r.status == statusSkipped && old.Status != statusSkipped:
+			reason := r.tap.skipAll
+			if reason == "" {
+				reason = "all test cases skipped"
+			}
+			lines = append(lines, fmt.Sprintf("%-20s - now skipped (%s), was %s%s", r.label(), reason, old.Status, detail))
+		case r.status != statusSkipped && r.tap.skipped > old.Skipped:
+			lines = append(lines, fmt.Sprintf("%-20s - %d test cases skipped, was %d%s", r.label(), r.tap.skipped, old.Skipped, detail))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,904 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// This file implements --slow-fs: a FUSE file system passing every
+// operation through to a directory after a delay, like a network file
+// system would. Only the parts of the protocol the tests need are
+// here; the rest gets ENOSYS, which the kernel handles gracefully.
+
+const (
+	fuseLookup      = 1
+	fuseForget      = 2
+	fuseGetattr     = 3
+	fuseSetattr     = 4
+	fuseReadlink    = 5
+	fuseSymlink     = 6
+	fuseMknod       = 8
+	fuseMkdir       = 9
+	fuseUnlink      = 10
+	fuseRmdir       = 11
+	fuseRename      = 12
+	fuseLink        = 13
+	fuseOpen        = 14
+	fuseRead        = 15
+	fuseWrite       = 16
+	fuseStatfs      = 17
+	fuseRelease     = 18
+	fuseFsync       = 20
+	fuseFlush       = 25
+	fuseInit        = 26
+	fuseOpendir     = 27
+	fuseReaddir     = 28
+	fuseReleasedir  = 29
+	fuseFsyncdir    = 30
+	fuseAccess      = 34
+	fuseCreate      = 35
+	fuseInterrupt   = 36
+	fuseDestroy     = 38
+	fuseBatchForget = 42
+	fuseRename2     = 45
+
+	fattrMode     = 1 << 0
+	fattrUID      = 1 << 1
+	fattrGID      = 1 << 2
+	fattrSize     = 1 << 3
+	fattrAtime    = 1 << 4
+	fattrMtime    = 1 << 5
+	fattrFh       = 1 << 6
+	fattrAtimeNow = 1 << 7
+	fattrMtimeNow = 1 << 8
+
+	fuseBigWrites = 1 << 5
+
+	fuseMaxWrite = 128 << 10
+
+	utimeNow  = 1<<30 - 1
+	utimeOmit = 1<<30 - 2
+)
+
+type fuseInHeader struct {
+	Len, Opcode          uint32
+	Unique, Nodeid       uint64
+	UID, GID, Pid        uint32
+	TotalExtlen, Padding uint16
+}
+
+type fuseOutHeader struct {
+	Len    uint32
+	Error  int32
+	Unique uint64
+}
+
+type fuseAttr struct {
+	Ino, Size, Blocks, Atime, Mtime, Ctime       uint64
+	Atimensec, Mtimensec, Ctimensec, Mode, Nlink uint32
+	UID, GID, Rdev, Blksize, Flags               uint32
+}
+
+type fuseEntryOut struct {
+	Nodeid, Generation, EntryValid, AttrValid uint64
+	EntryValidNsec, AttrValidNsec             uint32
+	Attr                                      fuseAttr
+}
+
+type fuseAttrOut struct {
+	AttrValid            uint64
+	AttrValidNsec, Dummy uint32
+	Attr                 fuseAttr
+}
+
+type fuseSetattrIn struct {
+	Valid, Padding                                uint32
+	Fh, Size, LockOwner, Atime, Mtime, Ctime      uint64
+	Atimensec, Mtimensec, Ctimensec, Mode, Unused uint32
+	UID, GID, Unused2                             uint32
+}
+
+type fuseMknodIn struct{ Mode, Rdev, Umask, Padding uint32 }
+
+type fuseMkdirIn struct{ Mode, Umask uint32 }
+
+type fuseRename2In struct {
+	Newdir         uint64
+	Flags, Padding uint32
+}
+
+type fuseOpenIn struct{ Flags, OpenFlags uint32 }
+
+type fuseOpenOut struct {
+	Fh                 uint64
+	OpenFlags, Padding uint32
+}
+
+type fuseCreateIn struct{ Flags, Mode, Umask, OpenFlags uint32 }
+
+// fuseIOIn is fuse_read_in as well as fuse_write_in.
+type fuseIOIn struct {
+	Fh, Offset     uint64
+	Size, IOFlags  uint32
+	LockOwner      uint64
+	Flags, Padding uint32
+}
+
+type fuseWriteOut struct{ Size, Padding uint32 }
+
+type fuseInitIn struct{ Major, Minor, MaxReadahead, Flags uint32 }
+
+type fuseInitOut struct {
+	Major, Minor, MaxReadahead, Flags  uint32
+	MaxBackground, CongestionThreshold uint16
+	MaxWrite, TimeGran                 uint32
+	MaxPages, MapAlignment             uint16
+	Flags2, MaxStackDepth              uint32
+	Unused                             [6]uint32
+}
+
+type fuseKstatfs struct {
+	Blocks, Bfree, Bavail, Files, Ffree uint64
+	Bsize, Namelen, Frsize, Padding     uint32
+	Spare                               [6]uint32
+}
+
+type fuseDirent struct {
+	Ino, Off      uint64
+	Namelen, Type uint32
+}
+
+type fuseForgetOne struct{ Nodeid, Nlookup uint64 }
+
+// hostOrder is the byte order of the FUSE protocol, which is that of
+// the host.
+var hostOrder binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		hostOrder = binary.BigEndian
+	}
+}
+
+// slowNode is a file or directory the kernel knows about. Hard links
+// give a node several names.
+type slowNode struct {
+	paths   []string
+	nlookup uint64
+}
+
+func (n *slowNode) path() string {
+	return n.paths[0]
+}
+
+type inodeKey struct {
+	dev, ino uint64
+}
+
+type slowDir struct {
+	entries []os.FileInfo
+}
+
+// slowFS serves the backing directory on the mount point, delaying
+// each request by latency.
+type slowFS struct {
+	backing, mnt string
+	latency      time.Duration
+	dev          *os.File
+
+	mu     sync.Mutex
+	nodes  map[uint64]*slowNode
+	inodes map[inodeKey]uint64
+	nextID uint64
+	dirs   map[uint64]*slowDir
+	nextFh uint64
+
+	done chan struct{}
+}
+
+// startSlowFS mounts backing on mnt, adding latency to each operation.
+func startSlowFS(backing, mnt string, latency time.Duration) (*slowFS, error) {
+	dev, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &slowFS{
+		backing: backing,
+		mnt:     mnt,
+		latency: latency,
+		dev:     dev,
+		nodes:   map[uint64]*slowNode{1: {paths: []string{backing}, nlookup: 1}},
+		inodes:  map[inodeKey]uint64{},
+		nextID:  1,
+		dirs:    map[uint64]*slowDir{},
+		done:    make(chan struct{}),
+	}
+	// Tests run as another user need allow_other, and the kernel
+	// does the permission checks, as we run as root.
+	opts := fmt.Sprintf("fd=%d,rootmode=40000,user_id=0,group_id=0,allow_other,default_permissions", dev.Fd())
+	if err := syscall.Mount("rungittest", mnt, "fuse.rungittest", syscall.MS_NOSUID|syscall.MS_NODEV, opts); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	go s.serve()
+	return s, nil
+}
+
+// stop unmounts the file system.
+func (s *slowFS) stop() error {
+	err := syscall.Unmount(s.mnt, 0)
+	if err == syscall.EBUSY {
+		err = syscall.Unmount(s.mnt, syscall.MNT_DETACH)
+	}
+	if err != nil {
+		return err
+	}
+	<-s.done
+	os.Remove(s.mnt)
+	return s.dev.Close()
+}
+
+func (s *slowFS) serve() {
+	defer close(s.done)
+	buf := make([]byte, fuseMaxWrite+64<<10)
+	for {
+		n, err := syscall.Read(int(s.dev.Fd()), buf)
+		if err == syscall.EINTR || err == syscall.ENOENT || err == syscall.EAGAIN {
+			continue
+		}
+		if err != nil {
+			if err != syscall.ENODEV {
+				log.Printf("--slow-fs: %v", err)
+			}
+			return
+		}
+		var h fuseInHeader
+		if err := binary.Read(bytes.NewReader(buf[:n]), hostOrder, &h); err != nil || int(h.Len) > n {
+			log.Printf("--slow-fs: short request")
+			return
+		}
+		body := append([]byte{}, buf[binary.Size(h):h.Len]...)
+		switch h.Opcode {
+		case fuseInit, fuseForget, fuseBatchForget, fuseInterrupt, fuseDestroy:
+			s.handle(&h, body)
+		default:
+			go func() {
+				time.Sleep(s.latency)
+				s.handle(&h, body)
+			}()
+		}
+	}
+}
+
+func (s *slowFS) reply(h *fuseInHeader, err error, out ...interface{}) {
+	var data bytes.Buffer
+	for _, o := range out {
+		if err != nil {
+			break
+		}
+		if b, ok := o.([]byte); ok {
+			data.Write(b)
+		} else {
+			binary.Write(&data, hostOrder, o)
+		}
+	}
+	oh := fuseOutHeader{Unique: h.Unique, Error: -int32(errnoOf(err))}
+	oh.Len = uint32(binary.Size(oh) + data.Len())
+	var msg bytes.Buffer
+	binary.Write(&msg, hostOrder, oh)
+	msg.Write(data.Bytes())
+	// ENOENT means the request was interrupted in the meantime.
+	if _, err := syscall.Write(int(s.dev.Fd()), msg.Bytes()); err != nil && err != syscall.ENOENT {
+		log.Printf("--slow-fs: reply: %v", err)
+	}
+}
+
+func errnoOf(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	for {
+		switch e := err.(type) {
+		case syscall.Errno:
+			return e
+		case *os.PathError:
+			err = e.Err
+		case *os.LinkError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		default:
+			return syscall.EIO
+		}
+	}
+}
+
+// names splits a request body into its NUL terminated strings.
+func names(body []byte) []string {
+	return strings.Split(strings.TrimSuffix(string(body), "\x00"), "\x00")
+}
+
+func (s *slowFS) nodePath(id uint64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.nodes[id]
+	if n == nil {
+		return "", syscall.ESTALE
+	}
+	return n.path(), nil
+}
+
+func (s *slowFS) childPath(parent uint64, name string) (string, error) {
+	p, err := s.nodePath(parent)
+	if err != nil {
+		return "", err
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return "", syscall.EINVAL
+	}
+	return filepath.Join(p, name), nil
+}
+
+func toAttr(st *syscall.Stat_t) fuseAttr {
+	return fuseAttr{
+		Ino:       st.Ino,
+		Size:      uint64(st.Size),
+		Blocks:    uint64(st.Blocks),
+		Atime:     uint64(st.Atim.Sec),
+		Atimensec: uint32(st.Atim.Nsec),
+		Mtime:     uint64(st.Mtim.Sec),
+		Mtimensec: uint32(st.Mtim.Nsec),
+		Ctime:     uint64(st.Ctim.Sec),
+		Ctimensec: uint32(st.Ctim.Nsec),
+		Mode:      st.Mode,
+		Nlink:     uint32(st.Nlink),
+		UID:       st.Uid,
+		GID:       st.Gid,
+		Rdev:      uint32(st.Rdev),
+		Blksize:   uint32(st.Blksize),
+	}
+}
+
+// entry looks up the node for path, which the kernel gets a reference
+// to.
+func (s *slowFS) entry(path string) (*fuseEntryOut, error) {
+	if path == s.mnt {
+		// The mount point is inside the backing dir; don't
+		// recurse into ourselves.
+		return nil, syscall.ENOENT
+	}
+	var st syscall.Stat_t
+	if err := syscall.Lstat(path, &st); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := inodeKey{uint64(st.Dev), st.Ino}
+	id, ok := s.inodes[key]
+	if !ok {
+		s.nextID++
+		id = s.nextID
+		s.inodes[key] = id
+		s.nodes[id] = &slowNode{}
+	}
+	n := s.nodes[id]
+	n.nlookup++
+	known := false
+	for _, p := range n.paths {
+		known = known || p == path
+	}
+	if !known {
+		n.paths = append([]string{path}, n.paths...)
+	}
+	return &fuseEntryOut{Nodeid: id, Attr: toAttr(&st)}, nil
+}
+
+func (s *slowFS) forget(id, nlookup uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.nodes[id]
+	if n == nil || id == 1 {
+		return
+	}
+	if n.nlookup > nlookup {
+		n.nlookup -= nlookup
+		return
+	}
+	delete(s.nodes, id)
+	for k, v := range s.inodes {
+		if v == id {
+			delete(s.inodes, k)
+			break
+		}
+	}
+}
+
+// removed drops path from the names of its node.
+func (s *slowFS) removed(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.nodes {
+		for i, p := range n.paths {
+			if p == path && len(n.paths) > 1 {
+				n.paths = append(n.paths[:i:i], n.paths[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// renamed updates the names of the nodes at and below old.
+func (s *slowFS) renamed(old, new string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.nodes {
+		for i, p := range n.paths {
+			if p == old {
+				n.paths[i] = new
+			} else if strings.HasPrefix(p, old+"/") {
+				n.paths[i] = new + p[len(old):]
+			}
+		}
+	}
+}
+
+// created gives a new file to the user creating it, with exactly the
+// mode requested; the kernel applied the umask already.
+func created(path string, h *fuseInHeader, mode uint32) error {
+	if err := syscall.Lchown(path, int(h.UID), int(h.GID)); err != nil {
+		return err
+	}
+	if mode&syscall.S_IFMT == syscall.S_IFLNK {
+		return nil
+	}
+	return syscall.Chmod(path, mode&07777)
+}
+
+func (s *slowFS) attr(path string) (*fuseAttrOut, error) {
+	var st syscall.Stat_t
+	if err := syscall.Lstat(path, &st); err != nil {
+		return nil, err
+	}
+	return &fuseAttrOut{Attr: toAttr(&st)}, nil
+}
+
+func (s *slowFS) handle(h *fuseInHeader, body []byte) {
+	in := bytes.NewReader(body)
+	read := func(v interface{}) error {
+		if err := binary.Read(in, hostOrder, v); err != nil {
+			return syscall.EINVAL
+		}
+		return nil
+	}
+	rest := func() []byte {
+		return body[len(body)-in.Len():]
+	}
+
+	switch h.Opcode {
+	case fuseInit:
+		var ii fuseInitIn
+		if err := read(&ii); err != nil || ii.Major != 7 {
+			s.reply(h, syscall.EPROTO)
+			return
+		}
+		s.reply(h, nil, &fuseInitOut{
+			Major:        7,
+			Minor:        31,
+			MaxReadahead: ii.MaxReadahead,
+			Flags:        ii.Flags & fuseBigWrites,
+			MaxWrite:     fuseMaxWrite,
+			TimeGran:     1,
+		})
+	case fuseDestroy:
+		s.reply(h, nil)
+	case fuseInterrupt:
+	case fuseForget:
+		var n uint64
+		if read(&n) == nil {
+			s.forget(h.Nodeid, n)
+		}
+	case fuseBatchForget:
+		var count [2]uint32
+		if read(&count) != nil {
+			return
+		}
+		for i := uint32(0); i < count[0]; i++ {
+			var f fuseForgetOne
+			if read(&f) != nil {
+				return
+			}
+			s.forget(f.Nodeid, f.Nlookup)
+		}
+
+	case fuseLookup:
+		p, err := s.childPath(h.Nodeid, names(body)[0])
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		e, err := s.entry(p)
+		s.reply(h, err, e)
+	case fuseGetattr:
+		p, err := s.nodePath(h.Nodeid)
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		a, err := s.attr(p)
+		s.reply(h, err, a)
+	case fuseSetattr:
+		var sa fuseSetattrIn
+		p, err := s.nodePath(h.Nodeid)
+		if err == nil {
+			err = read(&sa)
+		}
+		if err == nil {
+			err = s.setattr(p, &sa)
+		}
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		a, err := s.attr(p)
+		s.reply(h, err, a)
+	case fuseReadlink:
+		p, err := s.nodePath(h.Nodeid)
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		target, err := os.Readlink(p)
+		s.reply(h, err, []byte(target))
+	case fuseSymlink:
+		ns := names(body)
+		if len(ns) != 2 {
+			s.reply(h, syscall.EINVAL)
+			return
+		}
+		p, err := s.childPath(h.Nodeid, ns[0])
+		if err == nil {
+			err = syscall.Symlink(ns[1], p)
+		}
+		if err == nil {
+			err = created(p, h, syscall.S_IFLNK)
+		}
+		s.replyEntry(h, p, err)
+	case fuseMknod:
+		var mi fuseMknodIn
+		err := read(&mi)
+		p := ""
+		if err == nil {
+			p, err = s.childPath(h.Nodeid, names(rest())[0])
+		}
+		if err == nil {
+			err = syscall.Mknod(p, mi.Mode, int(mi.Rdev))
+		}
+		if err == nil {
+			err = created(p, h, mi.Mode)
+		}
+		s.replyEntry(h, p, err)
+	case fuseMkdir:
+		var mi fuseMkdirIn
+		err := read(&mi)
+		p := ""
+		if err == nil {
+			p, err = s.childPath(h.Nodeid, names(rest())[0])
+		}
+		if err == nil {
+			err = syscall.Mkdir(p, mi.Mode&07777)
+		}
+		if err == nil {
+			err = created(p, h, mi.Mode)
+		}
+		s.replyEntry(h, p, err)
+	case fuseUnlink, fuseRmdir:
+		p, err := s.childPath(h.Nodeid, names(body)[0])
+		if err == nil {
+			if h.Opcode == fuseUnlink {
+				err = syscall.Unlink(p)
+			} else {
+				err = syscall.Rmdir(p)
+			}
+		}
+		if err == nil {
+			s.removed(p)
+		}
+		s.reply(h, err)
+	case fuseRename, fuseRename2:
+		var ri fuseRename2In
+		var err error
+		if h.Opcode == fuseRename {
+			err = read(&ri.Newdir)
+		} else {
+			err = read(&ri)
+		}
+		if err == nil && ri.Flags != 0 {
+			err = syscall.ENOSYS
+		}
+		ns := names(rest())
+		if err == nil && len(ns) != 2 {
+			err = syscall.EINVAL
+		}
+		var from, to string
+		if err == nil {
+			from, err = s.childPath(h.Nodeid, ns[0])
+		}
+		if err == nil {
+			to, err = s.childPath(ri.Newdir, ns[1])
+		}
+		if err == nil {
+			err = syscall.Rename(from, to)
+		}
+		if err == nil {
+			s.removed(to)
+			s.renamed(from, to)
+		}
+		s.reply(h, err)
+	case fuseLink:
+		var old uint64
+		err := read(&old)
+		var from, to string
+		if err == nil {
+			from, err = s.nodePath(old)
+		}
+		if err == nil {
+			to, err = s.childPath(h.Nodeid, names(rest())[0])
+		}
+		if err == nil {
+			err = syscall.Link(from, to)
+		}
+		s.replyEntry(h, to, err)
+
+	case fuseOpen:
+		var oi fuseOpenIn
+		p, err := s.nodePath(h.Nodeid)
+		if err == nil {
+			err = read(&oi)
+		}
+		fd := -1
+		if err == nil {
+			fd, err = syscall.Open(p, int(oi.Flags)&^(syscall.O_CREAT|syscall.O_EXCL|syscall.O_NOCTTY)|syscall.O_CLOEXEC, 0)
+		}
+		s.reply(h, err, &fuseOpenOut{Fh: uint64(fd)})
+	case fuseCreate:
+		var ci fuseCreateIn
+		err := read(&ci)
+		p := ""
+		if err == nil {
+			p, err = s.childPath(h.Nodeid, names(rest())[0])
+		}
+		fd := -1
+		if err == nil {
+			fd, err = syscall.Open(p, int(ci.Flags)|syscall.O_CREAT|syscall.O_CLOEXEC, ci.Mode&07777)
+		}
+		if err == nil {
+			if err = created(p, h, ci.Mode); err != nil {
+				syscall.Close(fd)
+			}
+		}
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		e, err := s.entry(p)
+		if err != nil {
+			syscall.Close(fd)
+		}
+		s.reply(h, err, e, &fuseOpenOut{Fh: uint64(fd)})
+	case fuseRead:
+		var ri fuseIOIn
+		if err := read(&ri); err != nil {
+			s.reply(h, err)
+			return
+		}
+		if ri.Size > fuseMaxWrite {
+			ri.Size = fuseMaxWrite
+		}
+		buf := make([]byte, ri.Size)
+		n, err := syscall.Pread(int(ri.Fh), buf, int64(ri.Offset))
+		if err != nil {
+			n = 0
+		}
+		s.reply(h, err, buf[:n])
+	case fuseWrite:
+		var wi fuseIOIn
+		if err := read(&wi); err != nil {
+			s.reply(h, err)
+			return
+		}
+		data := rest()
+		if int(wi.Size) < len(data) {
+			data = data[:wi.Size]
+		}
+		n, err := syscall.Pwrite(int(wi.Fh), data, int64(wi.Offset))
+		s.reply(h, err, &fuseWriteOut{Size: uint32(n)})
+	case fuseRelease:
+		var fh uint64
+		err := read(&fh)
+		if err == nil {
+			err = syscall.Close(int(fh))
+		}
+		s.reply(h, err)
+	case fuseFsync:
+		var fh uint64
+		err := read(&fh)
+		if err == nil {
+			err = syscall.Fsync(int(fh))
+		}
+		s.reply(h, err)
+	case fuseFlush, fuseFsyncdir:
+		s.reply(h, nil)
+	case fuseAccess:
+		// default_permissions has the kernel check access.
+		s.reply(h, nil)
+	case fuseStatfs:
+		var st syscall.Statfs_t
+		err := syscall.Statfs(s.backing, &st)
+		s.reply(h, err, &fuseKstatfs{
+			Blocks:  st.Blocks,
+			Bfree:   st.Bfree,
+			Bavail:  st.Bavail,
+			Files:   st.Files,
+			Ffree:   st.Ffree,
+			Bsize:   uint32(st.Bsize),
+			Namelen: uint32(st.Namelen),
+			Frsize:  uint32(st.Frsize),
+		})
+
+	case fuseOpendir:
+		p, err := s.nodePath(h.Nodeid)
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		entries, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		s.mu.Lock()
+		s.nextFh++
+		fh := s.nextFh
+		s.dirs[fh] = &slowDir{entries: entries}
+		s.mu.Unlock()
+		s.reply(h, nil, &fuseOpenOut{Fh: fh})
+	case fuseReaddir:
+		var ri fuseIOIn
+		if err := read(&ri); err != nil {
+			s.reply(h, err)
+			return
+		}
+		s.mu.Lock()
+		d := s.dirs[ri.Fh]
+		s.mu.Unlock()
+		if d == nil {
+			s.reply(h, syscall.EBADF)
+			return
+		}
+		s.reply(h, nil, readdir(d, ri.Offset, int(ri.Size)))
+	case fuseReleasedir:
+		var fh uint64
+		if err := read(&fh); err != nil {
+			s.reply(h, err)
+			return
+		}
+		s.mu.Lock()
+		delete(s.dirs, fh)
+		s.mu.Unlock()
+		s.reply(h, nil)
+
+	default:
+		s.reply(h, syscall.ENOSYS)
+	}
+}
+
+func (s *slowFS) replyEntry(h *fuseInHeader, path string, err error) {
+	if err != nil {
+		s.reply(h, err)
+		return
+	}
+	e, err := s.entry(path)
+	s.reply(h, err, e)
+}
+
+func (s *slowFS) setattr(p string, sa *fuseSetattrIn) error {
+	if sa.Valid&fattrMode != 0 {
+		if err := syscall.Chmod(p, sa.Mode&07777); err != nil {
+			return err
+		}
+	}
+	if sa.Valid&(fattrUID|fattrGID) != 0 {
+		uid, gid := -1, -1
+		if sa.Valid&fattrUID != 0 {
+			uid = int(sa.UID)
+		}
+		if sa.Valid&fattrGID != 0 {
+			gid = int(sa.GID)
+		}
+		if err := syscall.Lchown(p, uid, gid); err != nil {
+			return err
+		}
+	}
+	if sa.Valid&fattrSize != 0 {
+		var err error
+		if sa.Valid&fattrFh != 0 {
+			err = syscall.Ftruncate(int(sa.Fh), int64(sa.Size))
+		} else {
+			err = syscall.Truncate(p, int64(sa.Size))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if sa.Valid&(fattrAtime|fattrMtime) != 0 {
+		ts := []syscall.Timespec{{Nsec: utimeOmit}, {Nsec: utimeOmit}}
+		if sa.Valid&fattrAtimeNow != 0 {
+			ts[0] = syscall.Timespec{Nsec: utimeNow}
+		} else if sa.Valid&fattrAtime != 0 {
+			ts[0] = syscall.Timespec{Sec: int64(sa.Atime), Nsec: int64(sa.Atimensec)}
+		}
+		if sa.Valid&fattrMtimeNow != 0 {
+			ts[1] = syscall.Timespec{Nsec: utimeNow}
+		} else if sa.Valid&fattrMtime != 0 {
+			ts[1] = syscall.Timespec{Sec: int64(sa.Mtime), Nsec: int64(sa.Mtimensec)}
+		}
+		if err := syscall.UtimesNano(p, ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readdir encodes the directory entries from offset on, as far as they
+// fit in size bytes. The offset of an entry is its index plus one.
+func readdir(d *slowDir, offset uint64, size int) []byte {
+	var buf bytes.Buffer
+	for i := int(offset); i < len(d.entries); i++ {
+		fi := d.entries[i]
+		st, _ := fi.Sys().(*syscall.Stat_t)
+		de := fuseDirent{Off: uint64(i + 1), Namelen: uint32(len(fi.Name()))}
+		if st != nil {
+			de.Ino = st.Ino
+			de.Type = (st.Mode & syscall.S_IFMT) >> 12
+		}
+		n := binary.Size(de) + len(fi.Name())
+		pad := (8 - n%8) % 8
+		if buf.Len()+n+pad > size {
+			break
+		}
+		binary.Write(&buf, hostOrder, &de)
+		buf.WriteString(fi.Name())
+		buf.Write(make([]byte, pad))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,159 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// shard is a list of tests for one CI job.
+type shard struct {
+	tests    []string
+	estimate time.Duration
+}
+
+// splitByDuration distributes the tests over n shards so the
+// estimated durations are balanced, assigning the longest tests first
+// to the least loaded shard. Each shard keeps the order of tests.
+func splitByDuration(tests []string, n int, h *history) []shard {
+	def := h.medianDuration(defaultEstimate)
+	est := map[string]time.Duration{}
+	for _, t := range tests {
+		d, ok := h.duration(t)
+		if !ok {
+			d = def
+		}
+		est[t] = d
+	}
+	byDuration := append([]string{}, tests...)
+	sort.SliceStable(byDuration, func(i, j int) bool {
+		return est[byDuration[i]] > est[byDuration[j]]
+	})
+	shards := make([]shard, n)
+	assigned := map[string]int{}
+	for _, t := range byDuration {
+		min := 0
+		for i := range shards {
+			if shards[i].estimate < shards[min].estimate {
+				min = i
+			}
+		}
+		shards[min].estimate += est[t]
+		assigned[t] = min
+	}
+	for _, t := range tests {
+		k := assigned[t]
+		shards[k].tests = append(shards[k].tests, t)
+	}
+	return shards
+}
+
+// splitByCount deals the tests round-robin over n shards.
+func splitByCount(tests []string, n int) []shard {
+	shards := make([]shard, n)
+	for i, t := range tests {
+		shards[i%n].tests = append(shards[i%n].tests, t)
+	}
+	return shards
+}
+
+func splitMain(args []string) {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	n := fs.Int("shards", 2, "number of shards")
+	by := fs.String("by", "duration", "balance the shards by duration (from the history) or count")
+	only := fs.Int("shard", 0, "print only the tests of this shard (1-based), one per line")
+	out := fs.String("out", "", "write shard-K.txt files to this directory instead of printing")
+	chdir := fs.String("chdir", "", "change to this directory before expanding globs")
+	skipTests := fs.String("skip-tests", "", "GIT_SKIP_TESTS style patterns of tests to skip")
+	historyFile := fs.String("history", defaultHistoryPath(), "history of past runs, for --by=duration")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rungittest split [flags] GLOB...\n\n"+
+			"Splits the tests into balanced lists for CI systems that run the shards\n"+
+			"as separate jobs. Nothing is run.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || *n < 1 || *only < 0 || *only > *n {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *chdir != "" {
+		if err := os.Chdir(*chdir); err != nil {
+			log.Fatalf("chdir: %v", err)
+		}
+	}
+	tests, err := selectTests(fs.Args(), strings.Fields(*skipTests))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var shards []shard
+	switch *by {
+	case "duration":
+		h := &history{runs: map[string][]historyEntry{}}
+		if *historyFile != "" {
+			if h, err = readHistory(*historyFile); err != nil {
+				log.Fatalf("--history: %v", err)
+			}
+		}
+		shards = splitByDuration(tests, *n, h)
+	case "count":
+		shards = splitByCount(tests, *n)
+	default:
+		log.Fatalf("--by must be duration or count")
+	}
+
+	if *only > 0 {
+		for _, t := range shards[*only-1].tests {
+			fmt.Println(t)
+		}
+		return
+	}
+	for i, s := range shards {
+		header := fmt.Sprintf("shard %d/%d: %d tests", i+1, *n, len(s.tests))
+		if *by == "duration" {
+			header += fmt.Sprintf(", about %s", s.estimate.Round(time.Second))
+		}
+		if *out == "" {
+			fmt.Printf("# %s\n%s", header, strings.Join(s.tests, "\n"))
+			if len(s.tests) > 0 {
+				fmt.Println()
+			}
+			continue
+		}
+		if i == 0 {
+			if err := os.MkdirAll(*out, 0755); err != nil {
+				log.Fatal(err)
+			}
+		}
+		name := filepath.Join(*out, fmt.Sprintf("shard-%d.txt", i+1))
+		data := ""
+		for _, t := range s.tests {
+			data += t + "\n"
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: %s\n", name, header)
+	}
+}
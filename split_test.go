@@ -0,0 +1,89 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitByDuration(t *testing.T) {
+	h := testHistory(map[string]float64{"a": 100, "b": 60, "c": 50, "d": 40, "e": 10})
+	for _, c := range []struct {
+		tests     []string
+		n         int
+		shards    [][]string
+		estimates []time.Duration
+	}{
+		{
+			tests:     []string{"a", "b", "c", "d", "e"},
+			n:         2,
+			shards:    [][]string{{"a", "d"}, {"b", "c", "e"}},
+			estimates: []time.Duration{140 * time.Second, 120 * time.Second},
+		},
+		{
+			// The shards keep the order of the tests.
+			tests:     []string{"e", "d", "c", "b", "a"},
+			n:         3,
+			shards:    [][]string{{"a"}, {"e", "b"}, {"d", "c"}},
+			estimates: []time.Duration{100 * time.Second, 70 * time.Second, 90 * time.Second},
+		},
+		{
+			// Unknown tests take the median, 50s.
+			tests:     []string{"x", "y", "a"},
+			n:         2,
+			shards:    [][]string{{"a"}, {"x", "y"}},
+			estimates: []time.Duration{100 * time.Second, 100 * time.Second},
+		},
+		{
+			tests:     []string{"a"},
+			n:         2,
+			shards:    [][]string{{"a"}, nil},
+			estimates: []time.Duration{100 * time.Second, 0},
+		},
+	} {
+		shards := splitByDuration(c.tests, c.n, h)
+		var tests [][]string
+		var estimates []time.Duration
+		for _, s := range shards {
+			tests = append(tests, s.tests)
+			estimates = append(estimates, s.estimate)
+		}
+		if !reflect.DeepEqual(tests, c.shards) || !reflect.DeepEqual(estimates, c.estimates) {
+			t.Errorf("splitByDuration(%v, %d) = %v %v, want %v %v", c.tests, c.n, tests, estimates, c.shards, c.estimates)
+		}
+	}
+}
+
+func TestSplitByCount(t *testing.T) {
+	for _, c := range []struct {
+		tests  []string
+		n      int
+		shards [][]string
+	}{
+		{[]string{"a", "b", "c", "d", "e"}, 2, [][]string{{"a", "c", "e"}, {"b", "d"}}},
+		{[]string{"a", "b"}, 3, [][]string{{"a"}, {"b"}, nil}},
+		{[]string{"a", "b"}, 1, [][]string{{"a", "b"}}},
+	} {
+		var got [][]string
+		for _, s := range splitByCount(c.tests, c.n) {
+			got = append(got, s.tests)
+		}
+		if !reflect.DeepEqual(got, c.shards) {
+			t.Errorf("splitByCount(%v, %d) = %v, want %v", c.tests, c.n, got, c.shards)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// exactLimit is the sample size up to which mannWhitney computes the
+// exact distribution of U.
+const exactLimit = 50
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U
+// test for the hypothesis that a and b come from the same
+// distribution.
+func mannWhitney(a, b []float64) float64 {
+	m, n := len(a), len(b)
+	if m == 0 || n == 0 {
+		return 1
+	}
+
+	type obs struct {
+		v     float64
+		fromA bool
+	}
+	var all []obs
+	for _, v := range a {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range b {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Assign ranks, averaging over ties.
+	var rankA, tieCorrection float64
+	ties := false
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankA += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieCorrection += t*t*t - t
+		}
+		i = j
+	}
+	u := rankA - float64(m*(m+1))/2
+
+	if !ties && m <= exactLimit && n <= exactLimit {
+		return exactMannWhitney(int(u), m, n)
+	}
+
+	// Normal approximation with tie and continuity correction.
+	N := float64(m + n)
+	mean := float64(m*n) / 2
+	variance := float64(m*n) / 12 * ((N + 1) - tieCorrection/(N*(N-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		z = 0
+	}
+	return math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// exactMannWhitney computes the two-sided p-value for U = u from the
+// exact null distribution of U for sample sizes m and n.
+func exactMannWhitney(u, m, n int) float64 {
+	// counts[i][j][k] would be the number of arrangements of i
+	// a's and j b's with U = k; we only keep one row of i.
+	prev := make([][]float64, n+1)
+	for j := range prev {
+		prev[j] = []float64{1}
+	}
+	for i := 1; i <= m; i++ {
+		cur := make([][]float64, n+1)
+		cur[0] = []float64{1}
+		for j := 1; j <= n; j++ {
+			// The largest element is either an a, which is
+			// larger than all j b's, or a b.
+			cur[j] = make([]float64, i*j+1)
+			for k, c := range prev[j] {
+				cur[j][k+j] += c
+			}
+			for k, c := range cur[j-1] {
+				cur[j][k] += c
+			}
+		}
+		prev = cur
+	}
+	dist := prev[n]
+
+	var total, le, ge float64
+	for k, c := range dist {
+		total += c
+		if k <= u {
+			le += c
+		}
+		if k >= u {
+			ge += c
+		}
+	}
+	return math.Min(1, 2*math.Min(le, ge)/total)
+}
@@ -0,0 +1,43 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "path/filepath"
+
+// resultStore keeps the results of a run. The CLI writes them to the
+// output dir; tests use a fake in memory.
+type resultStore interface {
+	// save replaces the stored results with rr.
+	save(rr *runResults) error
+
+	// load reads back the stored results. It returns an error
+	// satisfying os.IsNotExist if there are none.
+	load() (*jsonRun, error)
+}
+
+// dirStore stores results as summary.txt and results.json in an
+// output dir.
+type dirStore string
+
+func (d dirStore) save(rr *runResults) error {
+	if err := rr.writeSummary(filepath.Join(string(d), "summary.txt")); err != nil {
+		return err
+	}
+	return rr.writeResults(filepath.Join(string(d), "results.json"))
+}
+
+func (d dirStore) load() (*jsonRun, error) {
+	return readResults(string(d))
+}
@@ -0,0 +1,407 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// line formats the result for the progress output and summary.txt.
+func (r *result) line() string {
+	summary := r.summary
+	if r.previous != nil {
+		summary = fmt.Sprintf("%s (attempt %d, was %s)", summary, r.attempt+1, r.previous.status)
+	}
+	if r.known() {
+		summary += " [known issue]"
+	}
+	return fmt.Sprintf("%-20s - %-60s ", r.label(), summary)
+}
+
+// formatMissing lists prerequisites with the number of tests that
+// needed them, most frequently missing first.
+func formatMissing(missing map[string][]string) string {
+	var keys []string
+	for k := range missing {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(missing[keys[i]]) != len(missing[keys[j]]) {
+			return len(missing[keys[i]]) > len(missing[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+	var lines []string
+	for _, k := range keys {
+		ts := missing[k]
+		sort.Strings(ts)
+		lines = append(lines, fmt.Sprintf("%-20s %3d: %s", k, len(ts), strings.Join(ts, " ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runResults holds the results of a (possibly partial) run.
+type runResults struct {
+	results []*result
+	elapsed time.Duration
+
+	// notRun is the number of tests that were never started.
+	notRun int
+
+	// aborted is the reason the run was aborted, if it was.
+	aborted string
+
+	// notes are extra lines for the header.
+	notes []string
+
+	// leftOut are the tests that were not run to meet the deadline.
+	leftOut []string
+
+	// partial is set if tests were left out or stopped to meet the
+	// deadline.
+	partial bool
+
+	// duplicates are the tests that ran in more than one of the
+	// merged shards.
+	duplicates []string
+
+	// newSkips are the tests skipping more than in the baseline.
+	newSkips []string
+
+	// args and start are those of a finished run read back from
+	// results.json; for the current run they are unset.
+	args  []string
+	start time.Time
+
+	// id is the ID of the run, see newRunID.
+	id string
+
+	// appended are the IDs of the earlier runs whose results were
+	// kept by --append.
+	appended []string
+}
+
+// summaryVersion is the version of the summary.txt format. It is
+// incremented when a line or section changes in a way that breaks a
+// script reading the file; adding a header field or a section does
+// not change it.
+const summaryVersion = 2
+
+// summaryText renders summary.txt. The format is:
+//
+//	# rungittest summary VERSION
+//	# KEY: VALUE
+//	...
+//
+//	# failed N:
+//	LABEL - SUMMARY
+//		CONTINUATION
+//
+//	# SECTION N:
+//	...
+//
+// The header is a run of "# KEY: VALUE" lines. Sections are separated
+// by an empty line and start with "# TITLE N:" or "# TITLE:". The
+// "failed" section comes first and is always present, if empty. Lines
+// of a section that start with a tab or spaces continue the line
+// before. Readers should skip header keys and sections they do not
+// know.
+func (rr *runResults) summaryText() string {
+	var failed, skipped, cancelled, oom, leaks, suspects, fixed, mismatches, silent, recurring []string
+	missing := map[string][]string{}
+	for _, r := range rr.results {
+		if c := r.counts; c != nil {
+			if c.fixed > 0 {
+				fixed = append(fixed, fmt.Sprintf("%-20s - %d fixed, %d still broken", r.label(), c.fixed, c.broken))
+			}
+			if r.tap != nil && r.status != statusCancelled {
+				if d := c.check(r.tap); d != "" {
+					mismatches = append(mismatches, fmt.Sprintf("%-20s - %s", r.label(), d))
+				}
+			}
+		}
+		for _, l := range r.leaks {
+			leaks = append(leaks, fmt.Sprintf("%-20s - %s", r.label(), l))
+		}
+		if r.silent {
+			silent = append(silent, fmt.Sprintf("%-20s - longest silence %s", r.label(), r.maxSilence.Round(time.Second)))
+		}
+		if r.recurrence != "" {
+			recurring = append(recurring, fmt.Sprintf("%-20s - %s\n\t%s", r.label(), r.signature, r.recurrence))
+		}
+		switch {
+		case r.status == statusOOM:
+			oom = append(oom, r.line())
+		case r.failed():
+			l := r.line()
+			if r.verboseLog != "" {
+				l += "\n\tverbose log: " + r.verboseLog
+			}
+			failed = append(failed, l)
+		case r.status == statusSkipped:
+			skipped = append(skipped, r.line())
+		case r.status == statusCancelled:
+			cancelled = append(cancelled, r.line())
+		case r.status == statusSuspect:
+			l := r.line()
+			if r.previous != nil && len(r.previous.concurrent) > 0 {
+				l += "\n\tran alongside: " + strings.Join(r.previous.concurrent, ", ")
+			}
+			suspects = append(suspects, l)
+		}
+		if r.tap != nil {
+			for _, m := range r.tap.missing {
+				missing[m] = append(missing[m], r.label())
+			}
+		}
+	}
+	sort.Strings(failed)
+	sort.Strings(skipped)
+	sort.Strings(cancelled)
+	sort.Strings(oom)
+	sort.Strings(leaks)
+	sort.Strings(suspects)
+	sort.Strings(fixed)
+	sort.Strings(mismatches)
+	sort.Strings(silent)
+	sort.Strings(recurring)
+
+	header := []string{
+		fmt.Sprintf("rungittest summary %d", summaryVersion),
+		fmt.Sprintf("args: %s", strings.Join(rr.argsOrSelf(), " ")),
+	}
+	if rr.id != "" {
+		header = append(header, "run ID: "+rr.id)
+	}
+	if len(rr.appended) > 0 {
+		header = append(header, "earlier runs: "+strings.Join(rr.appended, ", "))
+	}
+	header = append(header,
+		"written: "+time.Now().Format(time.RFC3339),
+		fmt.Sprintf("elapsed: %s", rr.elapsed))
+	if rr.aborted != "" {
+		header = append(header, "aborted: "+rr.aborted)
+	}
+	if rr.notRun > 0 {
+		header = append(header, fmt.Sprintf("not run: %d", rr.notRun))
+	}
+	for _, n := range rr.notes {
+		header = append(header, "note: "+n)
+	}
+	blocks := []string{"# " + strings.Join(header, "\n# ")}
+
+	// The failures are always listed, so that "no failures" can be
+	// told apart from a truncated file.
+	blocks = append(blocks, fmt.Sprintf("# failed %d:", len(failed)))
+	if len(failed) > 0 {
+		blocks[len(blocks)-1] += "\n" + strings.Join(failed, "\n")
+	}
+	section := func(title string, lines []string) {
+		if len(lines) > 0 {
+			blocks = append(blocks, fmt.Sprintf("# %s %d:\n%s", title, len(lines), strings.Join(lines, "\n")))
+		}
+	}
+	var sels []string
+	for _, c := range bySelection(rr.results) {
+		sels = append(sels, c.line())
+	}
+	section("by selection", sels)
+	section("recurring or known failures", recurring)
+	section("interference suspects", suspects)
+	section("silent for more than --silence-timeout (likely hung)", silent)
+	section("OOM-killed", oom)
+	section("cancelled", cancelled)
+	section("run in more than one shard", rr.duplicates)
+	section("left out by --deadline", rr.leftOut)
+	section("newly skipped", rr.newSkips)
+	section("skipped", skipped)
+	if len(missing) > 0 {
+		blocks = append(blocks, "# missing prerequisites:\n"+formatMissing(missing))
+	}
+	section("known breakages fixed", fixed)
+	section("test-results counts differ from TAP", mismatches)
+	section("leaks", leaks)
+	section("most disk I/O", mostIO(rr.results))
+	if v := rr.variantSummary(); v != "" {
+		blocks = append(blocks, "# per variant:\n"+v)
+	}
+	if v := rr.variantSpecific(); v != "" {
+		blocks = append(blocks, "# failing only under some variants:\n"+v)
+	}
+	if v := imageComparison(rr.results); v != "" {
+		blocks = append(blocks, "# per image:\n"+v)
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+// argsOrSelf returns the command line of the run, or that of this
+// process if it is not known.
+func (rr *runResults) argsOrSelf() []string {
+	if len(rr.args) > 0 {
+		return rr.args
+	}
+	return os.Args
+}
+
+func (rr *runResults) writeSummary(path string) error {
+	return ioutil.WriteFile(path, []byte(rr.summaryText()), 0644)
+}
+
+// variantSummary returns per-variant counts and failures, or "" if
+// there are no variants.
+func (rr *runResults) variantSummary() string {
+	type counts struct {
+		total, ok, skipped int
+		failed             []string
+	}
+	byVariant := map[string]*counts{}
+	var names []string
+	for _, r := range rr.results {
+		if r.variant == nil || r.variant.groupName() == "" {
+			continue
+		}
+		key := r.variant.groupName()
+		c := byVariant[key]
+		if c == nil {
+			c = &counts{}
+			byVariant[key] = c
+			names = append(names, key)
+		}
+		c.total++
+		switch {
+		case r.status == statusOK:
+			c.ok++
+		case r.status == statusSkipped:
+			c.skipped++
+		case r.failed():
+			c.failed = append(c.failed, r.name)
+		}
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, n := range names {
+		c := byVariant[n]
+		sort.Strings(c.failed)
+		lines = append(lines, fmt.Sprintf("%s: %d tests, %d ok, %d failed, %d skipped",
+			n, c.total, c.ok, len(c.failed), c.skipped))
+		for i := 0; i < len(c.failed); {
+			j := i
+			for j < len(c.failed) && c.failed[j] == c.failed[i] {
+				j++
+			}
+			if j-i > 1 {
+				lines = append(lines, fmt.Sprintf("  %s (%dx)", c.failed[i], j-i))
+			} else {
+				lines = append(lines, "  "+c.failed[i])
+			}
+			i = j
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// blame returns the matrix settings shared by all failing variants
+// and none of the passing ones, or the names of the failing variants
+// if there are none.
+func blame(failed, passed []*variant) string {
+	var common []string
+	for _, p := range failed[0].parts {
+		inAll := true
+		for _, f := range failed[1:] {
+			if !hasPart(f, p) {
+				inAll = false
+			}
+		}
+		for _, v := range passed {
+			if hasPart(v, p) {
+				inAll = false
+			}
+		}
+		if inAll {
+			common = append(common, p)
+		}
+	}
+	if len(common) > 0 {
+		return strings.Join(common, ",")
+	}
+	var names []string
+	for _, f := range failed {
+		names = append(names, f.name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func hasPart(v *variant, p string) bool {
+	for _, q := range v.parts {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+// variantSpecific lists the tests that fail under some variants but
+// pass under others, grouped by the settings they fail under.
+func (rr *runResults) variantSpecific() string {
+	type outcome struct {
+		failed, passed []*variant
+	}
+	byTest := map[string]*outcome{}
+	for _, r := range rr.results {
+		if r.variant == nil || r.status == statusCancelled {
+			continue
+		}
+		o := byTest[r.name]
+		if o == nil {
+			o = &outcome{}
+			byTest[r.name] = o
+		}
+		if r.failed() {
+			o.failed = append(o.failed, r.variant)
+		} else {
+			o.passed = append(o.passed, r.variant)
+		}
+	}
+
+	groups := map[string][]string{}
+	for name, o := range byTest {
+		if len(o.failed) == 0 || len(o.passed) == 0 {
+			continue
+		}
+		key := blame(o.failed, o.passed)
+		groups[key] = append(groups[key], name)
+	}
+	var keys []string
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, k := range keys {
+		sort.Strings(groups[k])
+		lines = append(lines, fmt.Sprintf("only under %s:", k))
+		for _, n := range groups[k] {
+			lines = append(lines, "  "+n)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,221 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tapResult summarizes the TAP output of a single test script.
+type tapResult struct {
+	planned int
+	hasPlan bool
+
+	passed  int
+	failed  int
+	skipped int
+	todo    int
+
+	// skipAll is the reason given for skipping the whole script.
+	skipAll string
+
+	// missing holds the prerequisites that caused tests to be skipped.
+	missing []string
+
+	// skipReasons are why tests were skipped, see skipReason.
+	skipReasons []*skipReason
+
+	// satisfied and unsatisfied are the lazy prerequisites that
+	// test-lib.sh reported checking, in verbose output.
+	satisfied   []string
+	unsatisfied []string
+}
+
+// appendNew appends s to list unless it is there already.
+func appendNew(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+var missingRE = regexp.MustCompile(`\(missing ([^)]*)\)`)
+
+func (t *tapResult) addMissing(s string) {
+	// git says "(missing A,B of A,B,C)"
+	if i := strings.Index(s, " of "); i >= 0 {
+		s = s[:i]
+	}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		t.missing = appendNew(t.missing, p)
+	}
+}
+
+// directive returns the text after "# " in a TAP line, in lower case,
+// and the text following the directive keyword.
+func directive(line string) (string, string) {
+	i := strings.Index(line, "#")
+	if i < 0 {
+		return "", ""
+	}
+	d := strings.TrimSpace(line[i+1:])
+	fields := strings.SplitN(d, " ", 2)
+	rest := ""
+	if len(fields) == 2 {
+		rest = strings.TrimSpace(fields[1])
+	}
+	return strings.ToLower(fields[0]), rest
+}
+
+func parseTAP(out []byte) *tapResult {
+	t := &tapResult{}
+	for _, l := range bytes.Split(out, []byte("\n")) {
+		t.parseLine(string(l))
+	}
+	t.finish()
+	return t
+}
+
+// parseLine adds a line of TAP output.
+func (t *tapResult) parseLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	switch {
+	case strings.HasPrefix(line, "ok "):
+		if d, _ := directive(line); d == "skip" {
+			t.skipped++
+			if m := missingRE.FindStringSubmatch(line); m != nil {
+				t.addMissing(m[1])
+			}
+			t.skippedCase(line)
+		} else {
+			t.passed++
+		}
+	case strings.HasPrefix(line, "not ok "):
+		if d, _ := directive(line); d == "todo" {
+			t.todo++
+		} else {
+			t.failed++
+		}
+	case strings.HasPrefix(line, "1.."):
+		plan := line[3:]
+		if i := strings.IndexAny(plan, " #"); i >= 0 {
+			plan = plan[:i]
+		}
+		n, err := strconv.Atoi(plan)
+		if err != nil {
+			return
+		}
+		t.planned = n
+		t.hasPlan = true
+		if d, rest := directive(line); d == "skip" {
+			t.skipAll = rest
+		}
+	case strings.HasPrefix(line, "skipped: "):
+		t.skipAll = strings.TrimSpace(line[len("skipped: "):])
+	case strings.HasPrefix(line, "prerequisite "):
+		// "prerequisite GPG ok" or "... not satisfied".
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 {
+			return
+		}
+		switch fields[2] {
+		case "ok":
+			t.satisfied = appendNew(t.satisfied, fields[1])
+		case "not satisfied":
+			t.unsatisfied = appendNew(t.unsatisfied, fields[1])
+		}
+	}
+}
+
+// finish is called after the last line.
+func (t *tapResult) finish() {
+	if t.skipAll != "" {
+		if m := missingRE.FindStringSubmatch(t.skipAll); m != nil {
+			t.addMissing(m[1])
+		}
+		t.skippedScript(t.skipAll)
+	}
+}
+
+// skippedAll returns true if the script did not execute any test,
+// typically because of missing prerequisites.
+func (t *tapResult) skippedAll() bool {
+	if t.failed > 0 || t.passed > 0 || t.todo > 0 {
+		return false
+	}
+	return t.skipped > 0 || (t.hasPlan && t.planned == 0)
+}
+
+// count returns the number of test results seen.
+func (t *tapResult) count() int {
+	return t.passed + t.failed + t.skipped + t.todo
+}
+
+// checkPlan returns a description of the problem if the plan is
+// missing or does not match the results, or "" if it is OK.
+func (t *tapResult) checkPlan() string {
+	if !t.hasPlan {
+		return fmt.Sprintf("no plan, %d results", t.count())
+	}
+	if t.planned != t.count() {
+		return fmt.Sprintf("planned %d, got %d results", t.planned, t.count())
+	}
+	return ""
+}
+
+const maxExcerptLines = 40
+
+// failureExcerpt returns the failing test cases along with the
+// commands git prints for them, or the tail of the output if there
+// are none.
+func failureExcerpt(stdout, stderr []byte) string {
+	var lines []string
+	inFailure := false
+	for _, l := range strings.Split(string(stdout), "\n") {
+		if strings.HasPrefix(l, "not ok ") {
+			if d, _ := directive(l); d != "todo" {
+				inFailure = true
+				lines = append(lines, l)
+				continue
+			}
+		}
+		if inFailure && strings.HasPrefix(l, "#\t") {
+			lines = append(lines, l)
+			continue
+		}
+		inFailure = false
+	}
+	if len(lines) == 0 {
+		out := strings.TrimRight(string(stdout), "\n")
+		if errOut := strings.TrimRight(string(stderr), "\n"); errOut != "" {
+			out += "\n" + errOut
+		}
+		lines = strings.Split(out, "\n")
+	}
+	if len(lines) > maxExcerptLines {
+		lines = append([]string{"..."}, lines[len(lines)-maxExcerptLines:]...)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDirective(t *testing.T) {
+	for _, c := range []struct {
+		line, directive, rest string
+	}{
+		{"ok 1 - works", "", ""},
+		{"ok 2 # skip missing GPG", "skip", "missing GPG"},
+		{"ok 3 # SKIP", "skip", ""},
+		{"not ok 4 - broken # TODO known breakage", "todo", "known breakage"},
+		{"1..0 # SKIP no svn", "skip", "no svn"},
+		{"ok 5 #   skip   spaced out  ", "skip", "spaced out"},
+	} {
+		d, rest := directive(c.line)
+		if d != c.directive || rest != c.rest {
+			t.Errorf("directive(%q) = %q, %q, want %q, %q", c.line, d, rest, c.directive, c.rest)
+		}
+	}
+}
+
+func TestParseTAP(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		out  string
+
+		passed, failed, skipped, todo int
+		skipAll                       string
+		missing                       []string
+		plan                          string
+		skippedAll                    bool
+	}{
+		{
+			name:   "pass",
+			out:    "ok 1 - a\nok 2 - b\n1..2\n",
+			passed: 2,
+		},
+		{
+			name:    "mixed",
+			out:     "ok 1 - a\nnot ok 2 - b\nnot ok 3 - c # TODO later\nok 4 # skip d (missing GPG,TTY of GPG,TTY,PERL)\n1..4\n",
+			passed:  1,
+			failed:  1,
+			todo:    1,
+			skipped: 1,
+			missing: []string{"GPG", "TTY"},
+		},
+		{
+			name:       "skip all",
+			out:        "1..0 # SKIP skipping svn tests (missing SVN)\n",
+			skipAll:    "skipping svn tests (missing SVN)",
+			missing:    []string{"SVN"},
+			skippedAll: true,
+		},
+		{
+			name:   "no plan",
+			out:    "ok 1 - a\n",
+			passed: 1,
+			plan:   "no plan, 1 results",
+		},
+		{
+			name:   "short plan",
+			out:    "ok 1 - a\r\n1..3\r\n",
+			passed: 1,
+			plan:   "planned 3, got 1 results",
+		},
+	} {
+		tap := parseTAP([]byte(c.out))
+		got := []int{tap.passed, tap.failed, tap.skipped, tap.todo}
+		if want := []int{c.passed, c.failed, c.skipped, c.todo}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: passed, failed, skipped, todo = %v, want %v", c.name, got, want)
+		}
+		if tap.skipAll != c.skipAll {
+			t.Errorf("%s: skipAll = %q, want %q", c.name, tap.skipAll, c.skipAll)
+		}
+		if !reflect.DeepEqual(tap.missing, c.missing) {
+			t.Errorf("%s: missing = %q, want %q", c.name, tap.missing, c.missing)
+		}
+		if p := tap.checkPlan(); p != c.plan {
+			t.Errorf("%s: checkPlan() = %q, want %q", c.name, p, c.plan)
+		}
+		if s := tap.skippedAll(); s != c.skippedAll {
+			t.Errorf("%s: skippedAll() = %v, want %v", c.name, s, c.skippedAll)
+		}
+	}
+}
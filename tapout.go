@@ -0,0 +1,125 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// tapWriter combines the TAP output of all tests into one stream,
+// renumbering the test points and prefixing their descriptions with
+// the test. The plan comes at the end, once the total is known.
+type tapWriter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+	n  int
+}
+
+func newTapWriter(path string) (*tapWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &tapWriter{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// point writes the next test point.
+func (t *tapWriter) point(ok bool, desc string) {
+	t.n++
+	if ok {
+		fmt.Fprintf(t.w, "ok %d - %s\n", t.n, desc)
+	} else {
+		fmt.Fprintf(t.w, "not ok %d - %s\n", t.n, desc)
+	}
+}
+
+// add appends the TAP output of a test. Failures that do not show in
+// the TAP output, like a non-zero exit or a bad plan, are added as a
+// failing test point.
+func (t *tapWriter) add(r *result, stdout []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prefix := r.label()
+	if r.attempt > 0 {
+		prefix = fmt.Sprintf("%s (retry %d)", prefix, r.attempt)
+	}
+	tapFailures := 0
+	for _, l := range bytes.Split(stdout, []byte("\n")) {
+		line := strings.TrimRight(string(l), "\r")
+		var ok bool
+		var rest string
+		switch {
+		case strings.HasPrefix(line, "ok "):
+			ok, rest = true, line[len("ok "):]
+		case strings.HasPrefix(line, "not ok "):
+			ok, rest = false, line[len("not ok "):]
+		case strings.HasPrefix(line, "1..0") && r.status == statusSkipped:
+			desc := prefix
+			if d, reason := directive(line); d == "skip" {
+				desc += " # SKIP " + reason
+			} else {
+				desc += " # SKIP"
+			}
+			t.point(true, desc)
+			continue
+		case strings.HasPrefix(line, "#"):
+			fmt.Fprintf(t.w, "# %s:%s\n", prefix, line[1:])
+			continue
+		default:
+			// Plans and anything that is not TAP.
+			continue
+		}
+		// Drop the number and the dash.
+		if i := strings.IndexFunc(rest, func(c rune) bool { return c < '0' || c > '9' }); i > 0 {
+			rest = rest[i:]
+		}
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), "- ")
+		if !ok {
+			if d, _ := directive(rest); d != "todo" {
+				tapFailures++
+			}
+		}
+		t.point(ok, prefix+": "+rest)
+	}
+	if r.failed() && tapFailures == 0 || r.status == statusOOM || r.status == statusCancelled {
+		t.point(false, prefix+": "+r.summary)
+	}
+	if r.known() {
+		fmt.Fprintf(t.w, "# %s: %s\n", prefix, r.knownText())
+	}
+}
+
+// finish writes the plan.
+func (t *tapWriter) finish() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "1..%d\n", t.n)
+	return t.w.Flush()
+}
+
+// close writes the plan and closes the file.
+func (t *tapWriter) close() error {
+	if err := t.finish(); err != nil {
+		t.f.Close()
+		return err
+	}
+	return t.f.Close()
+}
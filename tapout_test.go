@@ -0,0 +1,42 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestTapWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &tapWriter{w: bufio.NewWriter(&buf)}
+	w.add(&result{name: "t0001-ok.sh", status: statusOK}, []byte("ok 1 - a\n1..1\n"))
+	w.add(&result{name: "t0002-oom.sh", status: statusOOM, summary: "oom: Killed"}, []byte("ok 1 - a\n"))
+	w.add(&result{name: "t0003-plan.sh", status: statusBadPlan, summary: "bad-plan: planned 2, got 1 results"}, []byte("ok 1 - a\n1..2\n"))
+	if err := w.finish(); err != nil {
+		t.Fatal(err)
+	}
+	want := `ok 1 - t0001-ok.sh: a
+ok 2 - t0002-oom.sh: a
+not ok 3 - t0002-oom.sh: oom: Killed
+ok 4 - t0003-plan.sh: a
+not ok 5 - t0003-plan.sh: bad-plan: planned 2, got 1 results
+1..5
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
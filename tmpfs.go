@@ -0,0 +1,125 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+)
+
+// tmpfsEnv names the mount point of the --tmpfs-root in the child
+// running the tests. The leading underscore keeps it apart from the
+// RUNGITTEST_<FLAG> defaults, so it is never taken for --tmpfs-root.
+const tmpfsEnv = "_RUNGITTEST_TMPFS_MOUNT"
+
+// runInTmpfs reruns this command in a child process with a tmpfs of
+// the given size as the test root. The child gets a private mount
+// namespace, so the tmpfs goes away with it. Without one, root mounts
+// the tmpfs itself and unmounts it once the child is done.
+func runInTmpfs(size int64, dir string) int {
+	mnt, err := os.MkdirTemp("", "rungittest-tmpfs-")
+	if err != nil {
+		fatalf("--tmpfs-root: %v", err)
+	}
+	defer os.Remove(mnt)
+	self, err := os.Executable()
+	if err != nil {
+		fatalf("--tmpfs-root: %v", err)
+	}
+	child := func() *exec.Cmd {
+		cmd := exec.Command(self, os.Args[1:]...)
+		cmd.Dir = dir
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.Env = append(os.Environ(), tmpfsEnv+"="+mnt)
+		return cmd
+	}
+
+	cmd := child()
+	cmd.SysProcAttr, err = namespaceAttr()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		if os.Geteuid() != 0 {
+			fatalf("--tmpfs-root: cannot create a mount namespace: %v", err)
+		}
+		log.Printf("--tmpfs-root: no mount namespace (%v), mounting on %s", err, mnt)
+		if err := mountTmpfs(mnt, size); err != nil {
+			fatalf("--tmpfs-root: %v", err)
+		}
+		defer func() {
+			if err := unmountTmpfs(mnt); err != nil {
+				log.Printf("--tmpfs-root: %v", err)
+			}
+		}()
+		cmd = child()
+		if err := cmd.Start(); err != nil {
+			fatalf("--tmpfs-root: %v", err)
+		}
+	}
+
+	// The child shares our process group, so it gets ^C from the
+	// terminal itself.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, append([]os.Signal{os.Interrupt}, forwardSignals...)...)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			if sig != os.Interrupt {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+	if err := cmd.Wait(); err != nil {
+		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() >= 0 {
+			return e.ExitCode()
+		}
+		log.Printf("--tmpfs-root: %v", err)
+		return exitInfra
+	}
+	return exitOK
+}
+
+// useTmpfs makes the tmpfs at dir the test root of this run, mounting
+// it first if needed. It does not return if the process has to
+// restart to drop the privileges the mount needed.
+func useTmpfs(dir string, size int64) error {
+	if !isTmpfs(dir) {
+		if err := privateMounts(); err != nil {
+			return err
+		}
+		if err := mountTmpfs(dir, size); err != nil {
+			return err
+		}
+		if os.Geteuid() != 0 {
+			return execWithoutCaps()
+		}
+	}
+	// test-lib.sh uses the last --root it is given.
+	opts := strings.TrimSpace(os.Getenv("GIT_TEST_OPTS") + " --root=" + dir)
+	if err := os.Setenv("GIT_TEST_OPTS", opts); err != nil {
+		return err
+	}
+	log.Printf("--tmpfs-root: using a %s tmpfs on %s", formatSize(size), dir)
+	return nil
+}
+
+func tmpfsOptions(size int64) string {
+	return fmt.Sprintf("size=%d,mode=0700", size)
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+const (
+	tmpfsMagic = 0x01021994
+
+	capSysAdmin = 21
+
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+)
+
+// namespaceAttr runs a child in a new mount namespace. For users other
+// than root, it also gets a user namespace in which it keeps its uid,
+// but may mount file systems.
+func namespaceAttr() (*syscall.SysProcAttr, error) {
+	if os.Geteuid() == 0 {
+		return &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}, nil
+	}
+	return &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWNS | syscall.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: os.Geteuid(), HostID: os.Geteuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getegid(), HostID: os.Getegid(), Size: 1}},
+		AmbientCaps: []uintptr{capSysAdmin},
+	}, nil
+}
+
+// privateMounts stops our mounts from propagating to the parent
+// namespace.
+func privateMounts() error {
+	return syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+}
+
+func mountTmpfs(dir string, size int64) error {
+	return syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, tmpfsOptions(size))
+}
+
+func unmountTmpfs(dir string) error {
+	return syscall.Unmount(dir, 0)
+}
+
+func isTmpfs(dir string) bool {
+	var st syscall.Statfs_t
+	return syscall.Statfs(dir, &st) == nil && st.Type == tmpfsMagic
+}
+
+// execWithoutCaps restarts this program without the ambient
+// capabilities namespaceAttr gave it, so the tests don't inherit them.
+// Capabilities are per thread, so the clearing thread must do the
+// exec.
+func execWithoutCaps() error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if _, _, e := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); e != 0 {
+		return e
+	}
+	return syscall.Exec(self, os.Args, os.Environ())
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import "syscall"
+
+func namespaceAttr() (*syscall.SysProcAttr, error) {
+	return nil, errNotSupported
+}
+
+func privateMounts() error {
+	return errNotSupported
+}
+
+func mountTmpfs(dir string, size int64) error {
+	return errNotSupported
+}
+
+func unmountTmpfs(dir string) error {
+	return errNotSupported
+}
+
+func isTmpfs(dir string) bool {
+	return false
+}
+
+func execWithoutCaps() error {
+	return errNotSupported
+}
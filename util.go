@@ -0,0 +1,114 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var errNotSupported = errors.New("not supported on this platform")
+
+// Exit statuses of a run.
+const (
+	exitOK        = 0 // all tests passed
+	exitFailures  = 1 // tests failed
+	exitInfra     = 2 // the run could not be done, eg. bad flags or a full disk
+	exitTimeout   = 3 // the run was stopped by --deadline
+	exitCancelled = 4 // the run was stopped by Ctrl-C or "ctl cancel-run"
+)
+
+// fatalf logs the error and exits with exitInfra.
+func fatalf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	os.Exit(exitInfra)
+}
+
+// sizeFlag is a flag.Value for byte counts such as "512M" or "2G".
+type sizeFlag int64
+
+func (s *sizeFlag) String() string {
+	return formatSize(int64(*s))
+}
+
+func (s *sizeFlag) Set(v string) error {
+	n, err := parseSize(v)
+	if err != nil {
+		return err
+	}
+	*s = sizeFlag(n)
+	return nil
+}
+
+// listFlag is a flag.Value collecting the values of a flag given more
+// than once.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// isTerminal returns true if f is a terminal that handles "\r", ie.
+// not redirected to a file or pipe, and not a dumb terminal.
+func isTerminal(f *os.File) bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+var sizeSuffixes = "KMGT"
+
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	mult := int64(1)
+	if s != "" {
+		if i := strings.IndexByte(sizeSuffixes, s[len(s)-1]); i >= 0 {
+			mult = 1 << (10 * uint(i+1))
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d", n)
+	}
+	f := float64(n)
+	suffix := ""
+	for _, c := range sizeSuffixes {
+		if f < 1024 {
+			break
+		}
+		f /= 1024
+		suffix = string(c)
+	}
+	return strings.TrimSuffix(strconv.FormatFloat(f, 'f', 1, 64), ".0") + suffix
+}
@@ -0,0 +1,209 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// watchInterval is how often --watch checks the files for changes.
+const watchInterval = time.Second
+
+// watchShared returns the files besides the scripts that the tests
+// depend on: the test libraries and the build outputs, relative to
+// the t/ directory.
+func watchShared() []string {
+	files := []string{"../git", "../bin-wrappers/git", "helper/test-tool"}
+	for _, g := range []string{"test-lib*.sh", "lib-*.sh"} {
+		m, _ := filepath.Glob(g)
+		files = append(files, m...)
+	}
+	return files
+}
+
+// mtimes returns the modification times of the files that exist.
+func mtimes(files []string) map[string]time.Time {
+	m := map[string]time.Time{}
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			m[f] = fi.ModTime()
+		}
+	}
+	return m
+}
+
+// changed returns the files whose modification time differs, or
+// which appeared or vanished.
+func changed(old, cur map[string]time.Time) []string {
+	var files []string
+	for f, t := range cur {
+		if o, ok := old[f]; !ok || !o.Equal(t) {
+			files = append(files, f)
+		}
+	}
+	for f := range old {
+		if _, ok := cur[f]; !ok {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// childArgs returns the flags given to this run, minus the given ones
+// that only matter to the parent, for running the tests in a child
+// process.
+func childArgs(fs *flag.FlagSet, outdir string, drop ...string) []string {
+	args := []string{"--outdir=" + outdir}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "chdir", "outdir", "select":
+			// The tests to run are given as arguments.
+			return
+		}
+		for _, d := range drop {
+			if f.Name == d {
+				return
+			}
+		}
+		if l, ok := f.Value.(*listFlag); ok {
+			for _, v := range *l {
+				args = append(args, "--"+f.Name+"="+v)
+			}
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+// watchTests runs the tests, and then keeps rerunning them as files
+// change: edited scripts are rerun, and a change to the test
+// libraries or the build reruns the failing tests, or all of them if
+// none failed. Each run is a child process writing to outdir.
+func watchTests(args, scripts []string, outdir string) int {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	shared := watchShared()
+	watched := append(append([]string{}, scripts...), shared...)
+	isShared := map[string]bool{}
+	for _, f := range shared {
+		isShared[f] = true
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		fatalf("--watch: %v", err)
+	}
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "RUNGITTEST_WATCH=") {
+			env = append(env, e)
+		}
+	}
+
+	failing := map[string]bool{}
+	run := scripts
+	for {
+		cmd := exec.Command(self, append(append([]string{}, args...), run...)...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.Env = env
+		before := mtimes(watched)
+		if err := cmd.Run(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				fatalf("--watch: %v", err)
+			}
+		}
+		select {
+		case <-sigs:
+			return exitCancelled
+		default:
+		}
+		if rr, err := readResults(outdir); err != nil {
+			log.Printf("--watch: %v", err)
+		} else {
+			variants := map[string]*variant{}
+			for i := range rr.Tests {
+				failing[rr.Tests[i].Name] = false
+			}
+			for i := range rr.Tests {
+				if r := rr.Tests[i].result(variants); r.failed() {
+					failing[r.name] = true
+				}
+			}
+		}
+
+		var files []string
+		fmt.Printf("--watch: waiting for changes to %d files, Ctrl-C to stop\n", len(before))
+		for len(files) == 0 {
+			select {
+			case <-sigs:
+				return exitOK
+			case <-time.After(watchInterval):
+			}
+			files = changed(before, mtimes(watched))
+		}
+		// Let builds and editors finish writing.
+		for cur := mtimes(watched); ; {
+			time.Sleep(watchInterval)
+			next := mtimes(watched)
+			if len(changed(cur, next)) == 0 {
+				files = changed(before, next)
+				break
+			}
+			cur = next
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		run = nil
+		sharedChanged := false
+		for _, f := range files {
+			if isShared[f] {
+				sharedChanged = true
+			} else {
+				run = append(run, f)
+			}
+		}
+		if sharedChanged {
+			sel := map[string]bool{}
+			for _, f := range run {
+				sel[f] = true
+			}
+			for _, s := range scripts {
+				if failing[s] && !sel[s] {
+					run = append(run, s)
+				}
+			}
+			if len(run) == 0 {
+				run = scripts
+			}
+		}
+		fmt.Printf("--watch: %s changed, running %d tests\n", strings.Join(files, ", "), len(run))
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2009 Alphabet Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// weight says that tests matching pattern take the given number of
+// worker slots.
+type weight struct {
+	pattern string
+	slots   int
+}
+
+// readWeights reads a file with lines such as
+//
+//	t5616 4
+//	t92* 2
+//
+// Empty lines and lines starting with '#' are ignored.
+func readWeights(path string) ([]weight, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ws []weight
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"PATTERN SLOTS\"", path, n)
+		}
+		k, err := strconv.Atoi(fields[1])
+		if err != nil || k < 1 {
+			return nil, fmt.Errorf("%s:%d: bad slot count %q", path, n, fields[1])
+		}
+		ws = append(ws, weight{pattern: fields[0], slots: k})
+	}
+	return ws, scanner.Err()
+}
+
+// slotsFor returns the number of worker slots j takes: that of the
+// first matching pattern, or 1.
+func slotsFor(ws []weight, j *job) int {
+	for _, w := range ws {
+		if matches(j, w.pattern) {
+			return w.slots
+		}
+	}
+	return 1
+}